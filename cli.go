@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"your-project/handlers"
+)
+
+// runImportCommand implements `import [-dry-run] [-facilitator user] file...`.
+// It returns the process exit code.
+func runImportCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "validate the files without writing to the database")
+	facilitator := fs.String("facilitator", "", "GitHub username of the user who facilitates the imported sessions (required unless -dry-run)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: import [-dry-run] [-facilitator user] file.json|file.md|archive.zip ...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || (*facilitator == "" && !*dryRun) {
+		fs.Usage()
+		return 2
+	}
+
+	facilitatorID := 0
+	if *facilitator != "" {
+		var err error
+		if facilitatorID, err = handlers.ImportFacilitator(context.Background(), *facilitator); err != nil {
+			fmt.Fprintf(os.Stderr, "-facilitator: %v\n", err)
+			return 2
+		}
+	}
+
+	exitCode := 0
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	for _, name := range fs.Args() {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			exitCode = 1
+			continue
+		}
+
+		report, err := handlers.ImportArchive(context.Background(), name, data, *dryRun, facilitatorID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			exitCode = 1
+			continue
+		}
+		if report.Failed > 0 {
+			exitCode = 1
+		}
+		encoder.Encode(report)
+	}
+	return exitCode
+}
@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var actionItemCollection *mongo.Collection
+
+func InitActionItemCollection(client *mongo.Client) {
+	actionItemCollection = client.Database("your-db-name").Collection("action_items")
+}
+
+// actionItemLinePattern matches task lines such as "- [ ] @alice send the slides".
+// Lines that have an action item end with its marker, see actionItemMarker.
+var actionItemLinePattern = regexp.MustCompile(`^(\s*[-*] \[([ xX])\] @(\S+)\s+(.+?))(?:\s*<!-- action-item:([0-9a-f]{24}) -->)?\s*$`)
+
+// actionItemMarker links a task line to its action item, so the item is
+// found again after the line is edited. It is an HTML comment and does not
+// show when the minutes are rendered.
+func actionItemMarker(id primitive.ObjectID) string {
+	return "<!-- action-item:" + id.Hex() + " -->"
+}
+
+var actionItemMarkerPattern = regexp.MustCompile(` ?<!-- action-item:[0-9a-f]{24} -->`)
+
+// stripActionItemMarkers removes the markers from minutes that are shown
+// outside the editor, or imported into a session with other action items
+func stripActionItemMarkers(content string) string {
+	return actionItemMarkerPattern.ReplaceAllString(content, "")
+}
+
+// resolveAssignee finds the session participant with the given username
+func resolveAssignee(session models.Session, username string) (models.Participant, bool) {
+	for _, participant := range session.Participants {
+		if strings.EqualFold(participant.Username, username) {
+			return participant, true
+		}
+	}
+	return models.Participant{}, false
+}
+
+func broadcastActionItem(eventType string, item models.ActionItem) {
+	Broadcast(item.SessionID.Hex(), map[string]interface{}{
+		"type":       eventType,
+		"actionItem": item,
+	})
+}
+
+// syncActionItemsFromMinutes creates action items for new "- [ ] @user task"
+// lines in the minutes, renames items whose line was edited and completes
+// open items whose line was ticked off. New items get their marker written
+// into the line; the minutes are returned with the markers, and stored with
+// them unless they were changed in the meantime.
+func syncActionItemsFromMinutes(ctx context.Context, sessionID primitive.ObjectID, content string) string {
+	var session models.Session
+	if err := sessionCollection.FindOne(ctx, notDeleted(bson.M{"_id": sessionID})).Decode(&session); err != nil {
+		logFor(ctx).Error("Failed to load session for action items", "session_id", sessionID.Hex(), "error", err)
+		return content
+	}
+
+	lines := strings.Split(content, "\n")
+	// 已有标记的条目不会再被按标题认领
+	claimed := make(map[primitive.ObjectID]bool)
+	for _, line := range lines {
+		if m := actionItemLinePattern.FindStringSubmatch(line); m != nil && m[5] != "" {
+			if id, err := primitive.ObjectIDFromHex(m[5]); err == nil {
+				claimed[id] = true
+			}
+		}
+	}
+
+	marked := false
+	for i, line := range lines {
+		m := actionItemLinePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		done := m[2] != " "
+
+		item := models.ActionItem{
+			SessionID: sessionID,
+			Title:     m[4],
+			Status:    models.ActionItemOpen,
+			Source:    "minutes",
+		}
+		if participant, ok := resolveAssignee(session, m[3]); ok {
+			item.AssigneeID = participant.ID
+			item.AssigneeUsername = participant.Username
+		} else {
+			// 不是参与者时保留原始文本，任务不丢失
+			item.Title = "@" + m[3] + " " + m[4]
+		}
+
+		var existing models.ActionItem
+		var err error
+		if m[5] != "" {
+			id, _ := primitive.ObjectIDFromHex(m[5])
+			err = actionItemCollection.FindOne(ctx, bson.M{"_id": id, "session_id": sessionID}).Decode(&existing)
+			if err == mongo.ErrNoDocuments {
+				// 条目已被删除，不再从纪要中创建
+				continue
+			}
+		} else {
+			// 加标记之前创建的条目按标题找回
+			err = actionItemCollection.FindOne(ctx, bson.M{
+				"session_id": sessionID,
+				"title":      item.Title,
+				"_id":        bson.M{"$nin": claimedIDs(claimed)},
+			}).Decode(&existing)
+		}
+		if err != nil && err != mongo.ErrNoDocuments {
+			logFor(ctx).Error("Failed to look up action item", "session_id", sessionID.Hex(), "title", item.Title, "error", err)
+			continue
+		}
+
+		now := time.Now()
+		if err == mongo.ErrNoDocuments {
+			if done {
+				continue
+			}
+			item.ID = primitive.NewObjectID()
+			item.CreatedAt = now
+			item.UpdatedAt = now
+			if _, err := actionItemCollection.InsertOne(ctx, item); err != nil {
+				logFor(ctx).Error("Failed to create action item from minutes", "session_id", sessionID.Hex(), "error", err)
+				continue
+			}
+			broadcastActionItem("actionItemCreated", item)
+			notifyActionItemAssigned(ctx, item)
+			existing = item
+		}
+		if m[5] == "" {
+			claimed[existing.ID] = true
+			lines[i] = m[1] + " " + actionItemMarker(existing.ID)
+			marked = true
+		}
+
+		if existing.Title != item.Title && existing.Status != models.ActionItemDone {
+			existing.Title = item.Title
+			existing.UpdatedAt = now
+			_, err := actionItemCollection.UpdateOne(ctx, bson.M{"_id": existing.ID}, bson.M{
+				"$set": bson.M{"title": existing.Title, "updated_at": now},
+			})
+			if err != nil {
+				logFor(ctx).Error("Failed to rename action item from minutes", "action_item_id", existing.ID.Hex(), "error", err)
+				continue
+			}
+			broadcastActionItem("actionItemUpdated", existing)
+		}
+
+		if done && existing.Status != models.ActionItemDone {
+			existing.Status = models.ActionItemDone
+			existing.UpdatedAt = now
+			existing.CompletedAt = &now
+			_, err := actionItemCollection.UpdateOne(ctx, bson.M{"_id": existing.ID}, bson.M{
+				"$set": bson.M{"status": existing.Status, "updated_at": now, "completed_at": now},
+			})
+			if err != nil {
+				logFor(ctx).Error("Failed to complete action item from minutes", "action_item_id", existing.ID.Hex(), "error", err)
+				continue
+			}
+			broadcastActionItem("actionItemCompleted", existing)
+		}
+	}
+	if !marked {
+		return content
+	}
+
+	// 只在纪要没有被再次修改时写回标记
+	withMarkers := strings.Join(lines, "\n")
+	result, err := minutesCollection.UpdateOne(ctx, bson.M{"session_id": sessionID, "content": content},
+		bson.M{"$set": bson.M{"content": withMarkers}})
+	if err != nil {
+		logFor(ctx).Error("Failed to mark action items in the minutes", "session_id", sessionID.Hex(), "error", err)
+		return content
+	}
+	if result.MatchedCount == 0 {
+		return content
+	}
+	return withMarkers
+}
+
+func claimedIDs(claimed map[primitive.ObjectID]bool) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(claimed))
+	for id := range claimed {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// GetActionItemsHandler lists the action items of a session
+func GetActionItemsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	objectID, err := primitive.ObjectIDFromHex(vars["sessionId"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{"session_id": objectID}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := actionItemCollection.Find(r.Context(), filter, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch action items", http.StatusInternalServerError)
+		return
+	}
+	items := []models.ActionItem{}
+	if err := cursor.All(r.Context(), &items); err != nil {
+		http.Error(w, "Failed to parse action items", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+type actionItemInput struct {
+	Title    *string `json:"title"`
+	Assignee *string `json:"assignee"` // participant username, empty to unassign
+	DueDate  *string `json:"due_date"` // YYYY-MM-DD or RFC 3339, empty to clear
+	Status   *string `json:"status"`
+}
+
+// apply validates the input and copies it onto item
+func (input actionItemInput) apply(session models.Session, item *models.ActionItem) string {
+	if input.Title != nil {
+		item.Title = strings.TrimSpace(*input.Title)
+	}
+	if item.Title == "" {
+		return "Title is required"
+	}
+
+	if input.Assignee != nil {
+		item.AssigneeID = primitive.NilObjectID
+		item.AssigneeUsername = ""
+		if username := strings.TrimPrefix(strings.TrimSpace(*input.Assignee), "@"); username != "" {
+			participant, ok := resolveAssignee(session, username)
+			if !ok {
+				return "Assignee must be a participant of the session"
+			}
+			item.AssigneeID = participant.ID
+			item.AssigneeUsername = participant.Username
+		}
+	}
+
+	if input.DueDate != nil {
+		item.DueDate = nil
+		if *input.DueDate != "" {
+			due, err := parseDateTime(*input.DueDate)
+			if err != nil {
+				return "Invalid due date"
+			}
+			item.DueDate = &due
+		}
+	}
+
+	if input.Status != nil {
+		switch *input.Status {
+		case models.ActionItemOpen:
+			item.CompletedAt = nil
+		case models.ActionItemDone:
+			if item.Status != models.ActionItemDone {
+				now := time.Now()
+				item.CompletedAt = &now
+			}
+		default:
+			return "Status must be open or done"
+		}
+		item.Status = *input.Status
+	}
+	return ""
+}
+
+// CreateActionItemHandler adds an action item to a session
+func CreateActionItemHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentUser(r); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	objectID, err := primitive.ObjectIDFromHex(vars["sessionId"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	var input actionItemInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var session models.Session
+	if err := sessionCollection.FindOne(r.Context(), notDeleted(bson.M{"_id": objectID})).Decode(&session); err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	item := models.ActionItem{
+		ID:        primitive.NewObjectID(),
+		SessionID: objectID,
+		Status:    models.ActionItemOpen,
+		Source:    "manual",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if msg := input.apply(session, &item); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := actionItemCollection.InsertOne(r.Context(), item); err != nil {
+		logFor(r.Context()).Error("Failed to create action item", "error", err)
+		http.Error(w, "Failed to create action item", http.StatusInternalServerError)
+		return
+	}
+
+	broadcastActionItem("actionItemCreated", item)
+	notifyActionItemAssigned(r.Context(), item)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(item)
+}
+
+// UpdateActionItemHandler edits, reassigns or completes an action item
+func UpdateActionItemHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentUser(r); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	sessionObjectID, err := primitive.ObjectIDFromHex(vars["sessionId"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+	itemObjectID, err := primitive.ObjectIDFromHex(vars["itemId"])
+	if err != nil {
+		http.Error(w, "Invalid action item ID", http.StatusBadRequest)
+		return
+	}
+
+	var input actionItemInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var item models.ActionItem
+	err = actionItemCollection.FindOne(r.Context(), bson.M{"_id": itemObjectID, "session_id": sessionObjectID}).Decode(&item)
+	if err != nil {
+		http.Error(w, "Action item not found", http.StatusNotFound)
+		return
+	}
+
+	var session models.Session
+	if err := sessionCollection.FindOne(r.Context(), notDeleted(bson.M{"_id": sessionObjectID})).Decode(&session); err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	wasDone := item.Status == models.ActionItemDone
+	previousAssignee := item.AssigneeUsername
+	if msg := input.apply(session, &item); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	item.UpdatedAt = time.Now()
+
+	if _, err := actionItemCollection.ReplaceOne(r.Context(), bson.M{"_id": item.ID}, item); err != nil {
+		logFor(r.Context()).Error("Failed to update action item", "action_item_id", item.ID.Hex(), "error", err)
+		http.Error(w, "Failed to update action item", http.StatusInternalServerError)
+		return
+	}
+
+	if !wasDone && item.Status == models.ActionItemDone {
+		broadcastActionItem("actionItemCompleted", item)
+	} else {
+		broadcastActionItem("actionItemUpdated", item)
+	}
+	if item.AssigneeUsername != previousAssignee {
+		notifyActionItemAssigned(r.Context(), item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+// DeleteActionItemHandler removes an action item
+func DeleteActionItemHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentUser(r); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	sessionObjectID, err := primitive.ObjectIDFromHex(vars["sessionId"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+	itemObjectID, err := primitive.ObjectIDFromHex(vars["itemId"])
+	if err != nil {
+		http.Error(w, "Invalid action item ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := actionItemCollection.DeleteOne(r.Context(), bson.M{"_id": itemObjectID, "session_id": sessionObjectID})
+	if err != nil {
+		http.Error(w, "Failed to delete action item", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		http.Error(w, "Action item not found", http.StatusNotFound)
+		return
+	}
+
+	Broadcast(sessionObjectID.Hex(), map[string]interface{}{
+		"type":         "actionItemDeleted",
+		"actionItemId": itemObjectID.Hex(),
+	})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Action item deleted successfully"})
+}
+
+// MyActionItemsHandler lists the current user's action items across sessions.
+// Only open items are returned unless status=all or status=done is given.
+func MyActionItemsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter := bson.M{"assignee_username": user.Username}
+	switch status := r.URL.Query().Get("status"); status {
+	case "", models.ActionItemOpen:
+		filter["status"] = models.ActionItemOpen
+	case "all":
+	default:
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "due_date", Value: 1}, {Key: "created_at", Value: 1}})
+	cursor, err := actionItemCollection.Find(r.Context(), filter, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch action items", http.StatusInternalServerError)
+		return
+	}
+	items := []models.ActionItem{}
+	if err := cursor.All(r.Context(), &items); err != nil {
+		http.Error(w, "Failed to parse action items", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
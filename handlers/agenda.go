@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// scaffoldMinutes builds an empty minutes document with one section per
+// agenda item.
+func scaffoldMinutes(agenda []models.AgendaItem) string {
+	var b strings.Builder
+	for _, item := range agenda {
+		fmt.Fprintf(&b, "## %s\n\n", item.Title)
+		var meta []string
+		if item.Owner != "" {
+			meta = append(meta, "Owner: @"+item.Owner)
+		}
+		if item.AllottedMinutes > 0 {
+			meta = append(meta, fmt.Sprintf("%d min", item.AllottedMinutes))
+		}
+		if len(meta) > 0 {
+			fmt.Fprintf(&b, "_%s_\n\n", strings.Join(meta, " · "))
+		}
+		if item.Notes != "" {
+			b.WriteString(item.Notes)
+			b.WriteString("\n\n")
+		}
+	}
+	return b.String()
+}
+
+func broadcastAgenda(sessionID string, session models.Session) {
+	Broadcast(sessionID, map[string]interface{}{
+		"type":              "agendaUpdated",
+		"agenda":            session.Agenda,
+		"currentAgendaItem": session.CurrentAgendaItem,
+	})
+}
+
+// writeAgenda returns the updated agenda to the client and the room
+func writeAgenda(w http.ResponseWriter, sessionID string, session models.Session) {
+	broadcastAgenda(sessionID, session)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"agenda":              session.Agenda,
+		"current_agenda_item": session.CurrentAgendaItem,
+	})
+}
+
+type agendaItemInput struct {
+	Title           *string `json:"title"`
+	Owner           *string `json:"owner"`
+	AllottedMinutes *int    `json:"allotted_minutes"`
+	Notes           *string `json:"notes"`
+}
+
+// apply validates the input and copies it onto item
+func (input agendaItemInput) apply(session models.Session, item *models.AgendaItem) string {
+	if input.Title != nil {
+		item.Title = strings.TrimSpace(*input.Title)
+	}
+	if item.Title == "" {
+		return "Title is required"
+	}
+	if input.Owner != nil {
+		item.Owner = ""
+		if username := strings.TrimPrefix(strings.TrimSpace(*input.Owner), "@"); username != "" {
+			participant, ok := resolveAssignee(session, username)
+			if !ok {
+				return "Owner must be a participant of the session"
+			}
+			item.Owner = participant.Username
+		}
+	}
+	if input.AllottedMinutes != nil {
+		if *input.AllottedMinutes < 0 {
+			return "Allotted minutes cannot be negative"
+		}
+		item.AllottedMinutes = *input.AllottedMinutes
+	}
+	if input.Notes != nil {
+		item.Notes = *input.Notes
+	}
+	return ""
+}
+
+// loadAuthorizedSession parses the route and loads the session, writing an error
+// response on failure.
+func loadAuthorizedSession(w http.ResponseWriter, r *http.Request) (models.Session, bool) {
+	var session models.Session
+
+	authSession, err := store.Get(r, "auth-session")
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return session, false
+	}
+	if _, ok := authSession.Values["user_id"].(int); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return session, false
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(mux.Vars(r)["sessionId"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return session, false
+	}
+
+	if err := sessionCollection.FindOne(r.Context(), notDeleted(bson.M{"_id": objectID})).Decode(&session); err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return session, false
+	}
+	return session, true
+}
+
+func findAgendaItem(session models.Session, itemID string) int {
+	for i, item := range session.Agenda {
+		if item.ID.Hex() == itemID {
+			return i
+		}
+	}
+	return -1
+}
+
+// updateAgenda applies an update to the session and returns the session as
+// it is afterwards. Updates change single items through the arrayFilters in
+// opts, so concurrent edits of other items are kept. It returns
+// mongo.ErrNoDocuments when filter no longer matches.
+func updateAgenda(r *http.Request, filter, update interface{}, opts *options.FindOneAndUpdateOptions) (models.Session, error) {
+	var session models.Session
+	if opts == nil {
+		opts = options.FindOneAndUpdate()
+	}
+	opts.SetReturnDocument(options.After)
+	err := sessionCollection.FindOneAndUpdate(r.Context(), filter, update, opts).Decode(&session)
+	return session, err
+}
+
+// agendaItemFilter matches the item with the given ID in arrayFilters
+func agendaItemFilter(itemID primitive.ObjectID) *options.FindOneAndUpdateOptions {
+	return options.FindOneAndUpdate().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"i._id": itemID}},
+	})
+}
+
+// AddAgendaItemHandler appends an item to the agenda
+func AddAgendaItemHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+
+	var input agendaItemInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	item := models.AgendaItem{ID: primitive.NewObjectID()}
+	if msg := input.apply(session, &item); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := sessionCollection.FindOneAndUpdate(r.Context(),
+		bson.M{"_id": session.ID},
+		bson.M{"$push": bson.M{"agenda": item}},
+		opts,
+	).Decode(&session)
+	if err != nil {
+		logFor(r.Context()).Error("Failed to add agenda item", "error", err)
+		http.Error(w, "Failed to add agenda item", http.StatusInternalServerError)
+		return
+	}
+
+	writeAgenda(w, session.ID.Hex(), session)
+}
+
+// UpdateAgendaItemHandler edits the title, owner, timebox or notes of an item
+func UpdateAgendaItemHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+
+	index := findAgendaItem(session, mux.Vars(r)["itemId"])
+	if index < 0 {
+		http.Error(w, "Agenda item not found", http.StatusNotFound)
+		return
+	}
+
+	var input agendaItemInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	item := session.Agenda[index]
+	if msg := input.apply(session, &item); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// 只写入请求中给出的字段
+	set := bson.M{}
+	if input.Title != nil {
+		set["agenda.$[i].title"] = item.Title
+	}
+	if input.Owner != nil {
+		set["agenda.$[i].owner"] = item.Owner
+	}
+	if input.AllottedMinutes != nil {
+		set["agenda.$[i].allotted_minutes"] = item.AllottedMinutes
+	}
+	if input.Notes != nil {
+		set["agenda.$[i].notes"] = item.Notes
+	}
+	if len(set) == 0 {
+		writeAgenda(w, session.ID.Hex(), session)
+		return
+	}
+
+	session, err := updateAgenda(r, bson.M{"_id": session.ID, "agenda._id": item.ID},
+		bson.M{"$set": set}, agendaItemFilter(item.ID))
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Agenda item not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logFor(r.Context()).Error("Failed to update agenda item", "error", err)
+		http.Error(w, "Failed to update agenda item", http.StatusInternalServerError)
+		return
+	}
+
+	writeAgenda(w, session.ID.Hex(), session)
+}
+
+// ReorderAgendaHandler reorders the agenda. The body lists every item ID in
+// the new order. It fails with 409 when the agenda changed since it was read.
+func ReorderAgendaHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		ItemIDs []string `json:"item_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if len(input.ItemIDs) != len(session.Agenda) {
+		http.Error(w, "item_ids must list every agenda item exactly once", http.StatusBadRequest)
+		return
+	}
+
+	order := make(bson.A, 0, len(session.Agenda))
+	seen := make(map[string]bool)
+	for _, itemID := range input.ItemIDs {
+		index := findAgendaItem(session, itemID)
+		if index < 0 || seen[itemID] {
+			http.Error(w, "item_ids must list every agenda item exactly once", http.StatusBadRequest)
+			return
+		}
+		seen[itemID] = true
+		order = append(order, session.Agenda[index].ID)
+	}
+	read := make(bson.A, 0, len(session.Agenda))
+	for _, item := range session.Agenda {
+		read = append(read, item.ID)
+	}
+
+	// 只有议程仍是读到的顺序时才重排；按新的顺序取数据库中当前的条目，
+	// 不会覆盖同时对条目内容的修改
+	filter := bson.M{"_id": session.ID, "$expr": bson.M{"$eq": bson.A{"$agenda._id", read}}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"agenda": bson.M{"$map": bson.M{
+			"input": order,
+			"as":    "id",
+			"in": bson.M{"$arrayElemAt": bson.A{
+				bson.M{"$filter": bson.M{"input": "$agenda", "cond": bson.M{"$eq": bson.A{"$$this._id", "$$id"}}}},
+				0,
+			}},
+		}},
+	}}}}
+	session, err := updateAgenda(r, filter, update, nil)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "The agenda changed, reload it and try again", http.StatusConflict)
+		return
+	} else if err != nil {
+		logFor(r.Context()).Error("Failed to reorder agenda", "error", err)
+		http.Error(w, "Failed to reorder agenda", http.StatusInternalServerError)
+		return
+	}
+
+	writeAgenda(w, session.ID.Hex(), session)
+}
+
+// CompleteAgendaItemHandler marks an agenda item as done
+func CompleteAgendaItemHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+
+	index := findAgendaItem(session, mux.Vars(r)["itemId"])
+	if index < 0 {
+		http.Error(w, "Agenda item not found", http.StatusNotFound)
+		return
+	}
+
+	itemID := session.Agenda[index].ID
+	opts := options.FindOneAndUpdate().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"i._id": itemID, "i.completed": bson.M{"$ne": true}}},
+	})
+	session, err := updateAgenda(r, bson.M{"_id": session.ID, "agenda._id": itemID}, bson.M{
+		"$set": bson.M{"agenda.$[i].completed": true, "agenda.$[i].completed_at": time.Now()},
+	}, opts)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Agenda item not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logFor(r.Context()).Error("Failed to complete agenda item", "error", err)
+		http.Error(w, "Failed to complete agenda item", http.StatusInternalServerError)
+		return
+	}
+
+	writeAgenda(w, session.ID.Hex(), session)
+}
+
+// AdvanceAgendaHandler moves the current agenda item pointer. Only the
+// facilitator may call it. Without an item_id the current item is completed
+// and the next open item becomes current.
+func AdvanceAgendaHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+
+	authSession, _ := store.Get(r, "auth-session")
+	userID, _ := authSession.Values["user_id"].(int)
+	if !isFacilitator(session, userID) {
+		http.Error(w, "Only the facilitator can advance the agenda", http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		ItemID string `json:"item_id"`
+	}
+	// 请求体可以省略；分块传输时 ContentLength 是 -1，只能读了才知道
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && err != io.EOF {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	current := findAgendaItem(session, session.CurrentAgendaItem.Hex())
+	next := -1
+	completeCurrent := false
+	if input.ItemID != "" {
+		next = findAgendaItem(session, input.ItemID)
+		if next < 0 {
+			http.Error(w, "Agenda item not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		completeCurrent = current >= 0 && !session.Agenda[current].Completed
+		for i, item := range session.Agenda {
+			if !item.Completed && i != current {
+				next = i
+				break
+			}
+		}
+	}
+
+	// 只有当前条目仍是读到的那一个时才移动，避免两个标签页同时推进时跳过条目
+	filter := bson.M{"_id": session.ID, "current_agenda_item": session.CurrentAgendaItem}
+	if session.CurrentAgendaItem.IsZero() {
+		filter["current_agenda_item"] = bson.M{"$in": bson.A{nil, primitive.NilObjectID}}
+	}
+	set := bson.M{"current_agenda_item": primitive.NilObjectID}
+	if next >= 0 {
+		filter["agenda._id"] = session.Agenda[next].ID
+		set["current_agenda_item"] = session.Agenda[next].ID
+	}
+	var opts *options.FindOneAndUpdateOptions
+	if completeCurrent {
+		set["agenda.$[i].completed"] = true
+		set["agenda.$[i].completed_at"] = time.Now()
+		opts = options.FindOneAndUpdate().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"i._id": session.Agenda[current].ID, "i.completed": bson.M{"$ne": true}}},
+		})
+	}
+
+	session, err := updateAgenda(r, filter, bson.M{"$set": set}, opts)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "The agenda changed, reload it and try again", http.StatusConflict)
+		return
+	} else if err != nil {
+		logFor(r.Context()).Error("Failed to advance agenda", "error", err)
+		http.Error(w, "Failed to advance agenda", http.StatusInternalServerError)
+		return
+	}
+
+	var currentItem *models.AgendaItem
+	if index := findAgendaItem(session, session.CurrentAgendaItem.Hex()); index >= 0 && !session.CurrentAgendaItem.IsZero() {
+		currentItem = &session.Agenda[index]
+	}
+
+	Broadcast(session.ID.Hex(), map[string]interface{}{
+		"type": "agendaItemChanged",
+		"item": currentItem,
+	})
+	writeAgenda(w, session.ID.Hex(), session)
+}
@@ -3,11 +3,14 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"log"
 	"net/http"
 	"os"
+	"strings"
+	"your-project/models"
 
 	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/oauth2"
@@ -52,27 +55,33 @@ func SetClient(client *mongo.Client) {
 func GitHubCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	session, err := store.Get(r, "auth-session")
 	if err != nil {
-		log.Printf("Failed to get session: %v", err)
+		logFor(r.Context()).Error("Failed to get session", "error", err)
+		oauthLogins.WithLabelValues("failure").Inc()
 		http.Error(w, "Failed to get session", http.StatusInternalServerError)
 		return
 	}
 
 	code := r.URL.Query().Get("code")
 	if code == "" {
+		oauthLogins.WithLabelValues("failure").Inc()
 		http.Error(w, "Code not found", http.StatusBadRequest)
 		return
 	}
 
 	token, err := oauth.Exchange(context.Background(), code)
 	if err != nil {
+		logFor(r.Context()).Warn("Failed to exchange OAuth code", "error", err)
+		oauthLogins.WithLabelValues("failure").Inc()
 		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
 		return
 	}
 
 	// Get user information from GitHub
 	client := oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(token))
-	resp, err := client.Get("https://api.github.com/user")
+	resp, err := client.Get(githubAPIURL() + "/user")
 	if err != nil {
+		logFor(r.Context()).Warn("Failed to get GitHub user", "error", err)
+		oauthLogins.WithLabelValues("failure").Inc()
 		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
 		return
 	}
@@ -87,6 +96,7 @@ func GitHubCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		oauthLogins.WithLabelValues("failure").Inc()
 		http.Error(w, "Failed to decode user info", http.StatusInternalServerError)
 		return
 	}
@@ -109,13 +119,36 @@ func GitHubCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if err != nil {
+		logFor(r.Context()).Error("Failed to save user", "github_id", user.ID, "error", err)
+		oauthLogins.WithLabelValues("failure").Inc()
 		http.Error(w, "Failed to save user info", http.StatusInternalServerError)
 		return
 	}
 
+	// 额外授权 repo 权限的回调：保存 token 以便创建 issue
+	repoGrant := false
+	if state := r.URL.Query().Get("state"); state != "" && state == session.Values["oauth_state"] {
+		repoGrant = true
+		delete(session.Values, "oauth_state")
+		sealed, err := sealGitHubToken(token.AccessToken)
+		if err == nil {
+			_, err = collection.UpdateOne(context.Background(),
+				bson.M{"github_id": user.ID},
+				bson.M{"$set": bson.M{"github_token": sealed}},
+			)
+		}
+		if err != nil {
+			logFor(r.Context()).Error("Failed to save GitHub token", "github_id", user.ID, "error", err)
+			oauthLogins.WithLabelValues("failure").Inc()
+			http.Error(w, "Failed to save GitHub token", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Set session
 	session.Values["user_id"] = user.ID
 	if err := session.Save(r, w); err != nil {
+		oauthLogins.WithLabelValues("failure").Inc()
 		http.Error(w, "Failed to save session", http.StatusInternalServerError)
 		return
 	}
@@ -125,7 +158,12 @@ func GitHubCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	if frontendURL == "" {
 		frontendURL = "http://localhost:3000" // Default to local React dev server
 	}
-	log.Printf("用户登录成功 - ID: %d, 用户名: %s, 邮箱: %s", user.ID, user.Username, user.Email)
+	oauthLogins.WithLabelValues("success").Inc()
+	logFor(r.Context()).Info("User logged in", "github_id", user.ID, "username", user.Username, "repo_grant", repoGrant)
+	if repoGrant {
+		http.Redirect(w, r, frontendURL+"?github_connected=true", http.StatusFound)
+		return
+	}
 	http.Redirect(w, r, frontendURL+"?login_success=true", http.StatusFound)
 }
 
@@ -136,7 +174,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := store.Get(r, "auth-session")
 	// 在开头检查并输出登录状态
 	if userID, ok := session.Values["user_id"].(int); ok {
-		log.Printf("用户 ID %d 已登录", userID)
+		logFor(r.Context()).Debug("User is already logged in", "github_id", userID)
 		frontendURL := os.Getenv("FRONTEND_URL")
 		if frontendURL == "" {
 			frontendURL = "http://localhost:3000"
@@ -144,7 +182,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, frontendURL+"?login_success=true", http.StatusFound)
 		return
 	} else {
-		log.Printf("用户未登录")
+		logFor(r.Context()).Debug("Starting GitHub login")
 	}
 
 	// 开始 OAuth 流程
@@ -186,7 +224,7 @@ func UserHandler(w http.ResponseWriter, r *http.Request) {
 
 	session, err := store.Get(r, "auth-session")
 	if err != nil {
-		log.Printf("Failed to get session: %v", err)
+		logFor(r.Context()).Error("Failed to get session", "error", err)
 		http.Error(w, "Failed to get session", http.StatusInternalServerError)
 		return
 	}
@@ -205,6 +243,9 @@ func UserHandler(w http.ResponseWriter, r *http.Request) {
 		Email     string `json:"email" bson:"email"`
 		Username  string `json:"username" bson:"username"`
 		AvatarURL string `json:"avatar_url" bson:"avatar_url"`
+
+		GitHubToken      string `json:"-" bson:"github_token"`
+		GitHubRepoAccess bool   `json:"github_repo_access" bson:"-"`
 	}
 
 	err = collection.FindOne(context.Background(), map[string]interface{}{"github_id": userID}).Decode(&user)
@@ -212,11 +253,99 @@ func UserHandler(w http.ResponseWriter, r *http.Request) {
 		if err == mongo.ErrNoDocuments {
 			json.NewEncoder(w).Encode(map[string]interface{}{"user": nil})
 		} else {
-			log.Printf("Failed to fetch user from database: %v", err)
+			logFor(r.Context()).Error("Failed to fetch user", "github_id", userID, "error", err)
 			http.Error(w, "Failed to fetch user information", http.StatusInternalServerError)
 		}
 		return
 	}
 
+	user.GitHubRepoAccess = user.GitHubToken != ""
 	json.NewEncoder(w).Encode(map[string]interface{}{"user": user})
 }
+
+// authUser is a user document from the users collection
+type authUser struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	GitHubID  int                `bson:"github_id"`
+	Name      string             `bson:"name"`
+	Email     string             `bson:"email"`
+	Username  string             `bson:"username"`
+	AvatarURL string             `bson:"avatar_url"`
+
+	NotificationPreferences *models.NotificationPreferences `bson:"notification_preferences,omitempty"`
+	// 仅在用户额外授权 repo 权限后才有，已加密，用 userGitHubToken 解密
+	GitHubToken string `bson:"github_token,omitempty"`
+}
+
+// currentUser loads the logged-in user. ok is false when the request is not
+// authenticated or the user no longer exists.
+func currentUser(r *http.Request) (*authUser, bool) {
+	session, err := store.Get(r, "auth-session")
+	if err != nil {
+		return nil, false
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		return nil, false
+	}
+
+	var user authUser
+	err = Client.Database("your-db-name").Collection("users").FindOne(
+		context.Background(),
+		bson.M{"github_id": userID},
+	).Decode(&user)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			logFor(r.Context()).Error("Failed to fetch user", "github_id", userID, "error", err)
+		}
+		return nil, false
+	}
+	return &user, true
+}
+
+func findUsersByUsername(ctx context.Context, usernames []string) (map[string]authUser, error) {
+	users := make(map[string]authUser)
+	if len(usernames) == 0 {
+		return users, nil
+	}
+
+	cursor, err := Client.Database("your-db-name").Collection("users").Find(ctx, bson.M{"username": bson.M{"$in": usernames}})
+	if err != nil {
+		return nil, err
+	}
+	var found []authUser
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	for _, user := range found {
+		users[user.Username] = user
+	}
+	return users, nil
+}
+
+// isWorkspaceAdmin reports whether the user is listed in WORKSPACE_ADMINS, a
+// comma separated list of GitHub usernames.
+func isWorkspaceAdmin(user authUser) bool {
+	for _, username := range strings.Split(os.Getenv("WORKSPACE_ADMINS"), ",") {
+		if username = strings.TrimSpace(username); username != "" && strings.EqualFold(username, user.Username) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentAdmin loads the logged-in user and writes an error response unless
+// they are a workspace admin.
+func currentAdmin(w http.ResponseWriter, r *http.Request) (*authUser, bool) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !isWorkspaceAdmin(*user) {
+		http.Error(w, "Only workspace admins can do this", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,540 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxBreakouts            = 50
+	maxBreakoutMinutes      = 240
+	maxBreakoutNotesLength  = 20000
+	maxBreakoutAnnouncement = 1000
+)
+
+// breakoutRoom is the key of the room of a breakout
+func breakoutRoom(sessionID, breakoutID string) string {
+	return sessionID + "/breakouts/" + breakoutID
+}
+
+func openBreakouts(session models.Session) []models.Breakout {
+	var open []models.Breakout
+	for _, breakout := range session.Breakouts {
+		if breakout.Status == models.BreakoutOpen {
+			open = append(open, breakout)
+		}
+	}
+	return open
+}
+
+func hasParticipant(breakout models.Breakout, username string) bool {
+	for _, participant := range breakout.Participants {
+		if strings.EqualFold(participant, username) {
+			return true
+		}
+	}
+	return false
+}
+
+// findOpenBreakout loads a breakout a user is about to connect to. Only its
+// participants and the facilitator may join it.
+func findOpenBreakout(w http.ResponseWriter, sessionID, breakoutID string, userID int, username string) (models.Breakout, bool) {
+	var session models.Session
+	objectID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return models.Breakout{}, false
+	}
+	if err := sessionCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": objectID})).Decode(&session); err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return models.Breakout{}, false
+	}
+	for _, breakout := range session.Breakouts {
+		if breakout.ID.Hex() != breakoutID {
+			continue
+		}
+		if breakout.Status != models.BreakoutOpen {
+			http.Error(w, "Breakout room is closed", http.StatusGone)
+			return breakout, false
+		}
+		if !hasParticipant(breakout, username) && !isFacilitator(session, userID) {
+			http.Error(w, "You are not in this breakout room", http.StatusForbidden)
+			return breakout, false
+		}
+		return breakout, true
+	}
+	http.Error(w, "Breakout room not found", http.StatusNotFound)
+	return models.Breakout{}, false
+}
+
+// connectedParticipants returns the usernames connected to the main room of
+// a session, without the facilitator
+func connectedParticipants(session models.Session) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, client := range roomClients(session.ID.Hex()) {
+		if session.FacilitatorID != 0 && client.userID == session.FacilitatorID {
+			continue
+		}
+		if !seen[client.username] {
+			seen[client.username] = true
+			usernames = append(usernames, client.username)
+		}
+	}
+	return usernames
+}
+
+// loadFacilitatorSession loads a session for a breakout action that only the
+// facilitator may take
+func loadFacilitatorSession(w http.ResponseWriter, r *http.Request, action string) (models.Session, *authUser, bool) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return session, nil, false
+	}
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return session, nil, false
+	}
+	if !isFacilitator(session, user.GitHubID) {
+		http.Error(w, "Only the facilitator can "+action, http.StatusForbidden)
+		return session, nil, false
+	}
+	return session, user, true
+}
+
+// CreateBreakoutsHandler splits a session into breakout rooms. Rooms are
+// given with their participants, or count asks for that many rooms with the
+// connected participants assigned at random.
+func CreateBreakoutsHandler(w http.ResponseWriter, r *http.Request) {
+	session, _, ok := loadFacilitatorSession(w, r, "open breakout rooms")
+	if !ok {
+		return
+	}
+	if len(openBreakouts(session)) > 0 {
+		http.Error(w, "Close the open breakout rooms first", http.StatusConflict)
+		return
+	}
+
+	var input struct {
+		Rooms []struct {
+			Name         string   `json:"name"`
+			Participants []string `json:"participants"`
+		} `json:"rooms"`
+		Count           int `json:"count"`
+		DurationMinutes int `json:"duration_minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if input.DurationMinutes < 0 || input.DurationMinutes > maxBreakoutMinutes {
+		http.Error(w, "duration_minutes must be between 0 and 240", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	var endsAt *time.Time
+	if input.DurationMinutes > 0 {
+		end := now.Add(time.Duration(input.DurationMinutes) * time.Minute)
+		endsAt = &end
+	}
+	newBreakout := func(name string) models.Breakout {
+		return models.Breakout{
+			ID:           primitive.NewObjectID(),
+			Name:         name,
+			Participants: []string{},
+			Status:       models.BreakoutOpen,
+			EndsAt:       endsAt,
+			CreatedAt:    now,
+		}
+	}
+
+	var breakouts []models.Breakout
+	switch {
+	case input.Count > 0 && len(input.Rooms) > 0:
+		http.Error(w, "Pass either rooms or count", http.StatusBadRequest)
+		return
+	case input.Count > 0:
+		usernames := connectedParticipants(session)
+		if input.Count > maxBreakouts || input.Count > len(usernames) {
+			http.Error(w, fmt.Sprintf("count must be between 1 and the %d connected participants", len(usernames)), http.StatusBadRequest)
+			return
+		}
+		for i := 0; i < input.Count; i++ {
+			breakouts = append(breakouts, newBreakout(fmt.Sprintf("Room %d", i+1)))
+		}
+		rand.Shuffle(len(usernames), func(i, j int) { usernames[i], usernames[j] = usernames[j], usernames[i] })
+		for i, username := range usernames {
+			breakouts[i%input.Count].Participants = append(breakouts[i%input.Count].Participants, username)
+		}
+	case len(input.Rooms) > 0:
+		if len(input.Rooms) > maxBreakouts {
+			http.Error(w, "A session can have at most 50 breakout rooms", http.StatusBadRequest)
+			return
+		}
+		assigned := make(map[string]bool)
+		for i, room := range input.Rooms {
+			name := strings.TrimSpace(room.Name)
+			if name == "" {
+				name = fmt.Sprintf("Room %d", i+1)
+			}
+			breakout := newBreakout(name)
+			for _, username := range room.Participants {
+				username = strings.TrimPrefix(strings.TrimSpace(username), "@")
+				if username == "" {
+					continue
+				}
+				if assigned[strings.ToLower(username)] {
+					http.Error(w, username+" is in more than one room", http.StatusBadRequest)
+					return
+				}
+				assigned[strings.ToLower(username)] = true
+				breakout.Participants = append(breakout.Participants, username)
+			}
+			breakouts = append(breakouts, breakout)
+		}
+	default:
+		http.Error(w, "Pass rooms or count", http.StatusBadRequest)
+		return
+	}
+
+	_, err := sessionCollection.UpdateOne(r.Context(), bson.M{"_id": session.ID},
+		bson.M{"$push": bson.M{"breakouts": bson.M{"$each": breakouts}}})
+	if err != nil {
+		logFor(r.Context()).Error("Failed to open breakout rooms", "error", err)
+		http.Error(w, "Failed to open breakout rooms", http.StatusInternalServerError)
+		return
+	}
+	if endsAt != nil {
+		sessionID := session.ID
+		time.AfterFunc(time.Until(*endsAt), func() {
+			if _, err := closeBreakouts(withLogger(context.Background(), breakoutLog), sessionID, true); err != nil {
+				breakoutLog.Error("Failed to close breakout rooms", "session_id", sessionID.Hex(), "error", err)
+			}
+		})
+	}
+
+	// 每个人收到自己被分到的讨论室
+	BroadcastEach(session.ID.Hex(), func(c *MeetingClient) interface{} {
+		message := map[string]interface{}{
+			"type":      "breakoutsOpened",
+			"breakouts": breakouts,
+		}
+		for _, breakout := range breakouts {
+			if hasParticipant(breakout, c.username) {
+				message["yourBreakout"] = breakout.ID.Hex()
+			}
+		}
+		return message
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(breakouts)
+}
+
+// GetBreakoutsHandler lists the breakout rooms of a session, the closed ones
+// included
+func GetBreakoutsHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+	breakouts := session.Breakouts
+	if breakouts == nil {
+		breakouts = []models.Breakout{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(breakouts)
+}
+
+// BroadcastToBreakoutsHandler sends a message from the facilitator to the
+// main room and every open breakout room
+func BroadcastToBreakoutsHandler(w http.ResponseWriter, r *http.Request) {
+	session, user, ok := loadFacilitatorSession(w, r, "message the breakout rooms")
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	text := strings.TrimSpace(input.Message)
+	if text == "" || utf8.RuneCountInString(text) > maxBreakoutAnnouncement {
+		http.Error(w, "Message must have between 1 and 1000 characters", http.StatusBadRequest)
+		return
+	}
+	open := openBreakouts(session)
+	if len(open) == 0 {
+		http.Error(w, "No open breakout rooms", http.StatusConflict)
+		return
+	}
+
+	message := map[string]interface{}{
+		"type":    "breakoutAnnouncement",
+		"message": text,
+		"from":    user.Username,
+		"sentAt":  time.Now(),
+	}
+	Broadcast(session.ID.Hex(), message)
+	for _, breakout := range open {
+		Broadcast(breakoutRoom(session.ID.Hex(), breakout.ID.Hex()), message)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// CloseBreakoutsHandler ends every open breakout room of a session before its
+// timer runs out
+func CloseBreakoutsHandler(w http.ResponseWriter, r *http.Request) {
+	session, _, ok := loadFacilitatorSession(w, r, "close breakout rooms")
+	if !ok {
+		return
+	}
+	closed, err := closeBreakouts(r.Context(), session.ID, false)
+	if err != nil {
+		logFor(r.Context()).Error("Failed to close breakout rooms", "error", err)
+		http.Error(w, "Failed to close breakout rooms", http.StatusInternalServerError)
+		return
+	}
+	if len(closed) == 0 {
+		http.Error(w, "No open breakout rooms", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(closed)
+}
+
+// closeBreakouts closes the open breakout rooms of a session, or with
+// expiredOnly just those whose timer has run out. Their notes are added to
+// the minutes and everyone in them is sent back to the main room.
+func closeBreakouts(ctx context.Context, sessionID primitive.ObjectID, expiredOnly bool) ([]models.Breakout, error) {
+	var session models.Session
+	if err := sessionCollection.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var closing []models.Breakout
+	ids := bson.A{}
+	for _, breakout := range openBreakouts(session) {
+		if expiredOnly && (breakout.EndsAt == nil || breakout.EndsAt.After(now)) {
+			continue
+		}
+		breakout.Status = models.BreakoutClosed
+		breakout.ClosedAt = &now
+		closing = append(closing, breakout)
+		ids = append(ids, breakout.ID)
+	}
+	if len(closing) == 0 {
+		return nil, nil
+	}
+
+	result, err := sessionCollection.UpdateOne(ctx, bson.M{"_id": sessionID},
+		bson.M{"$set": bson.M{
+			"breakouts.$[b].status":    models.BreakoutClosed,
+			"breakouts.$[b].closed_at": now,
+		}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{
+			bson.M{"b._id": bson.M{"$in": ids}, "b.status": models.BreakoutOpen},
+		}}))
+	if err != nil {
+		return nil, err
+	}
+	// 计时器和主持人同时关闭时只处理一次
+	if result.ModifiedCount == 0 {
+		return nil, nil
+	}
+
+	if err := mergeBreakoutNotes(ctx, session, closing); err != nil {
+		logFor(ctx).Error("Failed to add breakout notes to the minutes", "session_id", sessionID.Hex(), "error", err)
+	}
+
+	for _, breakout := range closing {
+		room := breakoutRoom(sessionID.Hex(), breakout.ID.Hex())
+		Broadcast(room, map[string]interface{}{
+			"type":       "breakoutEnded",
+			"breakoutId": breakout.ID.Hex(),
+			"sessionId":  sessionID.Hex(),
+		})
+		// 断开连接，客户端回到主会议室
+		for _, client := range roomClients(room) {
+			if client.conn != nil {
+				client.conn.Close()
+			}
+		}
+	}
+	Broadcast(sessionID.Hex(), map[string]interface{}{
+		"type":      "breakoutsClosed",
+		"breakouts": closing,
+	})
+	return closing, nil
+}
+
+// mergeBreakoutNotes appends the notes of closed breakout rooms to the
+// minutes of the session, one section per room
+func mergeBreakoutNotes(ctx context.Context, session models.Session, breakouts []models.Breakout) error {
+	var b strings.Builder
+	for _, breakout := range breakouts {
+		if strings.TrimSpace(breakout.Notes) == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "## Breakout: %s\n\n", breakout.Name)
+		if len(breakout.Participants) > 0 {
+			fmt.Fprintf(&b, "_Participants: @%s_\n\n", strings.Join(breakout.Participants, ", @"))
+		}
+		b.WriteString(strings.TrimSpace(breakout.Notes))
+		b.WriteString("\n\n")
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+
+	// 在数据库里追加，不会覆盖同时保存的纪要。还没有纪要时从议程生成，
+	// 和 GetMinutesHandler 一致。文本用 $literal 包起来，以 $ 开头时不会被当成字段
+	now := time.Now()
+	existing := bson.M{"$rtrim": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$content", bson.M{"$literal": scaffoldMinutes(session.Agenda)}}},
+		"chars": "\n",
+	}}
+	notes := bson.M{"$literal": b.String()}
+	var minutes models.Minutes
+	err := minutesCollection.FindOneAndUpdate(ctx, bson.M{"session_id": session.ID},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"content": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{existing, ""}},
+				notes,
+				bson.M{"$concat": bson.A{existing, "\n\n", notes}},
+			}},
+			"updated_at": now,
+			"created_at": bson.M{"$ifNull": bson.A{"$created_at", now}},
+		}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&minutes)
+	if err != nil {
+		return err
+	}
+
+	content := syncActionItemsFromMinutes(ctx, session.ID, minutes.Content)
+	emitWebhookEvent(ctx, session.ID, models.EventMinutesUpdated, map[string]interface{}{
+		"session_id": session.ID,
+		"content":    content,
+		"updated_at": now,
+	})
+	return nil
+}
+
+// closeExpiredBreakouts closes breakout rooms whose timer ran out while the
+// server was not running
+func closeExpiredBreakouts(ctx context.Context) {
+	filter := notDeleted(bson.M{"breakouts": bson.M{"$elemMatch": bson.M{
+		"status":  models.BreakoutOpen,
+		"ends_at": bson.M{"$lte": time.Now()},
+	}}})
+	cursor, err := sessionCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		logFor(ctx).Error("Failed to fetch expired breakout rooms", "error", err)
+		return
+	}
+	var sessions []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		logFor(ctx).Error("Failed to fetch expired breakout rooms", "error", err)
+		return
+	}
+	for _, session := range sessions {
+		if _, err := closeBreakouts(ctx, session.ID, true); err != nil {
+			logFor(ctx).Error("Failed to close breakout rooms", "session_id", session.ID.Hex(), "error", err)
+		}
+	}
+}
+
+// StartBreakoutTimers runs closeExpiredBreakouts in the background
+func StartBreakoutTimers(interval time.Duration) {
+	go func() {
+		for {
+			closeExpiredBreakouts(withLogger(context.Background(), breakoutLog))
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// handleBreakoutMessage handles the messages of clients in a breakout room.
+// Breakout rooms have notes, presence and typing indicators; everything else
+// happens in the main room.
+func handleBreakoutMessage(client *MeetingClient, msg map[string]interface{}) {
+	switch msg["type"] {
+	case "notes":
+		handleBreakoutNotes(client, msg)
+	case "typing":
+		handleTyping(client, msg)
+	case "resume":
+		handleResume(client, msg)
+	case "presence":
+		handlePresence(client, msg)
+	default:
+		action, _ := msg["type"].(string)
+		sendError(client, action, "Not available in breakout rooms")
+	}
+}
+
+// handleBreakoutNotes replaces the notes of the client's breakout room
+func handleBreakoutNotes(client *MeetingClient, msg map[string]interface{}) {
+	content, _ := msg["content"].(string)
+	if utf8.RuneCountInString(content) > maxBreakoutNotesLength {
+		sendError(client, "notes", "Notes are too long")
+		return
+	}
+	sessionID, err := primitive.ObjectIDFromHex(client.sessionID)
+	if err != nil {
+		sendError(client, "notes", "Invalid session ID")
+		return
+	}
+	breakoutID, err := primitive.ObjectIDFromHex(client.breakoutID)
+	if err != nil {
+		sendError(client, "notes", "Invalid breakout room")
+		return
+	}
+
+	result, err := sessionCollection.UpdateOne(context.Background(),
+		bson.M{"_id": sessionID, "breakouts": bson.M{"$elemMatch": bson.M{"_id": breakoutID, "status": models.BreakoutOpen}}},
+		bson.M{"$set": bson.M{
+			"breakouts.$[b].notes":            content,
+			"breakouts.$[b].notes_updated_by": client.username,
+		}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"b._id": breakoutID}}}))
+	if err != nil {
+		client.log().Error("Failed to save breakout notes", "error", err)
+		sendError(client, "notes", "Failed to save notes")
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendError(client, "notes", "Breakout room is closed")
+		return
+	}
+
+	Broadcast(client.room(), map[string]interface{}{
+		"type":      "breakoutNotes",
+		"content":   content,
+		"updatedBy": client.username,
+	})
+}
@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const icsTimeFormat = "20060102T150405Z"
+
+// icsEscape escapes a TEXT value as described in RFC 5545 section 3.3.11
+func icsEscape(value string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(value)
+}
+
+// icsLine writes a content line folded at 75 octets
+func icsLine(b *strings.Builder, line string) {
+	for len(line) > 75 {
+		cut := 75
+		// 不要在 UTF-8 字符中间断开
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+func frontendURL() string {
+	if u := os.Getenv("FRONTEND_URL"); u != "" {
+		return u
+	}
+	return "http://localhost:3000"
+}
+
+func icsHost() string {
+	if u, err := url.Parse(frontendURL()); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "localhost"
+}
+
+const icsLocalTimeFormat = "20060102T150405"
+
+// icsEventZone returns the time zone the times of a session's event are
+// written in. Only recurring invitations use one, so the rule is expanded in
+// the meeting's time zone across daylight saving changes; everything else is
+// written in UTC.
+func icsEventZone(session models.Session, withRecurrence bool) (*time.Location, bool) {
+	if !withRecurrence || session.Recurrence == "" || session.TimeZone == "" {
+		return nil, false
+	}
+	loc, err := time.LoadLocation(session.TimeZone)
+	return loc, err == nil
+}
+
+// icsTimezoneYears is how many years of daylight saving changes a VTIMEZONE
+// lists after the first event
+const icsTimezoneYears = 10
+
+// icsTimezone writes the VTIMEZONE that a TZID parameter refers to, listing
+// every UTC offset change of loc from a year before start on. Zones without
+// changes get a single STANDARD observance.
+func icsTimezone(b *strings.Builder, loc *time.Location, start time.Time) {
+	from := start.AddDate(-1, 0, 0)
+	to := start.AddDate(icsTimezoneYears, 0, 0)
+
+	icsLine(b, "BEGIN:VTIMEZONE")
+	icsLine(b, "TZID:"+loc.String())
+	observance := func(at time.Time, offsetFrom int) {
+		kind := "STANDARD"
+		if at.In(loc).IsDST() {
+			kind = "DAYLIGHT"
+		}
+		name, offset := at.In(loc).Zone()
+		icsLine(b, "BEGIN:"+kind)
+		// DTSTART 是切换前的本地时间
+		icsLine(b, "DTSTART:"+at.Add(time.Duration(offsetFrom)*time.Second).UTC().Format(icsLocalTimeFormat))
+		icsLine(b, "TZOFFSETFROM:"+icsOffset(offsetFrom))
+		icsLine(b, "TZOFFSETTO:"+icsOffset(offset))
+		icsLine(b, "TZNAME:"+name)
+		icsLine(b, "END:"+kind)
+	}
+
+	_, previous := from.In(loc).Zone()
+	changes := 0
+	for t := from; t.Before(to); t = t.Add(24 * time.Hour) {
+		next := t.Add(24 * time.Hour)
+		if _, offset := next.In(loc).Zone(); offset == previous {
+			continue
+		}
+		// 在这一天内二分查找切换的时刻
+		lo, hi := t, next
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if _, offset := mid.In(loc).Zone(); offset == previous {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		observance(hi, previous)
+		_, previous = hi.In(loc).Zone()
+		changes++
+	}
+	if changes == 0 {
+		observance(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC).Add(-time.Duration(previous)*time.Second), previous)
+	}
+	icsLine(b, "END:VTIMEZONE")
+}
+
+// icsOffset formats a UTC offset in seconds as +hhmm, or +hhmmss when it has
+// seconds
+func icsOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	formatted := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		formatted += fmt.Sprintf("%02d", seconds%60)
+	}
+	return formatted
+}
+
+// icsEvent writes a VEVENT for a scheduled session. Feeds list materialized
+// occurrences individually, so the RRULE is only written for invitations.
+func icsEvent(b *strings.Builder, session models.Session, attendees map[string]string, withRecurrence bool) {
+	start := session.ScheduledStart.UTC()
+	end := start.Add(time.Hour)
+	if session.ScheduledEnd != nil {
+		end = session.ScheduledEnd.UTC()
+	}
+
+	icsLine(b, "BEGIN:VEVENT")
+	icsLine(b, fmt.Sprintf("UID:%s@%s", session.ID.Hex(), icsHost()))
+	icsLine(b, "DTSTAMP:"+time.Now().UTC().Format(icsTimeFormat))
+	if loc, ok := icsEventZone(session, withRecurrence); ok {
+		// 重复规则按会议所在时区展开，避免夏令时偏移；对应的 VTIMEZONE 由 renderCalendar 写出
+		icsLine(b, fmt.Sprintf("DTSTART;TZID=%s:%s", session.TimeZone, start.In(loc).Format(icsLocalTimeFormat)))
+		icsLine(b, fmt.Sprintf("DTEND;TZID=%s:%s", session.TimeZone, end.In(loc).Format(icsLocalTimeFormat)))
+	} else {
+		icsLine(b, "DTSTART:"+start.Format(icsTimeFormat))
+		icsLine(b, "DTEND:"+end.Format(icsTimeFormat))
+	}
+	if withRecurrence && session.Recurrence != "" {
+		icsLine(b, "RRULE:"+strings.TrimPrefix(session.Recurrence, "RRULE:"))
+	}
+	icsLine(b, "SUMMARY:"+icsEscape(session.Name))
+	link := fmt.Sprintf("%s/sessions/%s", frontendURL(), session.ID.Hex())
+	icsLine(b, "URL:"+link)
+
+	var description []string
+	for _, item := range session.Agenda {
+		description = append(description, "- "+item.Title)
+	}
+	description = append(description, link)
+	icsLine(b, "DESCRIPTION:"+icsEscape(strings.Join(description, "\n")))
+
+	for _, participant := range session.Participants {
+		email := attendees[participant.Username]
+		if email == "" {
+			continue
+		}
+		icsLine(b, fmt.Sprintf("ATTENDEE;CN=%s;ROLE=REQ-PARTICIPANT;RSVP=TRUE:mailto:%s", icsEscape(participant.Username), email))
+	}
+	icsLine(b, "END:VEVENT")
+}
+
+// renderCalendar renders sessions as an iCalendar object. method is empty for
+// feeds and REQUEST for invitations.
+func renderCalendar(name, method string, sessions []models.Session, attendees map[string]string) []byte {
+	var b strings.Builder
+	icsLine(&b, "BEGIN:VCALENDAR")
+	icsLine(&b, "VERSION:2.0")
+	icsLine(&b, "PRODID:-//golangwebsite//Meetings//EN")
+	icsLine(&b, "CALSCALE:GREGORIAN")
+	if method != "" {
+		icsLine(&b, "METHOD:"+method)
+	}
+	icsLine(&b, "X-WR-CALNAME:"+icsEscape(name))
+	// RFC 5545 要求每个 TZID 都有对应的 VTIMEZONE
+	zones := make(map[string]bool)
+	for _, session := range sessions {
+		if session.ScheduledStart == nil {
+			continue
+		}
+		if loc, ok := icsEventZone(session, method == "REQUEST"); ok && !zones[session.TimeZone] {
+			zones[session.TimeZone] = true
+			icsTimezone(&b, loc, *session.ScheduledStart)
+		}
+	}
+	for _, session := range sessions {
+		if session.ScheduledStart != nil {
+			icsEvent(&b, session, attendees, method == "REQUEST")
+		}
+	}
+	icsLine(&b, "END:VCALENDAR")
+	return []byte(b.String())
+}
+
+// participantEmails looks up the e-mail addresses of the session participants
+func participantEmails(ctx context.Context, session models.Session) map[string]string {
+	users, err := findUsersByUsername(ctx, sessionUsernames(session))
+	if err != nil {
+		logFor(ctx).Error("Failed to look up participant e-mails", "session_id", session.ID.Hex(), "error", err)
+		return nil
+	}
+	emails := make(map[string]string)
+	for username, user := range users {
+		if user.Email != "" {
+			emails[username] = user.Email
+		}
+	}
+	return emails
+}
+
+// sessionInvitation renders the invitation .ics of a single session
+func sessionInvitation(ctx context.Context, session models.Session) []byte {
+	return renderCalendar(session.Name, "REQUEST", []models.Session{session}, participantEmails(ctx, session))
+}
+
+func writeCalendar(w http.ResponseWriter, r *http.Request, name string, filter bson.M) {
+	filter = notDeleted(filter)
+	filter["scheduled_start"] = bson.M{"$ne": nil}
+	opts := options.Find().SetSort(bson.D{{Key: "scheduled_start", Value: 1}})
+	cursor, err := sessionCollection.Find(r.Context(), filter, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+	var sessions []models.Session
+	if err := cursor.All(r.Context(), &sessions); err != nil {
+		http.Error(w, "Failed to parse sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write(renderCalendar(name, "", sessions, nil))
+}
+
+// 日历订阅无法携带登录 cookie，所以用每个用户自己的订阅 token 访问。
+// 数据库只保存 token 的哈希。
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// feedUser finds the owner of the feed token in the token query parameter
+func feedUser(w http.ResponseWriter, r *http.Request) (*authUser, bool) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	var user authUser
+	err := Client.Database("your-db-name").Collection("users").FindOne(r.Context(),
+		bson.M{"calendar_token_hash": hashFeedToken(token)}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	} else if err != nil {
+		logFor(r.Context()).Error("Failed to look up feed token", "error", err)
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &user, true
+}
+
+// CreateCalendarTokenHandler creates a new feed token for the current user and
+// returns the feed URLs. Any earlier token stops working.
+func CreateCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(buf)
+	_, err := Client.Database("your-db-name").Collection("users").UpdateOne(r.Context(),
+		bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"calendar_token_hash": hashFeedToken(token)}})
+	if err != nil {
+		logFor(r.Context()).Error("Failed to save feed token", "error", err)
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	query := "?token=" + url.QueryEscape(token)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":         token,
+		"workspace_url": "/api/calendar/workspace.ics" + query,
+		"user_url":      "/api/calendar/users/" + url.PathEscape(user.Username) + ".ics" + query,
+	})
+}
+
+// WorkspaceCalendarHandler serves an .ics feed of every scheduled session to
+// any user with a feed token
+func WorkspaceCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := feedUser(w, r); !ok {
+		return
+	}
+	writeCalendar(w, r, "Meetings", bson.M{})
+}
+
+// UserCalendarHandler serves an .ics feed of the sessions a user takes part
+// in. Only the user's own feed token opens it.
+func UserCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := feedUser(w, r)
+	if !ok {
+		return
+	}
+	username := mux.Vars(r)["username"]
+	if !strings.EqualFold(username, user.Username) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	writeCalendar(w, r, user.Username+" meetings", bson.M{"participants.username": user.Username})
+}
+
+// SessionInvitationHandler serves the invitation .ics of a session. It lists
+// the e-mail addresses of the participants, so only participants, the
+// facilitator and workspace admins can download it.
+func SessionInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+	if !isSessionMember(session, *user) && !isWorkspaceAdmin(*user) {
+		http.Error(w, "Only participants can download the invitation", http.StatusForbidden)
+		return
+	}
+	if session.ScheduledStart == nil {
+		http.Error(w, "Session is not scheduled", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; method=REQUEST; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="invite.ics"`)
+	w.Write(sessionInvitation(r.Context(), session))
+}
+
+// sameInstant compares two optional times at the millisecond precision of
+// the database
+func sameInstant(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.UnixMilli() == b.UnixMilli()
+}
+
+// UpdateScheduleHandler sets the schedule and recurrence of a session
+func UpdateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+
+	authSession, _ := store.Get(r, "auth-session")
+	userID, _ := authSession.Values["user_id"].(int)
+	if !isFacilitator(session, userID) {
+		http.Error(w, "Only the facilitator can change the schedule", http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		ScheduledStart *time.Time `json:"scheduled_start"`
+		ScheduledEnd   *time.Time `json:"scheduled_end"`
+		TimeZone       string     `json:"time_zone"`
+		Recurrence     string     `json:"recurrence"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	// 场次的开始时间跟随系列，单独移动会在下次生成场次时被当作过期场次移除
+	if !session.SeriesID.IsZero() && !sameInstant(session.ScheduledStart, input.ScheduledStart) {
+		http.Error(w, "The start of an occurrence follows its series; change the series instead", http.StatusBadRequest)
+		return
+	}
+
+	session.ScheduledStart = input.ScheduledStart
+	session.ScheduledEnd = input.ScheduledEnd
+	session.TimeZone = input.TimeZone
+	session.Recurrence = input.Recurrence
+	if msg := validateSchedule(&session); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	// 只有还没开始的会话随排期在 draft 和 scheduled 之间切换
+	if status := sessionStatus(session); status == models.SessionDraft || status == models.SessionScheduled {
+		session.Status = timelineStatus(session)
+	}
+
+	_, err := sessionCollection.UpdateOne(r.Context(), bson.M{"_id": session.ID}, bson.M{
+		"$set": bson.M{
+			"scheduled_start": session.ScheduledStart,
+			"scheduled_end":   session.ScheduledEnd,
+			"time_zone":       session.TimeZone,
+			"recurrence":      session.Recurrence,
+			"status":          session.Status,
+		},
+	})
+	if err != nil {
+		logFor(r.Context()).Error("Failed to update schedule", "error", err)
+		http.Error(w, "Failed to update schedule", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := materializeSeries(r.Context(), session); err != nil {
+		logFor(r.Context()).Error("Failed to materialize series", "series_id", session.ID.Hex(), "error", err)
+	}
+	notifyInvitation(r.Context(), session)
+
+	redactSession(&session, requestIsAdmin(r))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
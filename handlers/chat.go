@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var chatCollection *mongo.Collection
+
+const (
+	maxChatMessageLength = 2000
+	defaultChatPageSize  = 50
+	maxChatPageSize      = 200
+)
+
+func InitChatCollection(client *mongo.Client) {
+	chatCollection = client.Database("your-db-name").Collection("chat_messages")
+
+	// 分页按会话和 _id 倒序读取
+	_, err := chatCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "session_id", Value: 1}, {Key: "_id", Value: -1}},
+	})
+	if err != nil {
+		dbLog.Error("Failed to create indexes", "collection", "chat_messages", "error", err)
+	}
+}
+
+// mentionPattern matches @username with the characters GitHub allows
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9](?:[A-Za-z0-9-]{0,38}))`)
+
+// chatMentions returns the distinct usernames mentioned in a message, without
+// the author
+func chatMentions(content, author string) []string {
+	var mentions []string
+	seen := map[string]bool{strings.ToLower(author): true}
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		key := strings.ToLower(m[1])
+		if !seen[key] {
+			seen[key] = true
+			mentions = append(mentions, m[1])
+		}
+	}
+	return mentions
+}
+
+// validChatContent trims a message and checks its length
+func validChatContent(content string) (string, string) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return "", "Message is empty"
+	}
+	if utf8.RuneCountInString(content) > maxChatMessageLength {
+		return "", "Message is too long"
+	}
+	return content, ""
+}
+
+// notifyMentions tells mentioned users about a chat message. Users in the
+// meeting get a live notification, the other participants an e-mail.
+func notifyMentions(message models.ChatMessage) {
+	if len(message.Mentions) == 0 {
+		return
+	}
+	sessionID := message.SessionID.Hex()
+
+	mentioned := make(map[string]bool)
+	for _, username := range message.Mentions {
+		mentioned[strings.ToLower(username)] = true
+	}
+	present := make(map[string]bool)
+	for _, client := range roomClients(sessionID) {
+		if !mentioned[strings.ToLower(client.username)] {
+			continue
+		}
+		present[strings.ToLower(client.username)] = true
+		if err := client.send(map[string]interface{}{
+			"type":    "mentioned",
+			"message": message,
+		}); err != nil {
+			client.log().Warn("Failed to send mention", "message_id", message.ID.Hex(), "error", err)
+		}
+	}
+
+	var absent []string
+	for _, username := range message.Mentions {
+		if !present[strings.ToLower(username)] {
+			absent = append(absent, username)
+		}
+	}
+	if len(absent) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	var session models.Session
+	if err := sessionCollection.FindOne(ctx, notDeleted(bson.M{"_id": message.SessionID})).Decode(&session); err != nil {
+		notificationLog.Error("Failed to load session for mention notification", "session_id", message.SessionID.Hex(), "error", err)
+		return
+	}
+	// 只给会话的参与者和主持人发邮件，不能借提及给任意用户发信
+	users, err := findUsersByUsername(ctx, absent)
+	if err != nil {
+		notificationLog.Error("Failed to look up mentioned users", "session_id", message.SessionID.Hex(), "error", err)
+		return
+	}
+	var members []string
+	for username, user := range users {
+		if isSessionMember(session, user) {
+			members = append(members, username)
+		}
+	}
+	notifyUsernames(ctx, members, notification{
+		Kind:      "mention",
+		Template:  "mention",
+		Subject:   message.Username + " mentioned you in " + session.Name,
+		DedupeKey: "mention:" + message.ID.Hex(),
+		SessionID: session.ID,
+		Data: map[string]interface{}{
+			"Message": message,
+			"Session": session,
+			"Link":    sessionLink(session.ID),
+		},
+	})
+}
+
+func handleChatMessage(client *MeetingClient, msg map[string]interface{}) {
+	text, _ := msg["content"].(string)
+	content, problem := validChatContent(text)
+	if problem != "" {
+		sendError(client, "chat", problem)
+		return
+	}
+	sessionID, err := primitive.ObjectIDFromHex(client.sessionID)
+	if err != nil {
+		sendError(client, "chat", "Invalid session ID")
+		return
+	}
+
+	message := models.ChatMessage{
+		ID:        primitive.NewObjectID(),
+		SessionID: sessionID,
+		UserID:    client.userID,
+		Username:  client.username,
+		AvatarURL: client.avatarURL,
+		Content:   content,
+		Mentions:  chatMentions(content, client.username),
+		CreatedAt: time.Now(),
+	}
+	if _, err := chatCollection.InsertOne(context.Background(), message); err != nil {
+		client.log().Error("Failed to save chat message", "error", err)
+		sendError(client, "chat", "Failed to send message")
+		return
+	}
+
+	// 发送消息即停止输入状态
+	broadcastTransient(client.sessionID, map[string]interface{}{
+		"type":     "typing",
+		"username": client.username,
+		"typing":   false,
+	})
+	Broadcast(client.sessionID, map[string]interface{}{
+		"type":    "chatMessage",
+		"message": message,
+	})
+	go notifyMentions(message)
+}
+
+// findOwnChatMessage loads a message of the client's session that the client
+// may change. The facilitator may also delete other people's messages.
+func findOwnChatMessage(client *MeetingClient, action, messageID string, facilitatorAllowed bool) (models.ChatMessage, bool) {
+	var message models.ChatMessage
+	objectID, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		sendError(client, action, "Invalid message ID")
+		return message, false
+	}
+	err = chatCollection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&message)
+	if err != nil || message.SessionID.Hex() != client.sessionID || message.DeletedAt != nil {
+		sendError(client, action, "Message not found")
+		return message, false
+	}
+	if message.UserID == client.userID {
+		return message, true
+	}
+	if facilitatorAllowed {
+		if session, err := loadClientSession(client); err == nil && isFacilitator(session, client.userID) {
+			return message, true
+		}
+	}
+	sendError(client, action, "You can only change your own messages")
+	return message, false
+}
+
+func handleEditChat(client *MeetingClient, msg map[string]interface{}) {
+	messageID, _ := msg["messageId"].(string)
+	text, _ := msg["content"].(string)
+	content, problem := validChatContent(text)
+	if problem != "" {
+		sendError(client, "editChat", problem)
+		return
+	}
+	message, ok := findOwnChatMessage(client, "editChat", messageID, false)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	previous := message.Mentions
+	message.Content = content
+	message.Mentions = chatMentions(content, message.Username)
+	message.EditedAt = &now
+	_, err := chatCollection.UpdateOne(context.Background(), bson.M{"_id": message.ID},
+		bson.M{"$set": bson.M{"content": message.Content, "mentions": message.Mentions, "edited_at": now}})
+	if err != nil {
+		client.log().Error("Failed to edit chat message", "message_id", message.ID.Hex(), "error", err)
+		sendError(client, "editChat", "Failed to edit message")
+		return
+	}
+
+	Broadcast(client.sessionID, map[string]interface{}{
+		"type":    "chatEdited",
+		"message": message,
+	})
+
+	// 只通知编辑后新增的提及
+	notified := make(map[string]bool)
+	for _, username := range previous {
+		notified[strings.ToLower(username)] = true
+	}
+	added := message
+	added.Mentions = nil
+	for _, username := range message.Mentions {
+		if !notified[strings.ToLower(username)] {
+			added.Mentions = append(added.Mentions, username)
+		}
+	}
+	go notifyMentions(added)
+}
+
+func handleDeleteChat(client *MeetingClient, msg map[string]interface{}) {
+	messageID, _ := msg["messageId"].(string)
+	message, ok := findOwnChatMessage(client, "deleteChat", messageID, true)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	_, err := chatCollection.UpdateOne(context.Background(), bson.M{"_id": message.ID},
+		bson.M{
+			"$set":   bson.M{"deleted_at": now, "content": ""},
+			"$unset": bson.M{"mentions": ""},
+		})
+	if err != nil {
+		client.log().Error("Failed to delete chat message", "error", err)
+		sendError(client, "deleteChat", "Failed to delete message")
+		return
+	}
+
+	Broadcast(client.sessionID, map[string]interface{}{
+		"type":      "chatDeleted",
+		"messageId": message.ID.Hex(),
+		"deletedBy": client.username,
+	})
+}
+
+// handleTyping relays typing indicators. They are not kept for replay.
+func handleTyping(client *MeetingClient, msg map[string]interface{}) {
+	typing, _ := msg["typing"].(bool)
+	broadcastTransient(client.room(), map[string]interface{}{
+		"type":     "typing",
+		"username": client.username,
+		"typing":   typing,
+	})
+}
+
+// GetChatHandler returns a page of the chat of a session in chronological
+// order. Pass the next_before value of a page as before to load older
+// messages.
+func GetChatHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentUser(r); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sessionID, err := primitive.ObjectIDFromHex(mux.Vars(r)["sessionId"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+	// 回收站里的会话不返回聊天记录
+	if !sessionLive(r.Context(), sessionID.Hex()) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	limit := defaultChatPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxChatPageSize {
+			http.Error(w, "limit must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+	}
+	filter := bson.M{"session_id": sessionID}
+	if before := r.URL.Query().Get("before"); before != "" {
+		beforeID, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			http.Error(w, "Invalid before cursor", http.StatusBadRequest)
+			return
+		}
+		filter["_id"] = bson.M{"$lt": beforeID}
+	}
+
+	// 多取一条判断是否还有更早的消息
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit + 1))
+	cursor, err := chatCollection.Find(r.Context(), filter, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch chat", http.StatusInternalServerError)
+		return
+	}
+	messages := []models.ChatMessage{}
+	if err := cursor.All(r.Context(), &messages); err != nil {
+		http.Error(w, "Failed to fetch chat", http.StatusInternalServerError)
+		return
+	}
+
+	var nextBefore string
+	if len(messages) > limit {
+		messages = messages[:limit]
+		nextBefore = messages[limit-1].ID.Hex()
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages":    messages,
+		"next_before": nextBefore,
+	})
+}
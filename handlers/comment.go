@@ -4,7 +4,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
 	"time"
 	"your-project/models"
@@ -46,8 +46,9 @@ func PostCommentHandler(w http.ResponseWriter, r *http.Request) {
     }
 
     var commentInput struct {
-        Content string `json:"content"`
-        Stars   int    `json:"stars"`
+        Content   string `json:"content"`
+        Stars     int    `json:"stars"`
+        SummaryID string `json:"summary_id"` // 为空时评论所有 summary
     }
 
     if err := json.NewDecoder(r.Body).Decode(&commentInput); err != nil {
@@ -55,12 +56,6 @@ func PostCommentHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // 验证星级
-    if commentInput.Stars < 1 || commentInput.Stars > 10 {
-        http.Error(w, "Stars must be between 1 and 10", http.StatusBadRequest)
-        return
-    }
-
     // 获取用户信息用于广播
     var user struct {
         ID        primitive.ObjectID `bson:"_id"`
@@ -73,11 +68,51 @@ func PostCommentHandler(w http.ResponseWriter, r *http.Request) {
     ).Decode(&user)
 
     if err != nil {
-        log.Printf("Error fetching user info: %v", err)
+        logFor(r.Context()).Error("Failed to fetch user", "error", err)
         http.Error(w, "Failed to fetch user info", http.StatusInternalServerError)
         return
     }
 
+    var meeting models.Session
+    if err := sessionCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": objectID})).Decode(&meeting); err != nil {
+        http.Error(w, "Session not found", http.StatusNotFound)
+        return
+    }
+
+    // 验证星级，上限由会话设置
+    if maxStars := sessionMaxStars(meeting); commentInput.Stars < 1 || commentInput.Stars > maxStars {
+        http.Error(w, fmt.Sprintf("Stars must be between 1 and %d", maxStars), http.StatusBadRequest)
+        return
+    }
+
+    // 每个用户对每个 summary 只能评分一次。这里只用快照给出更明确的错误，
+    // 并发的两次提交由下面更新的条件挡住
+    var summaryID primitive.ObjectID
+    if commentInput.SummaryID != "" {
+        summaryID, err = primitive.ObjectIDFromHex(commentInput.SummaryID)
+        if err != nil {
+            http.Error(w, "Invalid summary ID", http.StatusBadRequest)
+            return
+        }
+    }
+    found := summaryID.IsZero()
+    for _, summary := range meeting.Summaries {
+        if !summaryID.IsZero() && summary.ID != summaryID {
+            continue
+        }
+        found = true
+        for _, existing := range summary.Comments {
+            if existing.UserID == user.ID {
+                http.Error(w, "You have already rated this summary", http.StatusConflict)
+                return
+            }
+        }
+    }
+    if !found {
+        http.Error(w, "Summary not found", http.StatusNotFound)
+        return
+    }
+
     comment := models.Comment{
         ID:        primitive.NewObjectID(),
         UserID:    user.ID,           // 使用用户在 MongoDB 中的 _id
@@ -86,12 +121,13 @@ func PostCommentHandler(w http.ResponseWriter, r *http.Request) {
         Content:   commentInput.Content,
         Stars:     commentInput.Stars,
         CreatedAt: time.Now(),
+        Anonymous: meeting.AnonymousFeedback, // 匿名模式下仍保存作者，便于处理滥用
     }
 
-    // 更新数据库
-    filter := bson.M{"_id": objectID}
+    // 更新数据库，只在用户还没评过分时写入
+    filter := notDeleted(bson.M{"_id": objectID, "summaries.comments.user_id": bson.M{"$ne": user.ID}})
 
-    // 使用 $push 将新评论添加到最后一个 summary 的 comments 数组中
+    // 使用 $push 将新评论添加到每个 summary 的 comments 数组中
     update := bson.M{
         "$push": bson.M{
             "summaries.$[].comments": comment,
@@ -99,7 +135,19 @@ func PostCommentHandler(w http.ResponseWriter, r *http.Request) {
     }
 
     opts := options.Update().SetUpsert(false)
-    _, err = sessionCollection.UpdateOne(
+    if !summaryID.IsZero() {
+        filter = notDeleted(bson.M{"_id": objectID, "summaries": bson.M{"$elemMatch": bson.M{
+            "_id":              summaryID,
+            "comments.user_id": bson.M{"$ne": user.ID},
+        }}})
+        update = bson.M{
+            "$push": bson.M{
+                "summaries.$[s].comments": comment,
+            },
+        }
+        opts.SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"s._id": summaryID}}})
+    }
+    result, err := sessionCollection.UpdateOne(
         context.Background(),
         filter,
         update,
@@ -107,30 +155,48 @@ func PostCommentHandler(w http.ResponseWriter, r *http.Request) {
     )
 
     if err != nil {
-        log.Printf("Database error: %v", err)
+        logFor(r.Context()).Error("Failed to save comment", "error", err)
         http.Error(w, "Failed to add comment", http.StatusInternalServerError)
         return
     }
+    if result.ModifiedCount == 0 {
+        http.Error(w, "You have already rated this summary", http.StatusConflict)
+        return
+    }
 
-    // 广播带有用户信息的评论
-    broadcastComment := map[string]interface{}{
-        "type": "newComment",
-        "comment": map[string]interface{}{
-            "id":         comment.ID.Hex(),
-            "content":    comment.Content,
-            "stars":      comment.Stars,
-            "created_at": comment.CreatedAt,
-            "username":   comment.Username,     // 直接放在顶层
-            "avatar_url": comment.AvatarURL,    // 直接放在顶层
-            "user_id":    comment.UserID.Hex(), // 如果需要的话
-        },
+    // 广播带有用户信息的评论，匿名评论只对管理员显示作者
+    broadcastComment := func(client *MeetingClient) interface{} {
+        shown := redactComment(comment, clientIsAdmin(client))
+        return map[string]interface{}{
+            "type": "newComment",
+            "comment": map[string]interface{}{
+                "id":         shown.ID.Hex(),
+                "content":    shown.Content,
+                "stars":      shown.Stars,
+                "created_at": shown.CreatedAt,
+                "username":   shown.Username,     // 直接放在顶层
+                "avatar_url": shown.AvatarURL,    // 直接放在顶层
+                "user_id":    shown.UserID.Hex(), // 如果需要的话
+                "anonymous":  shown.Anonymous,
+                "summary_id": commentInput.SummaryID,
+            },
+        }
     }
 
-    // 使用单独的 goroutine 进行广播
+    logger := logFor(r.Context()).With("comment_id", comment.ID.Hex())
+    logger.Info("Comment added", "anonymous", comment.Anonymous)
+
+    // 使用单独的 goroutine 进行广播，记下事件序号便于和客户端日志对照
     go func() {
-        Broadcast(sessionID, broadcastComment)
+        seq := BroadcastEach(sessionID, broadcastComment)
+        logger.Debug("Comment broadcast", "seq", seq)
     }()
 
+    emitWebhookEvent(context.Background(), objectID, models.EventCommentPosted, map[string]interface{}{
+        "session_id": objectID,
+        "comment":    redactComment(comment, false),
+    })
+
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{
         "success": true,
@@ -151,12 +217,19 @@ func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
     }
 
     var session models.Session
-    err = sessionCollection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&session)
+    err = sessionCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": objectID})).Decode(&session)
     if err != nil {
         http.Error(w, "Session not found", http.StatusNotFound)
         return
     }
 
+    sessions := []models.Session{session}
+    if err := attachReactions(r.Context(), sessions); err != nil {
+        logFor(r.Context()).Error("Failed to load reactions", "error", err)
+    }
+    session = sessions[0]
+    redactSession(&session, requestIsAdmin(r))
+
     // 构建所有评论的列表
     allComments := []models.Comment{}
     for _, summary := range session.Summaries {
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var decisionCollection *mongo.Collection
+
+func InitDecisionCollection(client *mongo.Client) {
+	decisionCollection = client.Database("your-db-name").Collection("decisions")
+}
+
+// normalizeTags trims, lowercases and de-duplicates tags
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// participantUsernames maps usernames to participants, rejecting unknown names
+func participantUsernames(session models.Session, usernames []string) ([]string, bool) {
+	resolved := []string{}
+	for _, username := range usernames {
+		participant, ok := resolveAssignee(session, strings.TrimPrefix(strings.TrimSpace(username), "@"))
+		if !ok {
+			return nil, false
+		}
+		resolved = append(resolved, participant.Username)
+	}
+	return resolved, true
+}
+
+// CreateDecisionHandler records a decision for a session
+func CreateDecisionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	sessionID := vars["sessionId"]
+	objectID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	var input struct {
+		Statement   string   `json:"statement"`
+		Rationale   string   `json:"rationale"`
+		Tags        []string `json:"tags"`
+		AgreedBy    []string `json:"agreed_by"`
+		DissentedBy []string `json:"dissented_by"`
+		Supersedes  string   `json:"supersedes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(input.Statement) == "" {
+		http.Error(w, "Statement is required", http.StatusBadRequest)
+		return
+	}
+
+	var session models.Session
+	if err := sessionCollection.FindOne(r.Context(), notDeleted(bson.M{"_id": objectID})).Decode(&session); err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	decision := models.Decision{
+		ID:        primitive.NewObjectID(),
+		SessionID: objectID,
+		Statement: strings.TrimSpace(input.Statement),
+		Rationale: strings.TrimSpace(input.Rationale),
+		Tags:      normalizeTags(input.Tags),
+		CreatedBy: user.Username,
+		CreatedAt: time.Now(),
+	}
+
+	if decision.AgreedBy, ok = participantUsernames(session, input.AgreedBy); !ok {
+		http.Error(w, "agreed_by must only contain participants", http.StatusBadRequest)
+		return
+	}
+	if decision.DissentedBy, ok = participantUsernames(session, input.DissentedBy); !ok {
+		http.Error(w, "dissented_by must only contain participants", http.StatusBadRequest)
+		return
+	}
+
+	if input.Supersedes != "" {
+		supersededID, err := primitive.ObjectIDFromHex(input.Supersedes)
+		if err != nil {
+			http.Error(w, "Invalid supersedes ID", http.StatusBadRequest)
+			return
+		}
+		// 先占用被取代的决定，两个新决定不能同时取代同一个决定
+		result, err := decisionCollection.UpdateOne(r.Context(),
+			bson.M{"_id": supersededID, "session_id": objectID, "superseded_by": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"superseded_by": decision.ID}},
+		)
+		if err != nil {
+			logFor(r.Context()).Error("Failed to link superseded decision", "decision_id", decision.ID.Hex(), "error", err)
+			http.Error(w, "Failed to create decision", http.StatusInternalServerError)
+			return
+		}
+		if result.MatchedCount == 0 {
+			count, err := decisionCollection.CountDocuments(r.Context(), bson.M{"_id": supersededID, "session_id": objectID})
+			if err == nil && count == 0 {
+				http.Error(w, "Superseded decision not found in this session", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Decision has already been superseded", http.StatusConflict)
+			return
+		}
+		decision.Supersedes = &supersededID
+	}
+
+	if _, err := decisionCollection.InsertOne(r.Context(), decision); err != nil {
+		logFor(r.Context()).Error("Failed to create decision", "error", err)
+		if decision.Supersedes != nil {
+			// 新决定没有保存，释放占用
+			decisionCollection.UpdateOne(r.Context(),
+				bson.M{"_id": *decision.Supersedes, "superseded_by": decision.ID},
+				bson.M{"$unset": bson.M{"superseded_by": ""}},
+			)
+		}
+		http.Error(w, "Failed to create decision", http.StatusInternalServerError)
+		return
+	}
+
+	Broadcast(sessionID, map[string]interface{}{
+		"type":     "decisionRecorded",
+		"decision": decision,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(decision)
+}
+
+// GetSessionDecisionsHandler lists the decisions of a session
+func GetSessionDecisionsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	objectID, err := primitive.ObjectIDFromHex(vars["sessionId"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	findDecisions(w, r, bson.M{"session_id": objectID})
+}
+
+// SearchDecisionsHandler queries decisions across all sessions. Supported
+// filters are session_id, tag, from and to (YYYY-MM-DD) and q for a
+// case-insensitive text search on statement and rationale.
+func SearchDecisionsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := bson.M{}
+
+	if sessionID := query.Get("session_id"); sessionID != "" {
+		objectID, err := primitive.ObjectIDFromHex(sessionID)
+		if err != nil {
+			http.Error(w, "Invalid session ID", http.StatusBadRequest)
+			return
+		}
+		filter["session_id"] = objectID
+	}
+
+	if tag := query.Get("tag"); tag != "" {
+		filter["tags"] = strings.ToLower(tag)
+	}
+
+	createdAt := bson.M{}
+	if from := query.Get("from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			http.Error(w, "from must be a date in YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+		createdAt["$gte"] = t
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			http.Error(w, "to must be a date in YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+		createdAt["$lt"] = t.AddDate(0, 0, 1)
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"statement": pattern},
+			bson.M{"rationale": pattern},
+		}
+	}
+
+	findDecisions(w, r, filter)
+}
+
+func findDecisions(w http.ResponseWriter, r *http.Request, filter bson.M) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := decisionCollection.Find(r.Context(), filter, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch decisions", http.StatusInternalServerError)
+		return
+	}
+	decisions := []models.Decision{}
+	if err := cursor.All(r.Context(), &decisions); err != nil {
+		http.Error(w, "Failed to parse decisions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(decisions)
+}
@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var eventCollection *mongo.Collection
+
+const (
+	// 每个会议室在内存中保留的事件数
+	defaultEventLogSize = 500
+	// 持久化的事件保留时长
+	persistedEventTTL = 24 * time.Hour
+)
+
+func InitEventLogCollection(client *mongo.Client) {
+	eventCollection = client.Database("your-db-name").Collection("session_events")
+	if !persistEvents() {
+		return
+	}
+
+	_, err := eventCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "session_id", Value: 1}, {Key: "seq", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(persistedEventTTL.Seconds())),
+		},
+	})
+	if err != nil {
+		dbLog.Error("Failed to create indexes", "collection", "session_events", "error", err)
+	}
+}
+
+// persistEvents reports whether room events are also written to MongoDB, so
+// clients can resume across server restarts and after the room emptied
+func persistEvents() bool {
+	return os.Getenv("EVENT_LOG_PERSIST") == "true"
+}
+
+func eventLogSize() int {
+	if value := os.Getenv("EVENT_LOG_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err == nil && size > 0 {
+			return size
+		}
+		appLog.Warn("Invalid EVENT_LOG_SIZE, using default", "value", value)
+	}
+	return defaultEventLogSize
+}
+
+// roomEvent is a broadcast with its sequence number. Events built per
+// recipient keep their builder so a replay shows each client its own view.
+type roomEvent struct {
+	seq     int64
+	message interface{}
+	build   func(client *MeetingClient) interface{}
+}
+
+func (event roomEvent) payload(client *MeetingClient) interface{} {
+	if event.build != nil {
+		return event.build(client)
+	}
+	return event.message
+}
+
+// eventLog numbers the events of a room and keeps the most recent ones. The
+// lock is held while an event is sent, so clients receive events in order.
+type eventLog struct {
+	sync.Mutex
+	epoch  string
+	seq    int64
+	events []roomEvent
+	load   sync.Once
+}
+
+var eventLogs = struct {
+	sync.Mutex
+	sessions map[string]*eventLog
+}{sessions: make(map[string]*eventLog)}
+
+// eventLogFor returns the event log of a session, creating it while the room
+// is open. Without persistence there is nothing to log once everybody left.
+func eventLogFor(sessionID string) *eventLog {
+	_, hasRoom := findRoom(sessionID)
+
+	eventLogs.Lock()
+	defer eventLogs.Unlock()
+	history, ok := eventLogs.sessions[sessionID]
+	if !ok {
+		if !hasRoom && !persistEvents() {
+			return nil
+		}
+		history = &eventLog{}
+		eventLogs.sessions[sessionID] = history
+	}
+	return history
+}
+
+// openEventLog returns the event log of a room a client is about to join,
+// creating it even before the room exists
+func openEventLog(sessionID string) *eventLog {
+	eventLogs.Lock()
+	defer eventLogs.Unlock()
+	history, ok := eventLogs.sessions[sessionID]
+	if !ok {
+		history = &eventLog{}
+		eventLogs.sessions[sessionID] = history
+	}
+	return history
+}
+
+func dropEventLog(sessionID string) {
+	eventLogs.Lock()
+	delete(eventLogs.sessions, sessionID)
+	eventLogs.Unlock()
+}
+
+// init sets the epoch and the last sequence number. In memory the epoch is new
+// for every log; persisted logs continue where the stored events end.
+// Must be called with the log locked.
+func (history *eventLog) init(sessionID string) {
+	history.load.Do(func() {
+		if !persistEvents() {
+			buf := make([]byte, 8)
+			rand.Read(buf)
+			history.epoch = hex.EncodeToString(buf)
+			return
+		}
+
+		history.epoch = sessionID
+		var last models.RoomEvent
+		err := eventCollection.FindOne(context.Background(), bson.M{"session_id": sessionID},
+			options.FindOne().SetSort(bson.M{"seq": -1})).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			wsLog.Error("Failed to load last event", "session_id", sessionID, "error", err)
+		}
+		history.seq = last.Seq
+	})
+}
+
+// encodeEvent adds the sequence number to a message
+func encodeEvent(seq int64, message interface{}) ([]byte, error) {
+	if fields, ok := message.(map[string]interface{}); ok {
+		withSeq := make(map[string]interface{}, len(fields)+1)
+		for key, value := range fields {
+			withSeq[key] = value
+		}
+		withSeq["seq"] = seq
+		return json.Marshal(withSeq)
+	}
+	return json.Marshal(map[string]interface{}{"seq": seq, "data": message})
+}
+
+// publish assigns the next sequence number to an event, records it and sends
+// it to everyone in the room. It returns the sequence number, or 0 when the
+// event could not be sent. Under the lock the event is only queued for each
+// client, which keeps the order without waiting on slow connections.
+func publish(sessionID string, event roomEvent) int64 {
+	history := eventLogFor(sessionID)
+	if history == nil {
+		return 0
+	}
+
+	history.Lock()
+	history.init(sessionID)
+	history.seq++
+	event.seq = history.seq
+	history.events = append(history.events, event)
+	if size := eventLogSize(); len(history.events) > size {
+		history.events = history.events[len(history.events)-size:]
+	}
+
+	var shared []byte
+	if event.build == nil {
+		data, err := encodeEvent(event.seq, event.message)
+		if err != nil {
+			history.Unlock()
+			wsLog.Error("Failed to encode event", "session_id", sessionID, "error", err)
+			return 0
+		}
+		shared = data
+	}
+	clients := roomClients(sessionID)
+	broadcastFanout.WithLabelValues("event").Observe(float64(len(clients)))
+	wsLog.Debug("Publishing event", "session_id", sessionID, "seq", event.seq, "recipients", len(clients))
+	for _, client := range clients {
+		data := shared
+		if data == nil {
+			var err error
+			if data, err = encodeEvent(event.seq, event.build(client)); err != nil {
+				client.log().Error("Failed to encode event", "seq", event.seq, "error", err)
+				continue
+			}
+		}
+		if err := client.deliver(event.seq, data); err != nil {
+			client.log().Warn("Failed to send event", "seq", event.seq, "error", err)
+			broadcastWriteErrors.WithLabelValues("event").Inc()
+		}
+	}
+	epoch := history.epoch
+	history.Unlock()
+
+	if persistEvents() {
+		persistEvent(sessionID, epoch, event)
+		// 没人在会议室时不保留内存日志，下次从数据库继续编号
+		if _, ok := findRoom(sessionID); !ok {
+			dropEventLog(sessionID)
+		}
+	}
+	return event.seq
+}
+
+// persistEvent stores an event as a participant without admin rights sees it
+func persistEvent(sessionID, epoch string, event roomEvent) {
+	data, err := encodeEvent(event.seq, event.payload(&MeetingClient{sessionID: sessionID}))
+	if err != nil {
+		wsLog.Error("Failed to encode event", "session_id", sessionID, "seq", event.seq, "error", err)
+		return
+	}
+	_, err = eventCollection.InsertOne(context.Background(), models.RoomEvent{
+		SessionID: sessionID,
+		Epoch:     epoch,
+		Seq:       event.seq,
+		Data:      string(data),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		dbLog.Error("Failed to persist event", "session_id", sessionID, "seq", event.seq, "error", err)
+	}
+}
+
+// encodedEvent is an event ready to be written to a client
+type encodedEvent struct {
+	seq  int64
+	data []byte
+}
+
+// missedEvents returns the encoded events after lastSeq for the client, or
+// false if some of them are no longer available. Must be called with the log
+// locked.
+func (history *eventLog) missedEvents(client *MeetingClient, lastSeq int64) ([]encodedEvent, bool) {
+	if lastSeq == history.seq {
+		return nil, true
+	}
+
+	if len(history.events) > 0 && history.events[0].seq <= lastSeq+1 {
+		var missed []encodedEvent
+		for _, event := range history.events {
+			if event.seq <= lastSeq {
+				continue
+			}
+			data, err := encodeEvent(event.seq, event.payload(client))
+			if err != nil {
+				return nil, false
+			}
+			missed = append(missed, encodedEvent{event.seq, data})
+		}
+		return missed, true
+	}
+
+	if !persistEvents() {
+		return nil, false
+	}
+	cursor, err := eventCollection.Find(context.Background(),
+		bson.M{"session_id": client.room(), "epoch": history.epoch, "seq": bson.M{"$gt": lastSeq}},
+		options.Find().SetSort(bson.M{"seq": 1}).SetLimit(int64(eventLogSize())))
+	if err != nil {
+		client.log().Error("Failed to load missed events", "error", err)
+		return nil, false
+	}
+	var stored []models.RoomEvent
+	if err := cursor.All(context.Background(), &stored); err != nil {
+		return nil, false
+	}
+	// 只在事件连续且一直补到最新时才回放
+	if len(stored) == 0 || stored[0].Seq != lastSeq+1 || stored[len(stored)-1].Seq != history.seq {
+		return nil, false
+	}
+	missed := make([]encodedEvent, 0, len(stored))
+	for _, event := range stored {
+		missed = append(missed, encodedEvent{event.Seq, []byte(event.Data)})
+	}
+	return missed, true
+}
+
+// resumePoint is the last event a reconnecting client received
+type resumePoint struct {
+	epoch   string
+	lastSeq int64
+}
+
+// enterRoom adds a client to its room. The welcome message, completed with
+// the room's epoch and seq, and the events missed since resume, if given, are
+// queued before the client is added, all with the event log locked: events
+// published meanwhile wait for the lock and reach the client after them.
+func enterRoom(client *MeetingClient, welcome map[string]interface{}, resume *resumePoint) error {
+	history := openEventLog(client.room())
+	history.Lock()
+	defer history.Unlock()
+	history.init(client.room())
+
+	welcome["epoch"], welcome["seq"] = history.epoch, history.seq
+	if err := client.send(welcome); err != nil {
+		return err
+	}
+	if resume != nil {
+		history.replay(client, resume.epoch, resume.lastSeq)
+	}
+	client.joinedSeq = history.seq
+	joinRoom(client)
+	return nil
+}
+
+// handleResume replays the events a reconnecting client missed since lastSeq,
+// or asks it to reload the session when they can't be replayed
+func handleResume(client *MeetingClient, msg map[string]interface{}) {
+	var input struct {
+		Epoch   string `json:"epoch"`
+		LastSeq int64  `json:"lastSeq"`
+	}
+	if err := decodeMessage(msg, &input); err != nil {
+		sendError(client, "resume", "Invalid resume request")
+		return
+	}
+	resumeClient(client, input.Epoch, input.LastSeq)
+}
+
+// resumeClient replays the events after lastSeq of the given epoch to a
+// client that is already in the room. Events published since it joined were
+// sent to it live, so older ones can't be replayed in order any more and the
+// client is asked to reload instead; connecting with the resume point avoids
+// this.
+func resumeClient(client *MeetingClient, epoch string, lastSeq int64) {
+	history := eventLogFor(client.room())
+	if history == nil {
+		return
+	}
+	history.Lock()
+	defer history.Unlock()
+	history.init(client.room())
+
+	if lastSeq < client.joinedSeq && history.seq > client.joinedSeq {
+		history.resync(client, "Events arrived before the resume request")
+		return
+	}
+	history.replay(client, epoch, lastSeq)
+}
+
+// resync asks the client to reload the session. Must be called with the log
+// locked.
+func (history *eventLog) resync(client *MeetingClient, reason string) {
+	if err := client.send(map[string]interface{}{
+		"type":   "resync",
+		"epoch":  history.epoch,
+		"seq":    history.seq,
+		"reason": reason,
+	}); err != nil {
+		client.log().Warn("Failed to send resync", "error", err)
+	}
+}
+
+// replay queues the events after lastSeq of the given epoch for the client,
+// followed by "resumed", or asks it to resync when they are gone. Must be
+// called with the log locked.
+func (history *eventLog) replay(client *MeetingClient, epoch string, lastSeq int64) {
+	resync := func(reason string) { history.resync(client, reason) }
+	if epoch != history.epoch || lastSeq > history.seq {
+		resync("The session was restarted")
+		return
+	}
+	missed, ok := history.missedEvents(client, lastSeq)
+	if !ok {
+		resync("Too many events were missed")
+		return
+	}
+
+	for _, event := range missed {
+		if err := client.deliver(event.seq, event.data); err != nil {
+			client.log().Warn("Failed to replay events", "error", err)
+			return
+		}
+	}
+	if err := client.send(map[string]interface{}{
+		"type":     "resumed",
+		"epoch":    history.epoch,
+		"seq":      history.seq,
+		"replayed": len(missed),
+	}); err != nil {
+		client.log().Warn("Failed to send resumed", "error", err)
+	}
+}
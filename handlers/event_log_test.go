@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// testStreamClient is an event stream subscriber of a room
+func testStreamClient(sessionID string, userID int) *MeetingClient {
+	return &MeetingClient{sessionID: sessionID, userID: userID, stream: newEventStream()}
+}
+
+// drainFrames returns the type and seq of the queued messages of a client
+func drainFrames(t *testing.T, client *MeetingClient) []string {
+	t.Helper()
+	var got []string
+	for {
+		select {
+		case frame := <-client.stream.frames:
+			var msg struct {
+				Type string `json:"type"`
+				Seq  int64  `json:"seq"`
+			}
+			if err := json.Unmarshal(frame.data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, fmt.Sprintf("%s/%d", msg.Type, msg.Seq))
+		default:
+			return got
+		}
+	}
+}
+
+func forgetRoom(sessionID string) {
+	meetingRooms.Lock()
+	delete(meetingRooms.rooms, sessionID)
+	meetingRooms.Unlock()
+	dropEventLog(sessionID)
+}
+
+func TestResumingClientGetsMissedEventsBeforeLiveOnes(t *testing.T) {
+	const sessionID = "event-log-order"
+	t.Cleanup(func() { forgetRoom(sessionID) })
+
+	first := testStreamClient(sessionID, 1)
+	if err := enterRoom(first, map[string]interface{}{"type": "connected"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		Broadcast(sessionID, map[string]interface{}{"type": "note"})
+	}
+	epoch := eventLogFor(sessionID).epoch
+
+	second := testStreamClient(sessionID, 2)
+	if err := enterRoom(second, map[string]interface{}{"type": "connected"}, &resumePoint{epoch: epoch, lastSeq: 1}); err != nil {
+		t.Fatal(err)
+	}
+	Broadcast(sessionID, map[string]interface{}{"type": "note"})
+
+	want := []string{"connected/3", "note/2", "note/3", "resumed/3", "note/4"}
+	got := drainFrames(t, second)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLateResumeRequestAsksForResync(t *testing.T) {
+	const sessionID = "event-log-late-resume"
+	t.Cleanup(func() { forgetRoom(sessionID) })
+
+	if err := enterRoom(testStreamClient(sessionID, 1), map[string]interface{}{"type": "connected"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	Broadcast(sessionID, map[string]interface{}{"type": "note"})
+	epoch := eventLogFor(sessionID).epoch
+
+	client := testStreamClient(sessionID, 2)
+	if err := enterRoom(client, map[string]interface{}{"type": "connected"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	Broadcast(sessionID, map[string]interface{}{"type": "note"})
+
+	// 事件 2 已经实时收到，现在补发事件 1 会乱序
+	resumeClient(client, epoch, 0)
+	got := drainFrames(t, client)
+	if len(got) != 3 || got[0] != "connected/1" || got[1] != "note/2" || got[2] != "resync/2" {
+		t.Errorf("got %v, want connected, note and resync", got)
+	}
+}
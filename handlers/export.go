@@ -0,0 +1,652 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exportDocument is the format-independent view of a meeting record that the
+// Markdown, HTML and PDF renderers share.
+type exportDocument struct {
+	Title        string
+	CreatedAt    time.Time
+	ExportedAt   time.Time
+	Participants []string
+	Summaries    []exportSummary
+	Decisions    []exportDecision
+	Chat         []exportChatMessage
+	Retro        []exportRetroColumn
+	Minutes      string
+}
+
+type exportSummary struct {
+	Author    string
+	Content   string
+	CreatedAt time.Time
+	Comments  []exportComment
+}
+
+type exportDecision struct {
+	ID          string
+	Statement   string
+	Rationale   string
+	Tags        []string
+	AgreedBy    []string
+	DissentedBy []string
+	Supersedes  string
+	CreatedAt   time.Time
+}
+
+type exportComment struct {
+	Author    string
+	Content   string
+	Stars     int
+	MaxStars  int
+	CreatedAt time.Time
+}
+
+// exportRetroColumn holds the revealed cards of a retro column. Cards grouped
+// under another card follow it with Grouped set.
+type exportRetroColumn struct {
+	Title string
+	Cards []exportRetroCard
+}
+
+type exportRetroCard struct {
+	Author  string
+	Content string
+	Votes   int
+	Grouped bool
+}
+
+type exportChatMessage struct {
+	Author    string
+	Content   string
+	Edited    bool
+	CreatedAt time.Time
+}
+
+var exportContentTypes = map[string]string{
+	"md":   "text/markdown; charset=utf-8",
+	"html": "text/html; charset=utf-8",
+	"pdf":  "application/pdf",
+	"json": "application/json",
+}
+
+// loadSessionExport 读取会话及其会议纪要
+func loadSessionExport(ctx context.Context, objectID primitive.ObjectID) (*models.SessionExport, error) {
+	var session models.Session
+	if err := sessionCollection.FindOne(ctx, notDeleted(bson.M{"_id": objectID})).Decode(&session); err != nil {
+		return nil, err
+	}
+
+	export := &models.SessionExport{
+		Version:    models.SessionExportVersion,
+		ExportedAt: time.Now(),
+		Session:    session,
+	}
+
+	var minutes models.Minutes
+	err := minutesCollection.FindOne(ctx, bson.M{"session_id": objectID}).Decode(&minutes)
+	if err == nil {
+		export.Minutes = &minutes
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := decisionCollection.Find(ctx, bson.M{"session_id": objectID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &export.Decisions); err != nil {
+		return nil, err
+	}
+
+	// 已删除的聊天消息不导出
+	cursor, err = chatCollection.Find(ctx, bson.M{"session_id": objectID, "deleted_at": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &export.Chat); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+func buildExportDocument(export *models.SessionExport) exportDocument {
+	session := export.Session
+	doc := exportDocument{
+		Title:      session.Name,
+		CreatedAt:  session.CreatedAt,
+		ExportedAt: export.ExportedAt,
+	}
+	if export.Minutes != nil {
+		doc.Minutes = stripActionItemMarkers(export.Minutes.Content)
+	}
+
+	names := make(map[primitive.ObjectID]string)
+	for _, participant := range session.Participants {
+		names[participant.ID] = participant.Username
+		doc.Participants = append(doc.Participants, participant.Username)
+	}
+
+	for _, summary := range session.Summaries {
+		// 跳过创建会话时生成的空白 summary
+		if summary.Content == "" && len(summary.Comments) == 0 {
+			continue
+		}
+		author, ok := names[summary.ParticipantID]
+		if !ok {
+			author = "General"
+		}
+		s := exportSummary{
+			Author:    author,
+			Content:   summary.Content,
+			CreatedAt: summary.CreatedAt,
+		}
+		for _, comment := range summary.Comments {
+			s.Comments = append(s.Comments, exportComment{
+				Author:    comment.Username,
+				Content:   comment.Content,
+				Stars:     comment.Stars,
+				MaxStars:  sessionMaxStars(session),
+				CreatedAt: comment.CreatedAt,
+			})
+		}
+		doc.Summaries = append(doc.Summaries, s)
+	}
+
+	for _, decision := range export.Decisions {
+		d := exportDecision{
+			ID:          decision.ID.Hex(),
+			Statement:   decision.Statement,
+			Rationale:   decision.Rationale,
+			Tags:        decision.Tags,
+			AgreedBy:    decision.AgreedBy,
+			DissentedBy: decision.DissentedBy,
+			CreatedAt:   decision.CreatedAt,
+		}
+		if decision.Supersedes != nil {
+			d.Supersedes = decision.Supersedes.Hex()
+		}
+		doc.Decisions = append(doc.Decisions, d)
+	}
+
+	for _, message := range export.Chat {
+		doc.Chat = append(doc.Chat, exportChatMessage{
+			Author:    message.Username,
+			Content:   message.Content,
+			Edited:    message.EditedAt != nil,
+			CreatedAt: message.CreatedAt,
+		})
+	}
+
+	if session.Retro != nil {
+		doc.Retro = exportRetro(session.Retro)
+	}
+
+	return doc
+}
+
+// exportRetro lists the revealed cards of a board by column, each group
+// after the card that heads it
+func exportRetro(board *models.RetroBoard) []exportRetroColumn {
+	votes := retroVotes(board)
+	grouped := make(map[primitive.ObjectID][]models.RetroCard)
+	for _, card := range board.Cards {
+		if card.Revealed && !card.GroupID.IsZero() {
+			grouped[card.GroupID] = append(grouped[card.GroupID], card)
+		}
+	}
+
+	columns := make([]exportRetroColumn, 0, len(board.Columns))
+	for _, column := range board.Columns {
+		c := exportRetroColumn{Title: column.Title}
+		for _, card := range board.Cards {
+			if card.ColumnID != column.ID || !card.Revealed || !card.GroupID.IsZero() {
+				continue
+			}
+			c.Cards = append(c.Cards, exportRetroCard{Author: card.Author, Content: card.Content, Votes: votes[card.ID]})
+			for _, member := range grouped[card.ID] {
+				c.Cards = append(c.Cards, exportRetroCard{Author: member.Author, Content: member.Content, Grouped: true})
+			}
+		}
+		columns = append(columns, c)
+	}
+	return columns
+}
+
+// renderExport renders a meeting record in the requested format
+func renderExport(export *models.SessionExport, format string) ([]byte, error) {
+	if format == "json" {
+		return json.MarshalIndent(export, "", "  ")
+	}
+
+	doc := buildExportDocument(export)
+	switch format {
+	case "md":
+		return []byte(renderExportMarkdown(doc)), nil
+	case "html":
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, "export.html", doc); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "pdf":
+		return renderExportPDF(doc)
+	}
+	return nil, fmt.Errorf("unsupported export format %q", format)
+}
+
+// markdownEscape escapes the lines of user-written text that the Markdown
+// import would read as a heading or a "- Key: value" field, so that an
+// exported session imports unchanged. Lines starting with a backslash are
+// escaped too, so that unescaping is unambiguous.
+func markdownEscape(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "- ") || strings.HasPrefix(line, `\`) {
+			lines[i] = `\` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func renderExportMarkdown(doc exportDocument) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n\n", doc.Title)
+	fmt.Fprintf(&b, "- Created: %s\n", doc.CreatedAt.Format(time.RFC1123))
+	fmt.Fprintf(&b, "- Exported: %s\n", doc.ExportedAt.Format(time.RFC1123))
+	if len(doc.Participants) > 0 {
+		fmt.Fprintf(&b, "- Participants: %s\n", strings.Join(doc.Participants, ", "))
+	}
+
+	b.WriteString("\n## Summaries\n")
+	if len(doc.Summaries) == 0 {
+		b.WriteString("\n_No summaries._\n")
+	}
+	for _, summary := range doc.Summaries {
+		fmt.Fprintf(&b, "\n### %s\n\n", summary.Author)
+		if summary.Content != "" {
+			b.WriteString(markdownEscape(summary.Content))
+			b.WriteString("\n")
+		}
+		if len(summary.Comments) > 0 {
+			b.WriteString("\n#### Comments\n\n")
+		}
+		for _, comment := range summary.Comments {
+			// 多行评论的后续行缩进两格，仍属于同一个列表项
+			lines := strings.Split(comment.Content, "\n")
+			fmt.Fprintf(&b, "- **%s** (%s, %s): %s\n",
+				comment.Author,
+				starsLabel(comment.Stars, comment.MaxStars),
+				comment.CreatedAt.Format(time.RFC3339),
+				lines[0],
+			)
+			for _, line := range lines[1:] {
+				fmt.Fprintf(&b, "  %s\n", line)
+			}
+		}
+	}
+
+	if len(doc.Decisions) > 0 {
+		b.WriteString("\n## Decisions\n")
+	}
+	for _, decision := range doc.Decisions {
+		fmt.Fprintf(&b, "\n### %s\n\n", decision.Statement)
+		fmt.Fprintf(&b, "- ID: %s\n", decision.ID)
+		fmt.Fprintf(&b, "- Date: %s\n", decision.CreatedAt.Format(time.RFC3339))
+		if len(decision.Tags) > 0 {
+			fmt.Fprintf(&b, "- Tags: %s\n", strings.Join(decision.Tags, ", "))
+		}
+		if len(decision.AgreedBy) > 0 {
+			fmt.Fprintf(&b, "- Agreed: %s\n", strings.Join(decision.AgreedBy, ", "))
+		}
+		if len(decision.DissentedBy) > 0 {
+			fmt.Fprintf(&b, "- Dissented: %s\n", strings.Join(decision.DissentedBy, ", "))
+		}
+		if decision.Supersedes != "" {
+			fmt.Fprintf(&b, "- Supersedes: %s\n", decision.Supersedes)
+		}
+		if decision.Rationale != "" {
+			fmt.Fprintf(&b, "\n%s\n", markdownEscape(decision.Rationale))
+		}
+	}
+
+	if len(doc.Retro) > 0 {
+		b.WriteString("\n## Retro\n")
+	}
+	for _, column := range doc.Retro {
+		fmt.Fprintf(&b, "\n### %s\n\n", column.Title)
+		if len(column.Cards) == 0 {
+			b.WriteString("_No cards._\n")
+		}
+		for _, card := range column.Cards {
+			indent := ""
+			if card.Grouped {
+				indent = "  "
+			}
+			fmt.Fprintf(&b, "%s- **%s**: %s%s\n", indent, card.Author,
+				strings.ReplaceAll(card.Content, "\n", " "), votesLabel(card.Votes))
+		}
+	}
+
+	if len(doc.Chat) > 0 {
+		b.WriteString("\n## Chat\n\n")
+	}
+	for _, message := range doc.Chat {
+		fmt.Fprintf(&b, "- %s **%s**: %s%s\n",
+			message.CreatedAt.Format("2006-01-02 15:04"),
+			message.Author,
+			strings.ReplaceAll(message.Content, "\n", " "),
+			editedLabel(message.Edited),
+		)
+	}
+
+	b.WriteString("\n## Minutes\n\n")
+	if doc.Minutes == "" {
+		b.WriteString("_No minutes recorded._\n")
+	} else {
+		b.WriteString(doc.Minutes)
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// renderExportPDF fails with errPDFUnsupportedText rather than export a
+// record whose non-Latin text would come out as question marks
+func renderExportPDF(doc exportDocument) ([]byte, error) {
+	pdf := newPDFDocument()
+
+	pdf.Text(doc.Title, 18, true, 0)
+	pdf.Space(4)
+	pdf.Text("Created: "+doc.CreatedAt.Format(time.RFC1123), 10, false, 0)
+	pdf.Text("Exported: "+doc.ExportedAt.Format(time.RFC1123), 10, false, 0)
+	if len(doc.Participants) > 0 {
+		pdf.Text("Participants: "+strings.Join(doc.Participants, ", "), 10, false, 0)
+	}
+
+	pdf.Space(10)
+	pdf.Text("Summaries", 14, true, 0)
+	if len(doc.Summaries) == 0 {
+		pdf.Text("No summaries.", 11, false, 0)
+	}
+	for _, summary := range doc.Summaries {
+		pdf.Space(6)
+		pdf.Text(summary.Author, 12, true, 0)
+		if summary.Content != "" {
+			pdf.Text(summary.Content, 11, false, 0)
+		}
+		for _, comment := range summary.Comments {
+			pdf.Text(fmt.Sprintf("%s (%s): %s", comment.Author, starsLabel(comment.Stars, comment.MaxStars), comment.Content), 10, false, 16)
+		}
+	}
+
+	if len(doc.Decisions) > 0 {
+		pdf.Space(10)
+		pdf.Text("Decisions", 14, true, 0)
+	}
+	for _, decision := range doc.Decisions {
+		pdf.Space(6)
+		pdf.Text(decision.Statement, 12, true, 0)
+		pdf.Text("Date: "+decision.CreatedAt.Format(time.RFC1123), 10, false, 0)
+		if len(decision.Tags) > 0 {
+			pdf.Text("Tags: "+strings.Join(decision.Tags, ", "), 10, false, 0)
+		}
+		if len(decision.AgreedBy) > 0 {
+			pdf.Text("Agreed: "+strings.Join(decision.AgreedBy, ", "), 10, false, 0)
+		}
+		if len(decision.DissentedBy) > 0 {
+			pdf.Text("Dissented: "+strings.Join(decision.DissentedBy, ", "), 10, false, 0)
+		}
+		if decision.Rationale != "" {
+			pdf.Text(decision.Rationale, 11, false, 0)
+		}
+	}
+
+	if len(doc.Retro) > 0 {
+		pdf.Space(10)
+		pdf.Text("Retro", 14, true, 0)
+	}
+	for _, column := range doc.Retro {
+		pdf.Space(6)
+		pdf.Text(column.Title, 12, true, 0)
+		for _, card := range column.Cards {
+			indent := 0.0
+			if card.Grouped {
+				indent = 16
+			}
+			pdf.Text(fmt.Sprintf("%s: %s%s", card.Author, card.Content, votesLabel(card.Votes)), 10, false, indent)
+		}
+	}
+
+	if len(doc.Chat) > 0 {
+		pdf.Space(10)
+		pdf.Text("Chat", 14, true, 0)
+	}
+	for _, message := range doc.Chat {
+		pdf.Text(fmt.Sprintf("%s %s: %s%s", message.CreatedAt.Format("2006-01-02 15:04"), message.Author, message.Content, editedLabel(message.Edited)), 10, false, 0)
+	}
+
+	pdf.Space(10)
+	pdf.Text("Minutes", 14, true, 0)
+	if doc.Minutes == "" {
+		pdf.Text("No minutes recorded.", 11, false, 0)
+	} else {
+		pdf.Text(doc.Minutes, 11, false, 0)
+	}
+
+	if pdf.Unsupported {
+		return nil, errPDFUnsupportedText
+	}
+	return pdf.Bytes(), nil
+}
+
+func starsLabel(stars, maxStars int) string {
+	return fmt.Sprintf("%d/%d stars", stars, maxStars)
+}
+
+func votesLabel(votes int) string {
+	switch votes {
+	case 0:
+		return ""
+	case 1:
+		return " (1 vote)"
+	}
+	return fmt.Sprintf(" (%d votes)", votes)
+}
+
+func editedLabel(edited bool) string {
+	if edited {
+		return " (edited)"
+	}
+	return ""
+}
+
+// exportFileName builds a file name that is safe to use in archives and
+// Content-Disposition headers.
+func exportFileName(session models.Session, format string) string {
+	slug := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '-'
+	}, session.Name)
+	slug = strings.Trim(slug, "-")
+	if slug == "" {
+		slug = "session"
+	}
+	return fmt.Sprintf("%s-%s-%s.%s", session.CreatedAt.Format("2006-01-02"), slug, session.ID.Hex(), format)
+}
+
+func exportFormat(r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "md"
+	}
+	_, ok := exportContentTypes[format]
+	return format, ok
+}
+
+// ExportSessionHandler exports a single session with its minutes
+func ExportSessionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["sessionId"]
+
+	objectID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	format, ok := exportFormat(r)
+	if !ok {
+		http.Error(w, "Format must be one of md, html, pdf, json", http.StatusBadRequest)
+		return
+	}
+
+	export, err := loadSessionExport(r.Context(), objectID)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logFor(r.Context()).Error("Failed to load session for export", "error", err)
+		http.Error(w, "Failed to export session", http.StatusInternalServerError)
+		return
+	}
+
+	redactSession(&export.Session, requestIsAdmin(r))
+	body, err := renderExport(export, format)
+	if err == errPDFUnsupportedText {
+		http.Error(w, "PDF export can't show some characters of this session, such as emoji; export it as html or md instead", http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		logFor(r.Context()).Error("Failed to render export", "format", format, "error", err)
+		http.Error(w, "Failed to export session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName(export.Session, format)))
+	w.Write(body)
+}
+
+// ExportSessionsHandler zips every session created in a date range
+func ExportSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	format, ok := exportFormat(r)
+	if !ok {
+		http.Error(w, "Format must be one of md, html, pdf, json", http.StatusBadRequest)
+		return
+	}
+
+	createdAt := bson.M{}
+	if from := r.URL.Query().Get("from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			http.Error(w, "from must be a date in YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+		createdAt["$gte"] = t
+	}
+	if to := r.URL.Query().Get("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			http.Error(w, "to must be a date in YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+		// to 是包含当天的
+		createdAt["$lt"] = t.AddDate(0, 0, 1)
+	}
+
+	filter := notDeleted(bson.M{})
+	if len(createdAt) > 0 {
+		filter["createdat"] = createdAt
+	}
+
+	cursor, err := sessionCollection.Find(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	var sessions []models.Session
+	if err = cursor.All(r.Context(), &sessions); err != nil {
+		http.Error(w, "Failed to parse sessions", http.StatusInternalServerError)
+		return
+	}
+
+	admin := requestIsAdmin(r)
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	// PDF 无法显示的会话改为导出 HTML，并在压缩包中列出
+	var fallbacks []string
+	for _, session := range sessions {
+		export, err := loadSessionExport(r.Context(), session.ID)
+		if err != nil {
+			logFor(r.Context()).Error("Failed to load session for export", "session_id", session.ID.Hex(), "error", err)
+			http.Error(w, "Failed to export sessions", http.StatusInternalServerError)
+			return
+		}
+
+		redactSession(&export.Session, admin)
+		sessionFormat := format
+		body, err := renderExport(export, format)
+		if err == errPDFUnsupportedText {
+			sessionFormat = "html"
+			fallbacks = append(fallbacks, exportFileName(session, sessionFormat))
+			body, err = renderExport(export, sessionFormat)
+		}
+		if err != nil {
+			logFor(r.Context()).Error("Failed to render export", "session_id", session.ID.Hex(), "format", format, "error", err)
+			http.Error(w, "Failed to export sessions", http.StatusInternalServerError)
+			return
+		}
+
+		f, err := archive.Create(exportFileName(session, sessionFormat))
+		if err == nil {
+			_, err = f.Write(body)
+		}
+		if err != nil {
+			http.Error(w, "Failed to build archive", http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(fallbacks) > 0 {
+		f, err := archive.Create("NOT-PDF.txt")
+		if err == nil {
+			_, err = fmt.Fprintf(f, "These sessions have characters the PDF fonts can't show, such as emoji, and were exported as HTML:\n\n%s\n",
+				strings.Join(fallbacks, "\n"))
+		}
+		if err != nil {
+			http.Error(w, "Failed to build archive", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		http.Error(w, "Failed to build archive", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "sessions-export.zip"))
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const anonymousName = "Anonymous"
+
+// redactComment hides the author of an anonymous comment unless the viewer
+// is a workspace admin
+func redactComment(comment models.Comment, admin bool) models.Comment {
+	if !comment.Anonymous || admin {
+		return comment
+	}
+	comment.UserID = primitive.NilObjectID
+	comment.Username = anonymousName
+	comment.AvatarURL = ""
+	return comment
+}
+
+// redactSession applies redactComment to every comment of the session
+func redactSession(session *models.Session, admin bool) {
+	for i := range session.Summaries {
+		for j := range session.Summaries[i].Comments {
+			session.Summaries[i].Comments[j] = redactComment(session.Summaries[i].Comments[j], admin)
+		}
+	}
+	redactRetro(session.Retro)
+	redactPoker(session.Poker)
+}
+
+// requestIsAdmin reports whether the request comes from a workspace admin
+func requestIsAdmin(r *http.Request) bool {
+	user, ok := currentUser(r)
+	return ok && isWorkspaceAdmin(*user)
+}
+
+// clientIsAdmin reports whether a WebSocket client is a workspace admin
+func clientIsAdmin(client *MeetingClient) bool {
+	return isWorkspaceAdmin(authUser{Username: client.username})
+}
+
+// UpdateSessionSettingsHandler changes the feedback and timing settings of a
+// session. Only the facilitator may change them.
+func UpdateSessionSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+
+	authSession, _ := store.Get(r, "auth-session")
+	userID, _ := authSession.Values["user_id"].(int)
+	if !isFacilitator(session, userID) {
+		http.Error(w, "Only the facilitator can change session settings", http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		AnonymousFeedback *bool `json:"anonymous_feedback"`
+		SpeakerTimebox    *int  `json:"speaker_timebox"`
+		MaxStars          *int  `json:"max_stars"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if input.AnonymousFeedback != nil {
+		session.AnonymousFeedback = *input.AnonymousFeedback
+	}
+	if input.SpeakerTimebox != nil {
+		if *input.SpeakerTimebox < 0 {
+			http.Error(w, "speaker_timebox must not be negative", http.StatusBadRequest)
+			return
+		}
+		session.SpeakerTimebox = *input.SpeakerTimebox
+	}
+	if input.MaxStars != nil {
+		if *input.MaxStars < 1 || *input.MaxStars > maxMaxStars {
+			http.Error(w, "max_stars must be between 1 and 10", http.StatusBadRequest)
+			return
+		}
+		session.MaxStars = *input.MaxStars
+	}
+
+	_, err := sessionCollection.UpdateOne(r.Context(), bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{
+			"anonymous_feedback": session.AnonymousFeedback,
+			"speaker_timebox":    session.SpeakerTimebox,
+			"max_stars":          session.MaxStars,
+		}})
+	if err != nil {
+		logFor(r.Context()).Error("Failed to update session settings", "error", err)
+		http.Error(w, "Failed to update settings", http.StatusInternalServerError)
+		return
+	}
+
+	settings := map[string]interface{}{
+		"anonymous_feedback": session.AnonymousFeedback,
+		"speaker_timebox":    session.SpeakerTimebox,
+		"max_stars":          sessionMaxStars(session),
+	}
+	Broadcast(session.ID.Hex(), map[string]interface{}{
+		"type":     "settingsUpdated",
+		"settings": settings,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
@@ -0,0 +1,603 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/oauth2"
+)
+
+// GitHub token 用 AES-256-GCM 加密后才写入数据库，密钥来自 GITHUB_TOKEN_KEY
+// （base64 编码的 32 字节）。存储格式为 "v1:" + base64(nonce + 密文)。
+const sealedTokenPrefix = "v1:"
+
+var errNoTokenKey = errors.New("GITHUB_TOKEN_KEY is not set")
+
+var (
+	githubClient      = &http.Client{Timeout: 15 * time.Second}
+	repositoryPattern = regexp.MustCompile(`^[A-Za-z0-9-]+/[A-Za-z0-9._-]+$`)
+)
+
+// githubAPIURL is the GitHub REST API root. GITHUB_API_URL points it at a
+// GitHub Enterprise server or a fake server during development.
+func githubAPIURL() string {
+	if u := os.Getenv("GITHUB_API_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "https://api.github.com"
+}
+
+// githubRequest calls the GitHub API with the user's token, decoding the
+// JSON response into out when it is not nil
+func githubRequest(ctx context.Context, token, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, githubAPIURL()+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := githubClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&apiError)
+		return fmt.Errorf("GitHub API %s %s: %s %s", method, path, resp.Status, apiError.Message)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// githubTokenCipher returns the AEAD for GITHUB_TOKEN_KEY
+func githubTokenCipher() (cipher.AEAD, error) {
+	encoded := os.Getenv("GITHUB_TOKEN_KEY")
+	if encoded == "" {
+		return nil, errNoTokenKey
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("GITHUB_TOKEN_KEY must be 32 bytes, base64 encoded")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealGitHubToken encrypts a token for storage
+func sealGitHubToken(token string) (string, error) {
+	aead, err := githubTokenCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(token), nil)
+	return sealedTokenPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openGitHubToken decrypts a token stored by sealGitHubToken
+func openGitHubToken(stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedTokenPrefix) {
+		return "", errors.New("token is not encrypted")
+	}
+	aead, err := githubTokenCipher()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealedTokenPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed token")
+	}
+	token, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// userGitHubToken returns the user's decrypted repo token, or "" when they
+// have none or it can't be decrypted
+func userGitHubToken(ctx context.Context, user authUser) string {
+	if user.GitHubToken == "" {
+		return ""
+	}
+	token, err := openGitHubToken(user.GitHubToken)
+	if err != nil {
+		logFor(ctx).Error("Failed to decrypt GitHub token", "username", user.Username, "error", err)
+		return ""
+	}
+	return token
+}
+
+// InitGitHub checks GITHUB_TOKEN_KEY and encrypts the tokens stored in plain
+// text before tokens were encrypted
+func InitGitHub() {
+	ctx := context.Background()
+	users := Client.Database("your-db-name").Collection("users")
+	cursor, err := users.Find(ctx, bson.M{"github_token": bson.M{"$exists": true, "$not": primitive.Regex{Pattern: "^" + sealedTokenPrefix}}})
+	if err != nil {
+		githubLog.Error("Failed to look up GitHub tokens", "error", err)
+		return
+	}
+	var plain []authUser
+	if err := cursor.All(ctx, &plain); err != nil {
+		githubLog.Error("Failed to look up GitHub tokens", "error", err)
+		return
+	}
+	if _, err := githubTokenCipher(); err != nil {
+		if len(plain) > 0 || err != errNoTokenKey {
+			githubLog.Error("GitHub tokens can't be used", "error", err, "unencrypted", len(plain))
+		} else {
+			githubLog.Warn("GitHub issue integration is disabled", "error", err)
+		}
+		return
+	}
+
+	for _, user := range plain {
+		sealed, err := sealGitHubToken(user.GitHubToken)
+		if err != nil {
+			githubLog.Error("Failed to encrypt GitHub token", "username", user.Username, "error", err)
+			return
+		}
+		_, err = users.UpdateOne(ctx, bson.M{"_id": user.ID, "github_token": user.GitHubToken},
+			bson.M{"$set": bson.M{"github_token": sealed}})
+		if err != nil {
+			githubLog.Error("Failed to encrypt GitHub token", "username", user.Username, "error", err)
+		}
+	}
+	if len(plain) > 0 {
+		githubLog.Info("Encrypted stored GitHub tokens", "count", len(plain))
+	}
+}
+
+type githubIssue struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	State   string `json:"state"`
+}
+
+// createGitHubIssue opens an issue in repository and returns the link to it
+func createGitHubIssue(ctx context.Context, user authUser, repository, title, body string) (*models.IssueLink, error) {
+	var issue githubIssue
+	err := githubRequest(ctx, userGitHubToken(ctx, user), http.MethodPost, "/repos/"+repository+"/issues",
+		map[string]interface{}{"title": title, "body": body}, &issue)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &models.IssueLink{
+		Repository: repository,
+		Number:     issue.Number,
+		URL:        issue.HTMLURL,
+		State:      issue.State,
+		CreatedBy:  user.Username,
+		CreatedAt:  now,
+		SyncedAt:   now,
+	}, nil
+}
+
+// currentGitHubUser loads the logged-in user and writes an error response
+// unless they granted repo access
+func currentGitHubUser(w http.ResponseWriter, r *http.Request) (*authUser, bool) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	if userGitHubToken(r.Context(), *user) == "" {
+		http.Error(w, "Connect GitHub with repo access first", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+// GitHubAuthorizeRepoHandler asks the logged-in user to additionally grant
+// the repo scope. GitHubCallbackHandler stores the resulting token.
+func GitHubAuthorizeRepoHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := store.Get(r, "auth-session")
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if _, ok := session.Values["user_id"].(int); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if _, err := githubTokenCipher(); err != nil {
+		logFor(r.Context()).Error("Can't store GitHub tokens", "error", err)
+		http.Error(w, "GitHub issue integration is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	state := "repo-" + hex.EncodeToString(buf)
+	session.Values["oauth_state"] = state
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	config := *oauth
+	config.Scopes = append([]string{"repo"}, oauth.Scopes...)
+	http.Redirect(w, r, config.AuthCodeURL(state, oauth2.AccessTypeOffline), http.StatusFound)
+}
+
+// SetSessionRepositoryHandler links a session to a GitHub repository
+func SetSessionRepositoryHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+
+	authSession, _ := store.Get(r, "auth-session")
+	userID, _ := authSession.Values["user_id"].(int)
+	if !isFacilitator(session, userID) {
+		http.Error(w, "Only the facilitator can link a repository", http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		Repository string `json:"repository"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	input.Repository = strings.TrimSpace(input.Repository)
+	if input.Repository != "" && !repositoryPattern.MatchString(input.Repository) {
+		http.Error(w, "Repository must look like owner/name", http.StatusBadRequest)
+		return
+	}
+
+	_, err := sessionCollection.UpdateOne(r.Context(), bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"repository": input.Repository}})
+	if err != nil {
+		logFor(r.Context()).Error("Failed to link repository", "repository", input.Repository, "error", err)
+		http.Error(w, "Failed to link repository", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"repository": input.Repository})
+}
+
+// CreateActionItemIssueHandler opens a GitHub issue for an action item in
+// the session's linked repository
+func CreateActionItemIssueHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentGitHubUser(w, r)
+	if !ok {
+		return
+	}
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+	if session.Repository == "" {
+		http.Error(w, "Session has no linked repository", http.StatusBadRequest)
+		return
+	}
+
+	itemID, err := primitive.ObjectIDFromHex(mux.Vars(r)["itemId"])
+	if err != nil {
+		http.Error(w, "Invalid action item ID", http.StatusBadRequest)
+		return
+	}
+	var item models.ActionItem
+	if err := actionItemCollection.FindOne(r.Context(), bson.M{"_id": itemID, "session_id": session.ID}).Decode(&item); err != nil {
+		http.Error(w, "Action item not found", http.StatusNotFound)
+		return
+	}
+	if item.Issue != nil {
+		http.Error(w, "Action item already has an issue: "+item.Issue.URL, http.StatusConflict)
+		return
+	}
+
+	var body strings.Builder
+	if item.AssigneeUsername != "" {
+		fmt.Fprintf(&body, "Assignee: @%s\n", item.AssigneeUsername)
+	}
+	if item.DueDate != nil {
+		fmt.Fprintf(&body, "Due: %s\n", item.DueDate.Format("2006-01-02"))
+	}
+	fmt.Fprintf(&body, "\nAction item from [%s](%s).", session.Name, sessionLink(session.ID))
+
+	issue, err := createGitHubIssue(r.Context(), *user, session.Repository, item.Title, body.String())
+	if err != nil {
+		logFor(r.Context()).Error("Failed to create issue for action item", "action_item_id", item.ID.Hex(), "error", err)
+		http.Error(w, "Failed to create GitHub issue", http.StatusBadGateway)
+		return
+	}
+
+	item.Issue = issue
+	item.UpdatedAt = time.Now()
+	_, err = actionItemCollection.UpdateOne(r.Context(), bson.M{"_id": item.ID},
+		bson.M{"$set": bson.M{"issue": issue, "updated_at": item.UpdatedAt}})
+	if err != nil {
+		logFor(r.Context()).Error("Failed to link issue to action item", "action_item_id", item.ID.Hex(), "error", err)
+		http.Error(w, "Failed to link issue", http.StatusInternalServerError)
+		return
+	}
+
+	broadcastActionItem("actionItemUpdated", item)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(item)
+}
+
+// CreateCommentIssueHandler opens a GitHub issue quoting a comment
+func CreateCommentIssueHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentGitHubUser(w, r)
+	if !ok {
+		return
+	}
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+	if session.Repository == "" {
+		http.Error(w, "Session has no linked repository", http.StatusBadRequest)
+		return
+	}
+
+	commentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["commentId"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+	var comment *models.Comment
+	for _, summary := range session.Summaries {
+		for i := range summary.Comments {
+			if summary.Comments[i].ID == commentID {
+				comment = &summary.Comments[i]
+				break
+			}
+		}
+		if comment != nil {
+			break
+		}
+	}
+	if comment == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if comment.Issue != nil {
+		http.Error(w, "Comment already has an issue: "+comment.Issue.URL, http.StatusConflict)
+		return
+	}
+
+	var input struct {
+		Title string `json:"title"`
+	}
+	// 请求体可以省略；分块传输时 ContentLength 是 -1，只能读了才知道
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && err != io.EOF {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	title := strings.TrimSpace(input.Title)
+	if title == "" {
+		// 默认用评论的第一行作为标题
+		title = strings.TrimSpace(strings.SplitN(comment.Content, "\n", 2)[0])
+		if len([]rune(title)) > 80 {
+			title = string([]rune(title)[:80]) + "…"
+		}
+	}
+	if title == "" {
+		http.Error(w, "Title is required", http.StatusBadRequest)
+		return
+	}
+
+	quoted := "> " + strings.ReplaceAll(comment.Content, "\n", "\n> ")
+	author := "@" + comment.Username
+	if comment.Anonymous {
+		author = anonymousName
+	}
+	body := fmt.Sprintf("%s\n\n— %s in [%s](%s)", quoted, author, session.Name, sessionLink(session.ID))
+
+	issue, err := createGitHubIssue(r.Context(), *user, session.Repository, title, body)
+	if err != nil {
+		logFor(r.Context()).Error("Failed to create issue for comment", "comment_id", commentID.Hex(), "error", err)
+		http.Error(w, "Failed to create GitHub issue", http.StatusBadGateway)
+		return
+	}
+
+	// 评论被复制到了每个 summary 中，全部更新
+	_, err = sessionCollection.UpdateOne(r.Context(),
+		bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"summaries.$[].comments.$[c].issue": issue}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"c._id": commentID}}}),
+	)
+	if err != nil {
+		logFor(r.Context()).Error("Failed to link issue to comment", "comment_id", commentID.Hex(), "error", err)
+		http.Error(w, "Failed to link issue", http.StatusInternalServerError)
+		return
+	}
+	comment.Issue = issue
+
+	Broadcast(session.ID.Hex(), map[string]interface{}{
+		"type":      "commentIssueCreated",
+		"commentId": commentID.Hex(),
+		"issue":     issue,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(comment)
+}
+
+// syncIssueStates refreshes the state of the issues linked to the given
+// action items and completes the items whose issue was closed. It returns
+// the items that changed.
+func syncIssueStates(ctx context.Context, token string, items []models.ActionItem) ([]models.ActionItem, error) {
+	var changed []models.ActionItem
+	for _, item := range items {
+		if item.Issue == nil {
+			continue
+		}
+		var issue githubIssue
+		path := fmt.Sprintf("/repos/%s/issues/%d", item.Issue.Repository, item.Issue.Number)
+		if err := githubRequest(ctx, token, http.MethodGet, path, nil, &issue); err != nil {
+			return changed, err
+		}
+
+		now := time.Now()
+		set := bson.M{"issue.state": issue.State, "issue.synced_at": now}
+		stateChanged := issue.State != item.Issue.State
+		item.Issue.State = issue.State
+		item.Issue.SyncedAt = now
+		completed := issue.State == "closed" && item.Status != models.ActionItemDone
+		if completed {
+			item.Status = models.ActionItemDone
+			item.CompletedAt = &now
+			item.UpdatedAt = now
+			set["status"] = item.Status
+			set["completed_at"] = now
+			set["updated_at"] = now
+		}
+
+		if _, err := actionItemCollection.UpdateOne(ctx, bson.M{"_id": item.ID}, bson.M{"$set": set}); err != nil {
+			return changed, err
+		}
+		if completed {
+			broadcastActionItem("actionItemCompleted", item)
+		}
+		if completed || stateChanged {
+			changed = append(changed, item)
+		}
+	}
+	return changed, nil
+}
+
+// SyncSessionIssuesHandler pulls the state of every issue linked to the
+// session's action items using the current user's token
+func SyncSessionIssuesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentGitHubUser(w, r)
+	if !ok {
+		return
+	}
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+
+	var items []models.ActionItem
+	cursor, err := actionItemCollection.Find(r.Context(), bson.M{"session_id": session.ID, "issue": bson.M{"$exists": true}})
+	if err == nil {
+		err = cursor.All(r.Context(), &items)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch action items", http.StatusInternalServerError)
+		return
+	}
+
+	changed, err := syncIssueStates(r.Context(), userGitHubToken(r.Context(), *user), items)
+	if err != nil {
+		logFor(r.Context()).Error("Failed to sync issues", "error", err)
+		http.Error(w, "Failed to sync GitHub issues", http.StatusBadGateway)
+		return
+	}
+	if changed == nil {
+		changed = []models.ActionItem{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changed)
+}
+
+// syncOpenIssues refreshes every open linked issue with the token of the
+// user who created it
+func syncOpenIssues(ctx context.Context) {
+	cursor, err := actionItemCollection.Find(ctx, bson.M{"issue.state": "open", "status": models.ActionItemOpen})
+	if err != nil {
+		logFor(ctx).Error("Failed to fetch action items with issues", "error", err)
+		return
+	}
+	var items []models.ActionItem
+	if err := cursor.All(ctx, &items); err != nil {
+		logFor(ctx).Error("Failed to parse action items with issues", "error", err)
+		return
+	}
+
+	byCreator := make(map[string][]models.ActionItem)
+	for _, item := range items {
+		byCreator[item.Issue.CreatedBy] = append(byCreator[item.Issue.CreatedBy], item)
+	}
+	creators := make([]string, 0, len(byCreator))
+	for username := range byCreator {
+		creators = append(creators, username)
+	}
+	users, err := findUsersByUsername(ctx, creators)
+	if err != nil {
+		logFor(ctx).Error("Failed to look up issue creators", "error", err)
+		return
+	}
+
+	for username, items := range byCreator {
+		user, ok := users[username]
+		if !ok {
+			continue
+		}
+		token := userGitHubToken(ctx, user)
+		if token == "" {
+			continue
+		}
+		if _, err := syncIssueStates(ctx, token, items); err != nil {
+			logFor(ctx).Warn("Failed to sync issues", "username", username, "error", err)
+		}
+	}
+}
+
+// StartIssueSync periodically syncs the state of linked GitHub issues
+func StartIssueSync(interval time.Duration) {
+	go func() {
+		for {
+			syncOpenIssues(withLogger(context.Background(), githubLog))
+			time.Sleep(interval)
+		}
+	}()
+}
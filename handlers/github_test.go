@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testTokenKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=" // 32 bytes
+
+func TestGitHubTokenIsEncryptedAtRest(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN_KEY", testTokenKey)
+
+	sealed, err := sealGitHubToken("gho_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "gho_secret") || !strings.HasPrefix(sealed, sealedTokenPrefix) {
+		t.Errorf("sealed token = %q", sealed)
+	}
+	if again, _ := sealGitHubToken("gho_secret"); again == sealed {
+		t.Error("sealing twice gave the same ciphertext")
+	}
+	if token, err := openGitHubToken(sealed); err != nil || token != "gho_secret" {
+		t.Errorf("openGitHubToken = %q, %v", token, err)
+	}
+
+	if _, err := openGitHubToken("gho_plaintext"); err == nil {
+		t.Error("plain text token accepted")
+	}
+	// 修改解码后的密文；直接改 base64 字符可能只改到填充位
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedTokenPrefix))
+	raw[len(raw)-1] ^= 1
+	tampered := sealedTokenPrefix + base64.StdEncoding.EncodeToString(raw)
+	if _, err := openGitHubToken(tampered); err == nil {
+		t.Error("tampered token accepted")
+	}
+
+	t.Setenv("GITHUB_TOKEN_KEY", base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210")))
+	if _, err := openGitHubToken(sealed); err == nil {
+		t.Error("token opened with another key")
+	}
+	t.Setenv("GITHUB_TOKEN_KEY", "")
+	if _, err := sealGitHubToken("gho_secret"); err != errNoTokenKey {
+		t.Errorf("sealing without a key: %v", err)
+	}
+}
+
+func TestCreateGitHubIssueAgainstFakeAPI(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN_KEY", testTokenKey)
+
+	var got struct {
+		method, path, auth string
+		body               map[string]string
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.method, got.path, got.auth = r.Method, r.URL.Path, r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got.body)
+		if r.URL.Path == "/repos/acme/missing/issues" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"number":42,"html_url":"https://github.example.com/acme/widgets/issues/42","state":"open"}`))
+	}))
+	defer server.Close()
+	t.Setenv("GITHUB_API_URL", server.URL+"/")
+
+	sealed, err := sealGitHubToken("gho_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := authUser{Username: "alice", GitHubToken: sealed}
+
+	link, err := createGitHubIssue(context.Background(), user, "acme/widgets", "Ship the export", "From the retro")
+	if err != nil {
+		t.Fatalf("createGitHubIssue: %v", err)
+	}
+	if got.method != http.MethodPost || got.path != "/repos/acme/widgets/issues" {
+		t.Errorf("request = %s %s", got.method, got.path)
+	}
+	if got.auth != "Bearer gho_secret" {
+		t.Errorf("Authorization = %q, want the decrypted token", got.auth)
+	}
+	if got.body["title"] != "Ship the export" || got.body["body"] != "From the retro" {
+		t.Errorf("body = %v", got.body)
+	}
+	if link.Number != 42 || link.State != "open" || link.Repository != "acme/widgets" || link.CreatedBy != "alice" ||
+		link.URL != "https://github.example.com/acme/widgets/issues/42" {
+		t.Errorf("link = %+v", link)
+	}
+
+	_, err = createGitHubIssue(context.Background(), user, "acme/missing", "Ship the export", "")
+	if err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "Not Found") {
+		t.Errorf("error = %v, want the API's 404 message", err)
+	}
+}
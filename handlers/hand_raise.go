@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type raisedHand struct {
+	UserID   int       `json:"id"`
+	Username string    `json:"username"`
+	RaisedAt time.Time `json:"raisedAt"`
+}
+
+// floorState is the live hand-raise queue of a session. Only the turns are
+// persisted; the queue itself lives as long as the meeting.
+type floorState struct {
+	queue   []raisedHand
+	speaker *raisedHand
+	turnID  primitive.ObjectID
+}
+
+var floors = struct {
+	sync.Mutex
+	sessions map[string]*floorState
+}{sessions: make(map[string]*floorState)}
+
+func floorFor(sessionID string) *floorState {
+	state, ok := floors.sessions[sessionID]
+	if !ok {
+		state = &floorState{}
+		floors.sessions[sessionID] = state
+	}
+	return state
+}
+
+// handQueueSnapshot returns the queue and current speaker for the
+// participants list
+func handQueueSnapshot(sessionID string) ([]raisedHand, *raisedHand) {
+	floors.Lock()
+	defer floors.Unlock()
+	state, ok := floors.sessions[sessionID]
+	if !ok {
+		return []raisedHand{}, nil
+	}
+	queue := append([]raisedHand{}, state.queue...)
+	var speaker *raisedHand
+	if state.speaker != nil {
+		s := *state.speaker
+		speaker = &s
+	}
+	return queue, speaker
+}
+
+func (state *floorState) remove(userID int) bool {
+	for i, hand := range state.queue {
+		if hand.UserID == userID {
+			state.queue = append(state.queue[:i], state.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// endTurn stores the end of the current speaker's turn
+func endTurn(sessionID string, turnID primitive.ObjectID, at time.Time) {
+	if turnID.IsZero() {
+		return
+	}
+	objectID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return
+	}
+	_, err = sessionCollection.UpdateOne(context.Background(), bson.M{"_id": objectID},
+		bson.M{"$set": bson.M{"floor_history.$[t].ended_at": at}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"t._id": turnID}}}))
+	if err != nil {
+		wsLog.Error("Failed to end floor turn", "session_id", sessionID, "turn_id", turnID.Hex(), "error", err)
+	}
+}
+
+func handleRaiseHand(client *MeetingClient) {
+	floors.Lock()
+	state := floorFor(client.sessionID)
+	for _, hand := range state.queue {
+		if hand.UserID == client.userID {
+			floors.Unlock()
+			return
+		}
+	}
+	state.queue = append(state.queue, raisedHand{
+		UserID:   client.userID,
+		Username: client.username,
+		RaisedAt: time.Now(),
+	})
+	floors.Unlock()
+
+	broadcastParticipantsList(client.sessionID)
+}
+
+func handleLowerHand(client *MeetingClient) {
+	floors.Lock()
+	changed := floorFor(client.sessionID).remove(client.userID)
+	floors.Unlock()
+
+	if changed {
+		broadcastParticipantsList(client.sessionID)
+	}
+}
+
+// handleYieldFloor ends the turn of the current speaker. The speaker or the
+// facilitator may end it.
+func handleYieldFloor(client *MeetingClient) {
+	facilitator := false
+	if session, err := loadClientSession(client); err == nil {
+		facilitator = isFacilitator(session, client.userID)
+	}
+
+	floors.Lock()
+	state := floorFor(client.sessionID)
+	if state.speaker == nil {
+		floors.Unlock()
+		return
+	}
+	if state.speaker.UserID != client.userID && !facilitator {
+		floors.Unlock()
+		sendError(client, "yieldFloor", "Only the speaker or the facilitator can end the turn")
+		return
+	}
+	turnID := state.turnID
+	state.speaker = nil
+	state.turnID = primitive.NilObjectID
+	floors.Unlock()
+
+	endTurn(client.sessionID, turnID, time.Now())
+	broadcastParticipantsList(client.sessionID)
+}
+
+// handleCallNext gives the floor to the first person in the queue, or to the
+// given username. Only the facilitator may call on people.
+func handleCallNext(client *MeetingClient, msg map[string]interface{}) {
+	session, err := loadClientSession(client)
+	if err != nil {
+		sendError(client, "callNext", "Session not found")
+		return
+	}
+	if !isFacilitator(session, client.userID) {
+		sendError(client, "callNext", "Only the facilitator can call on participants")
+		return
+	}
+	username, _ := msg["username"].(string)
+
+	now := time.Now()
+	floors.Lock()
+	state := floorFor(client.sessionID)
+	index := -1
+	for i, hand := range state.queue {
+		if username == "" || hand.Username == username {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		floors.Unlock()
+		if username != "" {
+			sendError(client, "callNext", username+" has not raised their hand")
+		} else {
+			sendError(client, "callNext", "Nobody is waiting")
+		}
+		return
+	}
+	next := state.queue[index]
+	state.queue = append(state.queue[:index], state.queue[index+1:]...)
+	previousTurn := state.turnID
+	state.speaker = &next
+	state.turnID = primitive.NewObjectID()
+	turn := models.FloorTurn{
+		ID:       state.turnID,
+		Username: next.Username,
+		RaisedAt: next.RaisedAt,
+		CalledAt: now,
+		CalledBy: client.username,
+	}
+	floors.Unlock()
+
+	endTurn(client.sessionID, previousTurn, now)
+	_, err = sessionCollection.UpdateOne(context.Background(), bson.M{"_id": session.ID},
+		bson.M{"$push": bson.M{"floor_history": turn}})
+	if err != nil {
+		client.log().Error("Failed to record floor turn", "error", err)
+	}
+
+	Broadcast(client.sessionID, map[string]interface{}{
+		"type":    "floorGiven",
+		"speaker": next,
+		"turn":    turn,
+	})
+	broadcastParticipantsList(client.sessionID)
+}
+
+// leaveFloor lowers the hand of a user who left the meeting and ends their
+// turn if they were speaking
+func leaveFloor(sessionID string, userID int) {
+	floors.Lock()
+	state, ok := floors.sessions[sessionID]
+	if !ok {
+		floors.Unlock()
+		return
+	}
+	state.remove(userID)
+	var turnID primitive.ObjectID
+	if state.speaker != nil && state.speaker.UserID == userID {
+		turnID = state.turnID
+		state.speaker = nil
+		state.turnID = primitive.NilObjectID
+	}
+	if len(state.queue) == 0 && state.speaker == nil {
+		delete(floors.sessions, sessionID)
+	}
+	floors.Unlock()
+
+	endTurn(sessionID, turnID, time.Now())
+}
+
+// GetFloorHistoryHandler returns the floor turns of a session and the total
+// speaking time per participant in seconds
+func GetFloorHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	objectID, err := primitive.ObjectIDFromHex(mux.Vars(r)["sessionId"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	var session models.Session
+	if err := sessionCollection.FindOne(r.Context(), notDeleted(bson.M{"_id": objectID})).Decode(&session); err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	type floorTotal struct {
+		Username string  `json:"username"`
+		Turns    int     `json:"turns"`
+		Seconds  float64 `json:"seconds"`
+	}
+	totals := make(map[string]*floorTotal)
+	for _, turn := range session.FloorHistory {
+		total, ok := totals[turn.Username]
+		if !ok {
+			total = &floorTotal{Username: turn.Username}
+			totals[turn.Username] = total
+		}
+		total.Turns++
+		end := time.Now()
+		if turn.EndedAt != nil {
+			end = *turn.EndedAt
+		}
+		total.Seconds += end.Sub(turn.CalledAt).Seconds()
+	}
+	summary := make([]floorTotal, 0, len(totals))
+	for _, total := range totals {
+		summary = append(summary, *total)
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i].Seconds > summary[j].Seconds })
+
+	history := session.FloorHistory
+	if history == nil {
+		history = []models.FloorTurn{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"turns":  history,
+		"totals": summary,
+	})
+}
@@ -8,7 +8,13 @@ import (
     //"github.com/gorilla/sessions"
 )
 
-var tmpl = template.Must(template.ParseGlob("templates/*.html"))
+var tmpl *template.Template
+
+// InitTemplates parses the page templates. It runs at startup rather than on
+// import, so the package can be loaded without the templates directory.
+func InitTemplates() {
+    tmpl = template.Must(template.ParseGlob("templates/*.html"))
+}
 
 // HomeHandler handles requests to the home page
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
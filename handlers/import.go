@@ -0,0 +1,655 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ImportRecordResult reports the outcome of importing a single session
+type ImportRecordResult struct {
+	Source      string   `json:"source"`
+	SessionName string   `json:"session_name"`
+	SessionID   string   `json:"session_id,omitempty"`
+	Status      string   `json:"status"` // created, valid or error
+	Errors      []string `json:"errors,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+// ImportReport summarizes an import run
+type ImportReport struct {
+	DryRun  bool                 `json:"dry_run"`
+	Created int                  `json:"created"`
+	Valid   int                  `json:"valid"`
+	Failed  int                  `json:"failed"`
+	Records []ImportRecordResult `json:"records"`
+}
+
+type importRecord struct {
+	source string
+	export models.SessionExport
+	errors []string
+}
+
+// maxImportSize limits the size of an uploaded archive
+const maxImportSize = 32 << 20
+
+// ImportArchive imports sessions from a JSON export, a structured Markdown
+// file or a zip archive of either. The format is detected from the name's
+// extension. The imported sessions are facilitated by the user with the given
+// GitHub ID, whatever the file says. With dryRun set nothing is written to
+// the database.
+func ImportArchive(ctx context.Context, name string, data []byte, dryRun bool, facilitatorID int) (ImportReport, error) {
+	records, err := parseImportFile(name, data)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	report := ImportReport{DryRun: dryRun, Records: []ImportRecordResult{}}
+	for _, record := range records {
+		result := importSession(ctx, record, dryRun, facilitatorID)
+		switch result.Status {
+		case "created":
+			report.Created++
+		case "valid":
+			report.Valid++
+		default:
+			report.Failed++
+		}
+		report.Records = append(report.Records, result)
+	}
+	return report, nil
+}
+
+func parseImportFile(name string, data []byte) ([]importRecord, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".zip":
+		archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zip archive: %w", err)
+		}
+		var records []importRecord
+		for _, f := range archive.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			ext := strings.ToLower(path.Ext(f.Name))
+			if ext != ".json" && ext != ".md" && ext != ".markdown" {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				records = append(records, importRecord{source: f.Name, errors: []string{err.Error()}})
+				continue
+			}
+			content, err := io.ReadAll(io.LimitReader(rc, maxImportSize))
+			rc.Close()
+			if err != nil {
+				records = append(records, importRecord{source: f.Name, errors: []string{err.Error()}})
+				continue
+			}
+			nested, err := parseImportFile(f.Name, content)
+			if err != nil {
+				records = append(records, importRecord{source: f.Name, errors: []string{err.Error()}})
+				continue
+			}
+			records = append(records, nested...)
+		}
+		return records, nil
+	case ".json":
+		return parseJSONImport(name, data)
+	case ".md", ".markdown":
+		export, errs := parseMarkdownImport(string(data))
+		return []importRecord{{source: name, export: export, errors: errs}}, nil
+	}
+	return nil, fmt.Errorf("unsupported import format %q", path.Ext(name))
+}
+
+// parseJSONImport accepts either a single export or an array of exports
+func parseJSONImport(name string, data []byte) ([]importRecord, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		records := make([]importRecord, 0, len(raw))
+		for i, item := range raw {
+			record := importRecord{source: fmt.Sprintf("%s[%d]", name, i)}
+			if err := json.Unmarshal(item, &record.export); err != nil {
+				record.errors = append(record.errors, fmt.Sprintf("invalid JSON: %v", err))
+			}
+			records = append(records, record)
+		}
+		return records, nil
+	}
+
+	record := importRecord{source: name}
+	if err := json.Unmarshal(trimmed, &record.export); err != nil {
+		record.errors = append(record.errors, fmt.Sprintf("invalid JSON: %v", err))
+	}
+	return []importRecord{record}, nil
+}
+
+var markdownCommentPattern = regexp.MustCompile(`^- \*\*(.+?)\*\* \((\d+)/\d+ stars(?:, ([^)]*))?\): (.*)$`)
+
+// markdownUnescape reverses markdownEscape for one line
+func markdownUnescape(line string) string {
+	return strings.TrimPrefix(line, `\`)
+}
+
+// parseMarkdownImport parses the layout written by renderExportMarkdown. It
+// restores the session, its summaries, comments, decisions and minutes; the
+// chat and retro board are only imported from JSON.
+func parseMarkdownImport(text string) (models.SessionExport, []string) {
+	var export models.SessionExport
+	var errs []string
+	session := &export.Session
+
+	participants := make(map[string]*models.Participant)
+	var participantOrder []string
+	participant := func(username string) *models.Participant {
+		if p, ok := participants[username]; ok {
+			return p
+		}
+		p := &models.Participant{ID: primitive.NewObjectID(), Username: username}
+		participants[username] = p
+		participantOrder = append(participantOrder, username)
+		return p
+	}
+
+	section := "header"
+	inComments := false
+	var summary *models.Summary
+	var summaryLines, minutesLines []string
+	flushSummary := func() {
+		if summary != nil {
+			summary.Content = strings.TrimSpace(strings.Join(summaryLines, "\n"))
+			session.Summaries = append(session.Summaries, *summary)
+		}
+		summary, summaryLines, inComments = nil, nil, false
+	}
+
+	var decision *models.Decision
+	var rationaleLines []string
+	flushDecision := func() {
+		if decision != nil {
+			decision.Rationale = strings.TrimSpace(strings.Join(rationaleLines, "\n"))
+			export.Decisions = append(export.Decisions, *decision)
+		}
+		decision, rationaleLines = nil, nil
+	}
+	splitList := func(value string) []string {
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportSize)
+	lineNo := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNo++
+
+		if section == "minutes" {
+			minutesLines = append(minutesLines, line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "# ") && section == "header":
+			session.Name = strings.TrimSpace(line[2:])
+			continue
+		case line == "## Summaries":
+			section = "summaries"
+			continue
+		case line == "## Decisions":
+			flushSummary()
+			section = "decisions"
+			continue
+		case line == "## Chat", line == "## Retro":
+			flushSummary()
+			flushDecision()
+			section = "skipped"
+			continue
+		case line == "## Minutes":
+			flushSummary()
+			flushDecision()
+			section = "minutes"
+			continue
+		}
+
+		// Markdown 里的聊天记录和复盘卡片不带 ID 等信息，只从 JSON 导入
+		if section == "skipped" {
+			continue
+		}
+
+		if section == "decisions" {
+			if strings.HasPrefix(line, "### ") {
+				flushDecision()
+				decision = &models.Decision{
+					Statement:   strings.TrimSpace(line[4:]),
+					Tags:        []string{},
+					AgreedBy:    []string{},
+					DissentedBy: []string{},
+					CreatedAt:   session.CreatedAt,
+				}
+				continue
+			}
+			if decision == nil {
+				continue
+			}
+			key, value, ok := strings.Cut(strings.TrimPrefix(line, "- "), ": ")
+			if !ok || !strings.HasPrefix(line, "- ") {
+				rationaleLines = append(rationaleLines, markdownUnescape(line))
+				continue
+			}
+			switch key {
+			case "ID":
+				if id, err := primitive.ObjectIDFromHex(value); err == nil {
+					decision.ID = id
+				}
+			case "Date":
+				t, err := parseDateTime(value)
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("line %d: invalid decision date %q", lineNo, value))
+				}
+				decision.CreatedAt = t
+			case "Tags":
+				decision.Tags = splitList(value)
+			case "Agreed":
+				decision.AgreedBy = splitList(value)
+			case "Dissented":
+				decision.DissentedBy = splitList(value)
+			case "Supersedes":
+				if id, err := primitive.ObjectIDFromHex(value); err == nil {
+					decision.Supersedes = &id
+				} else {
+					errs = append(errs, fmt.Sprintf("line %d: invalid superseded decision ID %q", lineNo, value))
+				}
+			default:
+				rationaleLines = append(rationaleLines, line)
+			}
+			continue
+		}
+
+		if section == "header" {
+			key, value, ok := strings.Cut(strings.TrimPrefix(line, "- "), ": ")
+			if !ok || !strings.HasPrefix(line, "- ") {
+				continue
+			}
+			switch key {
+			case "Created":
+				t, err := parseDateTime(value)
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("line %d: invalid created date %q", lineNo, value))
+				}
+				session.CreatedAt = t
+			case "Participants":
+				for _, name := range strings.Split(value, ",") {
+					if name = strings.TrimSpace(name); name != "" {
+						participant(name)
+					}
+				}
+			}
+			continue
+		}
+
+		// summaries section
+		if strings.HasPrefix(line, "### ") {
+			flushSummary()
+			author := strings.TrimSpace(line[4:])
+			summary = &models.Summary{Comments: []models.Comment{}, CreatedAt: session.CreatedAt}
+			if author != "General" {
+				p := participant(author)
+				p.Summarized = true
+				summary.ParticipantID = p.ID
+			}
+			continue
+		}
+		if summary == nil {
+			continue
+		}
+		if line == "#### Comments" {
+			inComments = true
+			continue
+		}
+		if !inComments {
+			summaryLines = append(summaryLines, markdownUnescape(line))
+			continue
+		}
+		// 缩进的行是上一条评论的后续行
+		if strings.HasPrefix(line, "  ") && len(summary.Comments) > 0 {
+			last := &summary.Comments[len(summary.Comments)-1]
+			last.Content += "\n" + line[2:]
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		m := markdownCommentPattern.FindStringSubmatch(line)
+		if m == nil {
+			errs = append(errs, fmt.Sprintf("line %d: unrecognized comment line", lineNo))
+			continue
+		}
+		stars, _ := strconv.Atoi(m[2])
+		comment := models.Comment{
+			ID:        primitive.NewObjectID(),
+			Username:  m[1],
+			Stars:     stars,
+			Content:   m[4],
+			CreatedAt: session.CreatedAt,
+		}
+		if m[3] != "" {
+			t, err := parseDateTime(m[3])
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("line %d: invalid comment date %q", lineNo, m[3]))
+			}
+			comment.CreatedAt = t
+		}
+		summary.Comments = append(summary.Comments, comment)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	flushSummary()
+	flushDecision()
+
+	for _, username := range participantOrder {
+		session.Participants = append(session.Participants, *participants[username])
+	}
+
+	minutes := strings.TrimSpace(strings.Join(minutesLines, "\n"))
+	if minutes != "" && minutes != "_No minutes recorded._" {
+		export.Minutes = &models.Minutes{
+			Content:   minutes,
+			CreatedAt: session.CreatedAt,
+			UpdatedAt: session.CreatedAt,
+		}
+	}
+
+	return export, errs
+}
+
+func parseDateTime(value string) (time.Time, error) {
+	layouts := []string{time.RFC3339, time.RFC1123, time.RFC1123Z, "2006-01-02 15:04", "2006-01-02"}
+	var err error
+	for _, layout := range layouts {
+		var t time.Time
+		if t, err = time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// validateImport checks a record and maps comment authors to known users
+func validateImport(ctx context.Context, record *importRecord, result *ImportRecordResult) {
+	session := &record.export.Session
+	result.Errors = append(result.Errors, record.errors...)
+
+	if strings.TrimSpace(session.Name) == "" {
+		result.Errors = append(result.Errors, "session name is required")
+	}
+	if session.CreatedAt.IsZero() {
+		result.Errors = append(result.Errors, "session created date is required")
+	}
+
+	if !session.ID.IsZero() {
+		count, err := sessionCollection.CountDocuments(ctx, bson.M{"_id": session.ID})
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("failed to check session ID: %v", err))
+		} else if count > 0 {
+			result.Errors = append(result.Errors, fmt.Sprintf("session %s already exists", session.ID.Hex()))
+		}
+	}
+
+	participantIDs := make(map[primitive.ObjectID]bool)
+	usernames := make(map[string]bool)
+	for _, p := range session.Participants {
+		if p.Username == "" {
+			result.Errors = append(result.Errors, "participant without username")
+		}
+		participantIDs[p.ID] = true
+		usernames[p.Username] = true
+	}
+
+	for i, summary := range session.Summaries {
+		if !summary.ParticipantID.IsZero() && !participantIDs[summary.ParticipantID] {
+			result.Errors = append(result.Errors, fmt.Sprintf("summary %d references unknown participant %s", i, summary.ParticipantID.Hex()))
+		}
+		for j, comment := range summary.Comments {
+			if comment.Username == "" {
+				result.Errors = append(result.Errors, fmt.Sprintf("summary %d comment %d has no author", i, j))
+			}
+			if maxStars := sessionMaxStars(*session); comment.Stars < 1 || comment.Stars > maxStars {
+				result.Errors = append(result.Errors, fmt.Sprintf("summary %d comment %d: stars must be between 1 and %d", i, j, maxStars))
+			}
+			usernames[comment.Username] = true
+		}
+	}
+
+	for i, decision := range record.export.Decisions {
+		if strings.TrimSpace(decision.Statement) == "" {
+			result.Errors = append(result.Errors, fmt.Sprintf("decision %d has no statement", i))
+		}
+		if !decision.ID.IsZero() {
+			count, err := decisionCollection.CountDocuments(ctx, bson.M{"_id": decision.ID})
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("failed to check decision ID: %v", err))
+			} else if count > 0 {
+				result.Errors = append(result.Errors, fmt.Sprintf("decision %s already exists", decision.ID.Hex()))
+			}
+		}
+	}
+
+	// 按用户名映射作者
+	names := make([]string, 0, len(usernames))
+	for name := range usernames {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	users, err := findUsersByUsername(ctx, names)
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("failed to look up users: %v", err))
+		return
+	}
+
+	for i := range session.Participants {
+		p := &session.Participants[i]
+		if user, ok := users[p.Username]; ok && p.AvatarURL == "" {
+			p.AvatarURL = user.AvatarURL
+		}
+	}
+	unknown := make(map[string]bool)
+	for i := range session.Summaries {
+		for j := range session.Summaries[i].Comments {
+			comment := &session.Summaries[i].Comments[j]
+			if comment.ID.IsZero() {
+				comment.ID = primitive.NewObjectID()
+			}
+			user, ok := users[comment.Username]
+			if !ok {
+				unknown[comment.Username] = true
+				continue
+			}
+			comment.UserID = user.ID
+			if comment.AvatarURL == "" {
+				comment.AvatarURL = user.AvatarURL
+			}
+		}
+	}
+	for name := range unknown {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("no user named %q, comments keep the username only", name))
+	}
+}
+
+// ImportFacilitator returns the GitHub ID of the user with the given username,
+// for the import command
+func ImportFacilitator(ctx context.Context, username string) (int, error) {
+	users, err := findUsersByUsername(ctx, []string{username})
+	if err != nil {
+		return 0, err
+	}
+	user, ok := users[username]
+	if !ok {
+		return 0, fmt.Errorf("no user named %q", username)
+	}
+	return user.GitHubID, nil
+}
+
+func importSession(ctx context.Context, record importRecord, dryRun bool, facilitatorID int) ImportRecordResult {
+	result := ImportRecordResult{
+		Source:      record.source,
+		SessionName: record.export.Session.Name,
+	}
+
+	validateImport(ctx, &record, &result)
+	if len(result.Errors) > 0 {
+		result.Status = "error"
+		return result
+	}
+	if dryRun {
+		result.Status = "valid"
+		return result
+	}
+
+	session := record.export.Session
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
+	}
+	if session.Summaries == nil {
+		session.Summaries = []models.Summary{}
+	}
+	for i := range session.Summaries {
+		if session.Summaries[i].ID.IsZero() {
+			session.Summaries[i].ID = primitive.NewObjectID()
+		}
+	}
+	session.DeletedAt, session.DeletedBy = nil, ""
+	// 导入文件里的主持人 ID 不可信，由导入者担任主持人
+	session.FacilitatorID = facilitatorID
+	if session.Status == "" {
+		session.Status = timelineStatus(session)
+	}
+	if _, err := sessionCollection.InsertOne(ctx, session); err != nil {
+		result.Status = "error"
+		result.Errors = append(result.Errors, fmt.Sprintf("failed to create session: %v", err))
+		return result
+	}
+	result.SessionID = session.ID.Hex()
+
+	if minutes := record.export.Minutes; minutes != nil {
+		minutes.ID = primitive.NewObjectID()
+		minutes.SessionID = session.ID
+		// 标记指向原会话的待办事项，下次保存时重新加上
+		minutes.Content = stripActionItemMarkers(minutes.Content)
+		if minutes.CreatedAt.IsZero() {
+			minutes.CreatedAt = session.CreatedAt
+		}
+		if minutes.UpdatedAt.IsZero() {
+			minutes.UpdatedAt = minutes.CreatedAt
+		}
+		if _, err := minutesCollection.InsertOne(ctx, minutes); err != nil {
+			result.Status = "error"
+			result.Errors = append(result.Errors, fmt.Sprintf("session created but failed to create minutes: %v", err))
+			return result
+		}
+	}
+
+	if len(record.export.Decisions) > 0 {
+		decisions := make([]interface{}, 0, len(record.export.Decisions))
+		for _, decision := range record.export.Decisions {
+			if decision.ID.IsZero() {
+				decision.ID = primitive.NewObjectID()
+			}
+			decision.SessionID = session.ID
+			if decision.CreatedAt.IsZero() {
+				decision.CreatedAt = session.CreatedAt
+			}
+			decisions = append(decisions, decision)
+		}
+		if _, err := decisionCollection.InsertMany(ctx, decisions); err != nil {
+			result.Status = "error"
+			result.Errors = append(result.Errors, fmt.Sprintf("session created but failed to create decisions: %v", err))
+			return result
+		}
+	}
+
+	if len(record.export.Chat) > 0 {
+		messages := make([]interface{}, 0, len(record.export.Chat))
+		for _, message := range record.export.Chat {
+			message.ID = primitive.NewObjectID()
+			message.SessionID = session.ID
+			messages = append(messages, message)
+		}
+		if _, err := chatCollection.InsertMany(ctx, messages); err != nil {
+			result.Status = "error"
+			result.Errors = append(result.Errors, fmt.Sprintf("session created but failed to create chat: %v", err))
+			return result
+		}
+	}
+
+	result.Status = "created"
+	return result
+}
+
+// ImportHandler imports sessions from an uploaded archive. The body is the
+// raw file; format is json, md or zip and dry_run=true only validates.
+func ImportHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := store.Get(r, "auth-session")
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "json" && format != "md" && format != "zip" {
+		http.Error(w, "Format must be one of json, md, zip", http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	report, err := ImportArchive(r.Context(), "upload."+format, data, dryRun, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logFor(r.Context()).Info("Import finished", "created", report.Created, "valid", report.Valid, "failed", report.Failed, "dry_run", dryRun)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
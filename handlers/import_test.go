@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMarkdownExportImportsUnchanged(t *testing.T) {
+	created := time.Date(2026, 10, 19, 9, 30, 15, 0, time.UTC)
+	alice := models.Participant{ID: primitive.NewObjectID(), Username: "alice"}
+	summary := "Finished the export.\n## Decisions\n### Not a summary\n- Created: never\n\\ backslash"
+	comment := "Nice work.\n\n- ID: not a field\n#### Comments"
+	rationale := "Users asked for it.\n- Tags: not, tags\n### Not a decision\n## Minutes"
+
+	export := models.SessionExport{
+		Session: models.Session{
+			Name:         "Sprint review",
+			CreatedAt:    created,
+			Participants: []models.Participant{alice},
+			Summaries: []models.Summary{{
+				ParticipantID: alice.ID,
+				Content:       summary,
+				Comments:      []models.Comment{{Username: "bob", Stars: 4, Content: comment, CreatedAt: created.Add(90 * time.Second)}},
+			}},
+		},
+		Decisions: []models.Decision{{
+			ID:        primitive.NewObjectID(),
+			Statement: "Ship the importer",
+			Rationale: rationale,
+			Tags:      []string{"release"},
+			CreatedAt: created,
+		}},
+		Minutes:    &models.Minutes{Content: "# Minutes\n- Key: value"},
+		ExportedAt: created.Add(time.Hour),
+	}
+
+	imported, errs := parseMarkdownImport(renderExportMarkdown(buildExportDocument(&export)))
+	if len(errs) > 0 {
+		t.Fatalf("import errors: %v", errs)
+	}
+	session := imported.Session
+	if session.Name != "Sprint review" || !session.CreatedAt.Equal(created) {
+		t.Errorf("session = %q created %v", session.Name, session.CreatedAt)
+	}
+	if len(session.Summaries) != 1 {
+		t.Fatalf("got %d summaries, want 1", len(session.Summaries))
+	}
+	if got := session.Summaries[0].Content; got != summary {
+		t.Errorf("summary = %q, want %q", got, summary)
+	}
+	if comments := session.Summaries[0].Comments; len(comments) != 1 || comments[0].Content != comment ||
+		comments[0].Stars != 4 || !comments[0].CreatedAt.Equal(created.Add(90*time.Second)) {
+		t.Errorf("comments = %+v", comments)
+	}
+	if len(imported.Decisions) != 1 {
+		t.Fatalf("got %d decisions, want 1", len(imported.Decisions))
+	}
+	decision := imported.Decisions[0]
+	if decision.ID != export.Decisions[0].ID || decision.Rationale != rationale || !reflect.DeepEqual(decision.Tags, []string{"release"}) {
+		t.Errorf("decision = %+v", decision)
+	}
+	if imported.Minutes == nil || imported.Minutes.Content != export.Minutes.Content {
+		t.Errorf("minutes = %+v", imported.Minutes)
+	}
+}
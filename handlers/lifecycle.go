@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 回收站中的会话默认保留 30 天
+const defaultTrashRetentionDays = 30
+
+func trashRetention() time.Duration {
+	days := defaultTrashRetentionDays
+	if value := os.Getenv("TRASH_RETENTION_DAYS"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			days = n
+		} else {
+			appLog.Warn("Invalid TRASH_RETENTION_DAYS, using default", "value", value)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// notDeleted adds the condition that leaves out sessions in the trash
+func notDeleted(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// sessionLive reports whether a session exists and is not in the trash
+func sessionLive(ctx context.Context, sessionID string) bool {
+	objectID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return false
+	}
+	count, err := sessionCollection.CountDocuments(ctx, notDeleted(bson.M{"_id": objectID}), options.Count().SetLimit(1))
+	return err == nil && count > 0
+}
+
+// timelineStatus derives the status of a session that is not archived from
+// when it was scheduled, started and ended. Sessions created before statuses
+// were stored get theirs this way.
+func timelineStatus(session models.Session) string {
+	switch {
+	case session.EndedAt != nil:
+		return models.SessionEnded
+	case session.StartedAt != nil:
+		return models.SessionLive
+	case session.ScheduledStart != nil:
+		return models.SessionScheduled
+	}
+	return models.SessionDraft
+}
+
+func sessionStatus(session models.Session) string {
+	if session.Status != "" {
+		return session.Status
+	}
+	return timelineStatus(session)
+}
+
+// backfillSessionStatuses stores the status of sessions created before
+// statuses existed, so they can be filtered by status
+func backfillSessionStatuses(ctx context.Context) {
+	cursor, err := sessionCollection.Find(ctx, bson.M{"status": bson.M{"$exists": false}})
+	if err != nil {
+		lifecycleLog.Error("Failed to fetch sessions without status", "error", err)
+		return
+	}
+	var sessions []models.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		lifecycleLog.Error("Failed to fetch sessions without status", "error", err)
+		return
+	}
+	for _, session := range sessions {
+		_, err := sessionCollection.UpdateOne(ctx, bson.M{"_id": session.ID, "status": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"status": timelineStatus(session)}})
+		if err != nil {
+			lifecycleLog.Error("Failed to set session status", "session_id", session.ID.Hex(), "error", err)
+		}
+	}
+}
+
+// loadFacilitatedSession loads a session for a lifecycle change that only
+// its facilitator may make
+func loadFacilitatedSession(w http.ResponseWriter, r *http.Request, filter bson.M, action string) (models.Session, bool) {
+	var session models.Session
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return session, false
+	}
+	objectID, err := primitive.ObjectIDFromHex(mux.Vars(r)["sessionId"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return session, false
+	}
+	filter["_id"] = objectID
+	if err := sessionCollection.FindOne(r.Context(), filter).Decode(&session); err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return session, false
+	}
+	if !isFacilitator(session, user.GitHubID) {
+		http.Error(w, "Only the facilitator can "+action+" the session", http.StatusForbidden)
+		return session, false
+	}
+	return session, true
+}
+
+// ArchiveSessionHandler moves a finished or unused session to the archive
+func ArchiveSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadFacilitatedSession(w, r, notDeleted(bson.M{}), "archive")
+	if !ok {
+		return
+	}
+	switch sessionStatus(session) {
+	case models.SessionArchived:
+		http.Error(w, "Session is already archived", http.StatusConflict)
+		return
+	case models.SessionLive:
+		http.Error(w, "End the meeting before archiving it", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	_, err := sessionCollection.UpdateOne(r.Context(), bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"status": models.SessionArchived, "archived_at": now}})
+	if err != nil {
+		logFor(r.Context()).Error("Failed to archive session", "error", err)
+		http.Error(w, "Failed to archive session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      models.SessionArchived,
+		"archived_at": now,
+	})
+}
+
+// UnarchiveSessionHandler takes a session out of the archive
+func UnarchiveSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadFacilitatedSession(w, r, notDeleted(bson.M{}), "unarchive")
+	if !ok {
+		return
+	}
+	if session.Status != models.SessionArchived {
+		http.Error(w, "Session is not archived", http.StatusConflict)
+		return
+	}
+
+	status := timelineStatus(session)
+	_, err := sessionCollection.UpdateOne(r.Context(), bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"status": status}, "$unset": bson.M{"archived_at": ""}})
+	if err != nil {
+		logFor(r.Context()).Error("Failed to unarchive session", "error", err)
+		http.Error(w, "Failed to unarchive session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status})
+}
+
+// GetTrashHandler lists the sessions in the trash with the time they will be
+// purged
+func GetTrashHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentUser(r); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}})
+	cursor, err := sessionCollection.Find(r.Context(), bson.M{"deleted_at": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch trash", http.StatusInternalServerError)
+		return
+	}
+	var sessions []models.Session
+	if err := cursor.All(r.Context(), &sessions); err != nil {
+		http.Error(w, "Failed to parse sessions", http.StatusInternalServerError)
+		return
+	}
+
+	retention := trashRetention()
+	trash := make([]map[string]interface{}, 0, len(sessions))
+	for _, session := range sessions {
+		trash = append(trash, map[string]interface{}{
+			"_id":        session.ID,
+			"name":       session.Name,
+			"created_at": session.CreatedAt,
+			"status":     sessionStatus(session),
+			"deleted_at": session.DeletedAt,
+			"deleted_by": session.DeletedBy,
+			"purge_at":   session.DeletedAt.Add(retention),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trash)
+}
+
+// RestoreSessionHandler takes a session out of the trash
+func RestoreSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadFacilitatedSession(w, r, bson.M{"deleted_at": bson.M{"$exists": true}}, "restore")
+	if !ok {
+		return
+	}
+	if time.Since(*session.DeletedAt) > trashRetention() {
+		http.Error(w, "Session can no longer be restored", http.StatusGone)
+		return
+	}
+
+	_, err := sessionCollection.UpdateOne(r.Context(), bson.M{"_id": session.ID},
+		bson.M{"$unset": bson.M{"deleted_at": "", "deleted_by": ""}})
+	if err != nil {
+		logFor(r.Context()).Error("Failed to restore session", "error", err)
+		http.Error(w, "Failed to restore session", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Session restored successfully"})
+}
+
+// PurgeSessionHandler permanently deletes a session from the trash
+func PurgeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadFacilitatedSession(w, r, bson.M{"deleted_at": bson.M{"$exists": true}}, "purge")
+	if !ok {
+		return
+	}
+	if err := purgeSession(r.Context(), session.ID); err != nil {
+		logFor(r.Context()).Error("Failed to purge session", "error", err)
+		http.Error(w, "Failed to purge session", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Session purged successfully"})
+}
+
+// purgeSession deletes a session and everything that belongs to it. The
+// session document goes last so a failed purge is retried by the next run.
+func purgeSession(ctx context.Context, sessionID primitive.ObjectID) error {
+	related := []*mongo.Collection{
+		minutesCollection,
+		actionItemCollection,
+		decisionCollection,
+		reactionCollection,
+		chatCollection,
+		outboxCollection,
+		webhookDeliveryCollection,
+	}
+	for _, collection := range related {
+		if _, err := collection.DeleteMany(ctx, bson.M{"session_id": sessionID}); err != nil {
+			return err
+		}
+	}
+	// 事件日志以房间记录，包括会话的分组讨论室
+	if _, err := eventCollection.DeleteMany(ctx, bson.M{"session_id": bson.M{"$regex": "^" + sessionID.Hex()}}); err != nil {
+		return err
+	}
+	_, err := sessionCollection.DeleteOne(ctx, bson.M{"_id": sessionID})
+	return err
+}
+
+// purgeTrash purges the sessions that have been in the trash longer than the
+// retention period
+func purgeTrash(ctx context.Context) {
+	cutoff := time.Now().Add(-trashRetention())
+	cursor, err := sessionCollection.Find(ctx, bson.M{"deleted_at": bson.M{"$lt": cutoff}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		logFor(ctx).Error("Failed to fetch expired sessions", "error", err)
+		return
+	}
+	var expired []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &expired); err != nil {
+		logFor(ctx).Error("Failed to fetch expired sessions", "error", err)
+		return
+	}
+
+	for _, session := range expired {
+		if err := purgeSession(ctx, session.ID); err != nil {
+			logFor(ctx).Error("Failed to purge session", "session_id", session.ID.Hex(), "error", err)
+			continue
+		}
+		logFor(ctx).Info("Purged session from the trash", "session_id", session.ID.Hex())
+	}
+}
+
+// StartTrashPurge runs purgeTrash in the background
+func StartTrashPurge(interval time.Duration) {
+	go func() {
+		for {
+			purgeTrash(withLogger(context.Background(), lifecycleLog))
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 日志以 JSON 输出到标准输出，每个子系统可以单独设置级别
+
+// logOutput is the handler all loggers write through. It lets everything
+// pass; subsystemHandler decides what is logged.
+var logOutput slog.Handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})
+
+var logLevels = struct {
+	sync.Mutex
+	levels map[string]*slog.LevelVar
+}{levels: make(map[string]*slog.LevelVar)}
+
+// Loggers of the subsystems. LOG_LEVELS uses these names.
+var (
+	appLog          = subsystemLogger("app")
+	httpLog         = subsystemLogger("http")
+	wsLog           = subsystemLogger("websocket")
+	dbLog           = subsystemLogger("db")
+	notificationLog = subsystemLogger("notifications")
+	webhookLog      = subsystemLogger("webhooks")
+	recurrenceLog   = subsystemLogger("recurrence")
+	lifecycleLog    = subsystemLogger("lifecycle")
+	githubLog       = subsystemLogger("github")
+	slackLog        = subsystemLogger("slack")
+	breakoutLog     = subsystemLogger("breakouts")
+)
+
+// subsystemHandler drops records below the level of its subsystem
+type subsystemHandler struct {
+	slog.Handler
+	level *slog.LevelVar
+}
+
+func (h subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return subsystemHandler{h.Handler.WithAttrs(attrs), h.level}
+}
+
+func (h subsystemHandler) WithGroup(name string) slog.Handler {
+	return subsystemHandler{h.Handler.WithGroup(name), h.level}
+}
+
+// subsystemLogger returns a logger whose records carry the subsystem name
+// and follow its level
+func subsystemLogger(name string) *slog.Logger {
+	logLevels.Lock()
+	defer logLevels.Unlock()
+	level, ok := logLevels.levels[name]
+	if !ok {
+		level = new(slog.LevelVar)
+		logLevels.levels[name] = level
+	}
+	return slog.New(subsystemHandler{logOutput, level}).With("subsystem", name)
+}
+
+func parseLevel(value string) (slog.Level, bool) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+		return 0, false
+	}
+	return level, true
+}
+
+// InitLogging sets the log levels from LOG_LEVEL, the default of every
+// subsystem, and LOG_LEVELS, a comma separated list of subsystem=level
+// overrides such as websocket=debug,webhooks=warn. The standard log package
+// is routed through the app logger, so nothing is written as plain text.
+func InitLogging() {
+	level := slog.LevelInfo
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		var ok bool
+		if level, ok = parseLevel(value); !ok {
+			appLog.Warn("Invalid LOG_LEVEL, using default", "value", value)
+			level = slog.LevelInfo
+		}
+	}
+
+	logLevels.Lock()
+	for _, levelVar := range logLevels.levels {
+		levelVar.Set(level)
+	}
+	var invalid []string
+	for _, override := range strings.Split(os.Getenv("LOG_LEVELS"), ",") {
+		if strings.TrimSpace(override) == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(override, "=")
+		levelVar, ok := logLevels.levels[strings.TrimSpace(name)]
+		subsystemLevel, valid := parseLevel(value)
+		if !ok || !valid {
+			invalid = append(invalid, override)
+			continue
+		}
+		levelVar.Set(subsystemLevel)
+	}
+	logLevels.Unlock()
+
+	for _, override := range invalid {
+		appLog.Warn("Ignoring invalid LOG_LEVELS entry", "entry", override)
+	}
+	slog.SetDefault(appLog)
+}
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+// logFor returns the logger of the request a context belongs to, or the app
+// logger for work that is not part of a request
+func logFor(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return appLog
+}
+
+// withLogger sets the logger of work done outside a request, such as the
+// subsystem logger of a background worker
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// requestID returns the ID of the request a context belongs to
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// validRequestID accepts IDs set by a proxy in front of the server as long as
+// they are short and printable
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDMiddleware gives every request an ID, taken from X-Request-ID or
+// generated, and returns it in the response. Handlers get a logger with the
+// request ID, the user ID and the session ID through logFor(r.Context()).
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			buf := make([]byte, 8)
+			rand.Read(buf)
+			id = hex.EncodeToString(buf)
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		logger := httpLog.With("request_id", id)
+		if authSession, err := store.Get(r, "auth-session"); err == nil {
+			if userID, ok := authSession.Values["user_id"].(int); ok {
+				logger = logger.With("user_id", userID)
+			}
+		}
+		if sessionID := mux.Vars(r)["sessionId"]; sessionID != "" {
+			logger = logger.With("session_id", sessionID)
+		}
+
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		ctx = context.WithValue(ctx, loggerKey, logger)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelDebug
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+		logger.Log(ctx, level, "Request finished",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// clientLogger is the logger of a meeting connection. Its records carry the
+// ID of the request that opened the connection, so they can be matched with
+// the HTTP requests of the same user.
+func clientLogger(r *http.Request, client *MeetingClient) *slog.Logger {
+	logger := wsLog.With(
+		"request_id", requestID(r.Context()),
+		"session_id", client.sessionID,
+		"user_id", client.userID,
+		"username", client.username,
+	)
+	if client.breakoutID != "" {
+		logger = logger.With("breakout_id", client.breakoutID)
+	}
+	if client.stream != nil {
+		logger = logger.With("transport", "sse", "client_id", client.id)
+	}
+	return logger
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// smtpConfig holds the SMTP settings read from the environment. Mail is
+// disabled when SMTP_HOST is not set.
+type smtpConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func loadSMTPConfig() smtpConfig {
+	config := smtpConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	if config.Port == "" {
+		config.Port = "587"
+	}
+	if config.From == "" {
+		config.From = "meetings@" + icsHost()
+	}
+	return config
+}
+
+func (c smtpConfig) Enabled() bool {
+	return c.Host != ""
+}
+
+// Send delivers a single message. net/smtp upgrades to STARTTLS when the
+// server offers it, so plain local SMTP sinks work as well.
+func (c smtpConfig) Send(message models.OutboxMessage) error {
+	body, err := buildMIMEMessage(c.From, message)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if c.Username != "" {
+		auth = smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(c.Host, c.Port), auth, c.From, []string{message.To}, body)
+}
+
+// buildMIMEMessage renders a multipart/mixed message with text and HTML
+// alternatives and any attachments.
+func buildMIMEMessage(from string, message models.OutboxMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	mixed := multipart.NewWriter(&buf)
+
+	headers := []string{
+		"From: " + from,
+		"To: " + message.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", message.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		fmt.Sprintf("Message-ID: <%s@%s>", primitive.NewObjectID().Hex(), icsHost()),
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q", mixed.Boundary()),
+	}
+	header := strings.Join(headers, "\r\n") + "\r\n\r\n"
+
+	var alternativeBuf bytes.Buffer
+	alternative := multipart.NewWriter(&alternativeBuf)
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", message.TextBody},
+		{"text/html; charset=utf-8", message.HTMLBody},
+	} {
+		w, err := alternative.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(w, []byte(part.body))
+	}
+	if err := alternative.Close(); err != nil {
+		return nil, err
+	}
+
+	w, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {fmt.Sprintf("multipart/alternative; boundary=%q", alternative.Boundary())},
+	})
+	if err != nil {
+		return nil, err
+	}
+	w.Write(alternativeBuf.Bytes())
+
+	for _, attachment := range message.Attachments {
+		w, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", attachment.Filename)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(w, attachment.Data)
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+
+	return append([]byte(header), buf.Bytes()...), nil
+}
+
+// writeBase64Lines writes base64 in 76 character lines as required by RFC 2045
+func writeBase64Lines(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// smtpSink is a minimal SMTP server that accepts every message and hands the
+// DATA of each one to its channel
+type smtpSink struct {
+	listener net.Listener
+	messages chan string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &smtpSink{listener: listener, messages: make(chan string, 1)}
+	t.Cleanup(func() { listener.Close() })
+	go sink.serve()
+	return sink
+}
+
+func (s *smtpSink) config() smtpConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return smtpConfig{Host: host, Port: port, From: "meetings@example.com"}
+}
+
+func (s *smtpSink) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpSink) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 sink ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 sink")
+		case strings.HasPrefix(command, "DATA"):
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.messages <- data.String()
+			reply("250 queued")
+		case strings.HasPrefix(command, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestInvitationIsDeliveredThroughSMTP(t *testing.T) {
+	loadEmailTemplates("../templates/email")
+	sink := newSMTPSink(t)
+
+	start := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+	session := models.Session{ID: primitive.NewObjectID(), Name: "Sprint review", ScheduledStart: &start}
+	invite := []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")
+	message, err := renderNotification(authUser{Username: "alice", Email: "alice@example.com"}, notification{
+		Kind:      "invitation",
+		Template:  "invitation",
+		Subject:   "Invitation: " + session.Name,
+		DedupeKey: "invitation:" + session.ID.Hex(),
+		SessionID: session.ID,
+		Data: map[string]interface{}{
+			"Session": session,
+			"Link":    sessionLink(session.ID),
+		},
+		Attachments: []models.EmailAttachment{{
+			Filename:    "invite.ics",
+			ContentType: "text/calendar; method=REQUEST; charset=utf-8",
+			Data:        invite,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("renderNotification: %v", err)
+	}
+	if message.DedupeKey != "invitation:"+session.ID.Hex()+":alice" {
+		t.Errorf("dedupe key = %q, want it scoped to the recipient", message.DedupeKey)
+	}
+
+	if err := sink.config().Send(message); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	var raw string
+	select {
+	case raw = <-sink.messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the sink received no message")
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	if to := parsed.Header.Get("To"); to != "alice@example.com" {
+		t.Errorf("To = %q", to)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if subject != "Invitation: Sprint review" {
+		t.Errorf("Subject = %q", subject)
+	}
+
+	_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Content-Type: %v", err)
+	}
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+
+	alternative, err := parts.NextPart()
+	if err != nil {
+		t.Fatalf("alternative part: %v", err)
+	}
+	_, params, _ = mime.ParseMediaType(alternative.Header.Get("Content-Type"))
+	bodies := multipart.NewReader(alternative, params["boundary"])
+	for _, want := range []string{"text/plain", "text/html"} {
+		part, err := bodies.NextPart()
+		if err != nil {
+			t.Fatalf("%s part: %v", want, err)
+		}
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), want) {
+			t.Errorf("part Content-Type = %q, want %s", part.Header.Get("Content-Type"), want)
+		}
+		body, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		if !strings.Contains(string(body), sessionLink(session.ID)) {
+			t.Errorf("%s body does not link to the session:\n%s", want, body)
+		}
+	}
+
+	attachment, err := parts.NextPart()
+	if err != nil {
+		t.Fatalf("attachment: %v", err)
+	}
+	if attachment.FileName() != "invite.ics" {
+		t.Errorf("attachment name = %q", attachment.FileName())
+	}
+	data, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, attachment))
+	if string(data) != string(invite) {
+		t.Errorf("attachment = %q, want %q", data, invite)
+	}
+}
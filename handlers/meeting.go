@@ -4,46 +4,101 @@ package handlers
 import (
 	"context"
 	"net/http"
+	"time"
 	"your-project/models"
 
-	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// NextParticipant returns the first participant who has not summarized yet,
+// or nil when everyone has
 func NextParticipant(sessionID string) (*models.Participant, error) {
+	// _id 存的是 ObjectID，用字符串查询永远找不到会话
+	objectID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Fetch the session
 	var session models.Session
-	err := sessionCollection.FindOne(context.Background(), bson.M{"_id": sessionID}).Decode(&session)
+	err = sessionCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": objectID})).Decode(&session)
 	if err != nil {
 		return nil, err
 	}
+	return nextParticipant(session), nil
+}
 
+// nextParticipant returns the first participant of a loaded session who has
+// not summarized yet, or nil when everyone has
+func nextParticipant(session models.Session) *models.Participant {
 	for _, participant := range session.Participants {
 		if !participant.Summarized {
-			return &participant, nil
+			return &participant
 		}
 	}
-
-	return nil, nil // All participants have summarized
+	return nil // All participants have summarized
 }
 
-func StartMeetingHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	sessionID := vars["sessionId"]
+// markMeeting records the first time a meeting started or ended and emits the
+// matching webhook event. field is started_at or ended_at.
+func markMeeting(sessionID string, field, event string) {
+	objectID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return
+	}
 
-	participant, err := NextParticipant(sessionID)
+	status := models.SessionLive
+	if field == "ended_at" {
+		status = models.SessionEnded
+	}
+
+	now := time.Now()
+	result, err := sessionCollection.UpdateOne(context.Background(),
+		bson.M{"_id": objectID, field: bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{field: now, "status": status}},
+	)
 	if err != nil {
-		http.Error(w, "Failed to determine next participant", http.StatusInternalServerError)
+		appLog.Error("Failed to update meeting", "session_id", sessionID, "field", field, "error", err)
+		return
+	}
+	if result.ModifiedCount == 0 {
 		return
 	}
 
-	if participant != nil {
-		// Notify clients via WebSocket
-		Broadcast(sessionID, map[string]interface{}{
+	emitWebhookEvent(context.Background(), objectID, event, map[string]interface{}{
+		"session_id": objectID,
+		field:        now,
+	})
+
+	if event == models.EventMeetingEnded {
+		go postMeetingResults(context.Background(), objectID)
+	}
+}
+
+// StartMeetingHandler starts the meeting or calls the next speaker; once
+// everyone has summarized it ends the meeting. Only the facilitator can.
+func StartMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadFacilitatedSession(w, r, notDeleted(bson.M{}), "start")
+	if !ok {
+		return
+	}
+	sessionID := session.ID.Hex()
+
+	if participant := nextParticipant(session); participant != nil {
+		markMeeting(sessionID, "started_at", models.EventMeetingStarted)
+		message := map[string]interface{}{
 			"type":        "nextParticipant",
 			"participant": participant,
-		})
+		}
+		// 每位发言人的限时（秒）
+		if session.SpeakerTimebox > 0 {
+			message["timebox"] = session.SpeakerTimebox
+		}
+		// Notify clients via WebSocket
+		Broadcast(sessionID, message)
 	} else {
+		markMeeting(sessionID, "ended_at", models.EventMeetingEnded)
 		Broadcast(sessionID, map[string]interface{}{
 			"type": "meetingEnded",
 		})
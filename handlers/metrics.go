@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// 指标注册在单独的 registry 中，/metrics 只输出这里的指标
+
+var defaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of HTTP requests by route template and method. WebSocket and event stream routes are left out.",
+		Buckets: defaultLatencyBuckets,
+	}, []string{"route", "method"})
+	broadcastFanout = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "broadcast_fanout_recipients",
+		Help:    "Number of connections each room broadcast was sent to.",
+		Buckets: []float64{0, 1, 2, 5, 10, 25, 50, 100, 250},
+	}, []string{"kind"})
+	broadcastWriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "broadcast_write_errors_total",
+		Help: "Failed writes of broadcast messages to a connection.",
+	}, []string{"kind"})
+	mongoDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongodb_operation_duration_seconds",
+		Help:    "Latency of MongoDB commands by collection and command.",
+		Buckets: defaultLatencyBuckets,
+	}, []string{"collection", "command"})
+	mongoErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_operation_errors_total",
+		Help: "Failed MongoDB commands by collection and command.",
+	}, []string{"collection", "command"})
+	oauthLogins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "oauth_logins_total",
+		Help: "GitHub OAuth logins by result.",
+	}, []string{"result"})
+)
+
+// openRooms returns a snapshot of the rooms by key
+func openRooms() map[string]*meetingRoom {
+	meetingRooms.Lock()
+	defer meetingRooms.Unlock()
+	rooms := make(map[string]*meetingRoom, len(meetingRooms.rooms))
+	for key, room := range meetingRooms.rooms {
+		rooms[key] = room
+	}
+	return rooms
+}
+
+// connectionGauge counts the open connections by transport
+func connectionGauge() map[string]float64 {
+	values := map[string]float64{"websocket": 0, "sse": 0}
+	for _, room := range openRooms() {
+		room.Lock()
+		for _, client := range room.clients {
+			if client.stream != nil {
+				values["sse"]++
+			} else {
+				values["websocket"]++
+			}
+		}
+		room.Unlock()
+	}
+	return values
+}
+
+// roomGauge counts the rooms with open connections, breakout rooms included.
+// Rooms whose participants are all in their reconnect grace are left out.
+func roomGauge() map[string]float64 {
+	values := map[string]float64{"session": 0, "breakout": 0}
+	for key, room := range openRooms() {
+		room.Lock()
+		empty := len(room.clients) == 0
+		room.Unlock()
+		if empty {
+			continue
+		}
+		if strings.Contains(key, "/breakouts/") {
+			values["breakout"]++
+		} else {
+			values["session"]++
+		}
+	}
+	return values
+}
+
+// gaugeFuncs registers one gauge per label value, each read from collect
+// when the metrics are scraped
+func gaugeFuncs(name, help, label string, values []string, collect func() map[string]float64) []prometheus.Collector {
+	gauges := make([]prometheus.Collector, 0, len(values))
+	for _, value := range values {
+		value := value
+		gauges = append(gauges, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        name,
+			Help:        help,
+			ConstLabels: prometheus.Labels{label: value},
+		}, func() float64 { return collect()[value] }))
+	}
+	return gauges
+}
+
+var metricsRegistry = newMetricsRegistry()
+
+func newMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(httpRequests, httpDuration, broadcastFanout, broadcastWriteErrors, mongoDuration, mongoErrors, oauthLogins)
+	registry.MustRegister(gaugeFuncs("websocket_connections", "Open meeting connections by transport.",
+		"transport", []string{"websocket", "sse"}, connectionGauge)...)
+	registry.MustRegister(gaugeFuncs("meeting_rooms", "Rooms with open connections by kind.",
+		"kind", []string{"session", "breakout"}, roomGauge)...)
+	return registry
+}
+
+var metricsExporter = promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+
+// MetricsHandler serves the metrics in the Prometheus text format. When
+// METRICS_TOKEN is set the scraper has to send it as a bearer token.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if token := os.Getenv("METRICS_TOKEN"); token != "" {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	metricsExporter.ServeHTTP(w, r)
+}
+
+// statusRecorder remembers the status code of a response. WebSocket upgrades
+// and event streams still need the hijacker and flusher underneath.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(data)
+}
+
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking is not supported")
+	}
+	// 升级成功后不会再写状态码
+	rec.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// streamingRoute reports whether a route holds its connection open for the
+// whole meeting. Its duration is the length of the connection, not latency.
+func streamingRoute(template string) bool {
+	return strings.HasPrefix(template, "/ws/") || strings.HasSuffix(template, "/events")
+}
+
+// MetricsMiddleware counts requests and their latency by the route template
+// they matched, so /api/sessions/{sessionId} is one series for all sessions
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		if !streamingRoute(route) {
+			httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		}
+	})
+}
+
+// mongoCommands remembers the collection of each running command until it
+// finishes
+var mongoCommands sync.Map
+
+// MongoMonitor records the latency of every MongoDB command by collection
+func MongoMonitor() *event.CommandMonitor {
+	finished := func(requestID int64, command string, duration time.Duration, failed bool) {
+		collection := ""
+		if value, ok := mongoCommands.LoadAndDelete(requestID); ok {
+			collection = value.(string)
+		}
+		mongoDuration.WithLabelValues(collection, command).Observe(duration.Seconds())
+		if failed {
+			mongoErrors.WithLabelValues(collection, command).Inc()
+		}
+		dbLog.Debug("MongoDB command finished", "collection", collection, "command", command,
+			"duration_ms", duration.Milliseconds(), "failed", failed)
+	}
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			mongoCommands.Store(e.RequestID, commandCollection(e.CommandName, e.Command))
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finished(e.RequestID, e.CommandName, e.Duration, false)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finished(e.RequestID, e.CommandName, e.Duration, true)
+		},
+	}
+}
+
+// commandCollection is the collection a command works on: the value of the
+// command itself for find, insert, update and the like, or the collection
+// field for getMore. Commands such as ping have none.
+func commandCollection(name string, command bson.Raw) string {
+	if value, err := command.LookupErr(name); err == nil {
+		if collection, ok := value.StringValueOK(); ok {
+			return collection
+		}
+	}
+	if value, err := command.LookupErr("collection"); err == nil {
+		if collection, ok := value.StringValueOK(); ok {
+			return collection
+		}
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func scrapeMetrics(t *testing.T, token string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	MetricsHandler(rec, req)
+	body, _ := io.ReadAll(rec.Body)
+	return rec.Code, string(body)
+}
+
+func TestMetricsLeaveStreamingRoutesOutOfLatency(t *testing.T) {
+	t.Setenv("METRICS_TOKEN", "scrape-token")
+	// 计数器是全局的，go test -count=N 时从零开始
+	httpRequests.Reset()
+	httpDuration.Reset()
+
+	r := mux.NewRouter()
+	r.Use(MetricsMiddleware)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.HandleFunc("/ws/sessions/{sessionId}", ok)
+	r.HandleFunc("/api/sessions/{sessionId}/events", ok)
+	r.HandleFunc("/api/sessions/{sessionId}", ok)
+	for _, path := range []string{"/ws/sessions/1", "/api/sessions/1/events", "/api/sessions/1"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if code, _ := scrapeMetrics(t, ""); code != http.StatusUnauthorized {
+		t.Errorf("scrape without token: status = %d, want 401", code)
+	}
+	code, body := scrapeMetrics(t, "scrape-token")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/ws/sessions/{sessionId}",status="200"} 1`,
+		`http_requests_total{method="GET",route="/api/sessions/{sessionId}/events",status="200"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/api/sessions/{sessionId}"} 1`,
+		`websocket_connections{transport="websocket"} 0`,
+		`websocket_connections{transport="sse"} 0`,
+		`meeting_rooms{kind="session"} 0`,
+		`meeting_rooms{kind="breakout"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "http_request_duration_seconds") &&
+			(strings.Contains(line, `route="/ws/`) || strings.Contains(line, `/events"`)) {
+			t.Errorf("streaming route in latency histogram: %s", line)
+		}
+	}
+}
@@ -35,10 +35,15 @@ func GetMinutesHandler(w http.ResponseWriter, r *http.Request) {
 	var minutes models.Minutes
 	err = minutesCollection.FindOne(context.Background(), bson.M{"session_id": objectID}).Decode(&minutes)
 	if err == mongo.ErrNoDocuments {
-		// If no minutes exist, return an empty minutes object
+		// If no minutes exist, return minutes scaffolded from the agenda
+		content := ""
+		var session models.Session
+		if err := sessionCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": objectID})).Decode(&session); err == nil {
+			content = scaffoldMinutes(session.Agenda)
+		}
 		minutes = models.Minutes{
 			SessionID: objectID,
-			Content:   "",
+			Content:   content,
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		}
@@ -122,5 +127,15 @@ func UpdateMinutesHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	w.WriteHeader(http.StatusOK)
+	// 从纪要中识别 "- [ ] @user task" 形式的待办事项，返回带上条目标记的纪要
+	content := syncActionItemsFromMinutes(context.Background(), sessionObjectID, input.Content)
+
+	emitWebhookEvent(context.Background(), sessionObjectID, models.EventMinutesUpdated, map[string]interface{}{
+		"session_id": sessionObjectID,
+		"content":    content,
+		"updated_at": now,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"content": content, "updated_at": now})
 }
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"math"
+	"net/http"
+	"path/filepath"
+	texttemplate "text/template"
+	"time"
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	outboxCollection *mongo.Collection
+	mailer           smtpConfig
+
+	emailHTMLTemplates *htmltemplate.Template
+	emailTextTemplates *texttemplate.Template
+)
+
+const (
+	outboxMaxAttempts = 6
+	outboxBaseBackoff = time.Minute
+	// 超过这个时间仍处于 sending 状态的消息视为发送进程已退出
+	outboxLockTimeout = 10 * time.Minute
+)
+
+func InitNotifications(client *mongo.Client) {
+	outboxCollection = client.Database("your-db-name").Collection("outbox")
+	mailer = loadSMTPConfig()
+	loadEmailTemplates("templates/email")
+
+	// 同一通知只入队一次；没有 dedupe_key 的消息不受限制
+	_, err := outboxCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "dedupe_key", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"dedupe_key": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		dbLog.Error("Failed to create indexes", "collection", "outbox", "error", err)
+	}
+}
+
+// notification describes an e-mail sent to a set of users. Template names
+// the pair of files in templates/email rendered with Data plus the
+// recipient as .User.
+type notification struct {
+	Kind        string // invitation, reminder, minutes_published, action_item, mention
+	Template    string
+	Subject     string
+	Data        map[string]interface{}
+	DedupeKey   string
+	SessionID   primitive.ObjectID // purged with the session
+	Attachments []models.EmailAttachment
+}
+
+func notificationPreferences(user authUser) models.NotificationPreferences {
+	if user.NotificationPreferences != nil {
+		return *user.NotificationPreferences
+	}
+	return models.DefaultNotificationPreferences()
+}
+
+func (n notification) wanted(prefs models.NotificationPreferences) bool {
+	switch n.Kind {
+	case "invitation":
+		return prefs.Invitations
+	case "reminder":
+		return prefs.Reminders
+	case "minutes_published":
+		return prefs.MinutesPublished
+	case "action_item":
+		return prefs.ActionItems
+	case "mention":
+		return !prefs.MuteMentions
+	}
+	return true
+}
+
+// notifyUsernames queues the notification for every user that has an e-mail
+// address and has not opted out of this kind of notification.
+func notifyUsernames(ctx context.Context, usernames []string, n notification) {
+	if !mailer.Enabled() || len(usernames) == 0 {
+		return
+	}
+
+	users, err := findUsersByUsername(ctx, usernames)
+	if err != nil {
+		logFor(ctx).Error("Failed to look up users for notification", "kind", n.Kind, "error", err)
+		return
+	}
+
+	for _, user := range users {
+		if user.Email == "" || !n.wanted(notificationPreferences(user)) {
+			continue
+		}
+		if err := enqueueNotification(ctx, user, n); err != nil {
+			logFor(ctx).Error("Failed to queue notification", "kind", n.Kind, "username", user.Username, "error", err)
+		}
+	}
+}
+
+// loadEmailTemplates parses the HTML and text templates of the e-mails in dir
+func loadEmailTemplates(dir string) {
+	emailHTMLTemplates = htmltemplate.Must(htmltemplate.ParseGlob(filepath.Join(dir, "*.html")))
+	emailTextTemplates = texttemplate.Must(texttemplate.ParseGlob(filepath.Join(dir, "*.txt")))
+}
+
+func enqueueNotification(ctx context.Context, user authUser, n notification) error {
+	message, err := renderNotification(user, n)
+	if err != nil {
+		return err
+	}
+	_, err = outboxCollection.InsertOne(ctx, message)
+	if mongo.IsDuplicateKeyError(err) {
+		// 已经入队过
+		return nil
+	}
+	return err
+}
+
+// renderNotification renders the e-mail of a notification for one user as a
+// pending outbox message
+func renderNotification(user authUser, n notification) (models.OutboxMessage, error) {
+	dedupeKey := ""
+	if n.DedupeKey != "" {
+		dedupeKey = n.DedupeKey + ":" + user.Username
+	}
+
+	data := map[string]interface{}{"User": user, "AppURL": frontendURL()}
+	for key, value := range n.Data {
+		data[key] = value
+	}
+
+	var htmlBody, textBody bytes.Buffer
+	if err := emailHTMLTemplates.ExecuteTemplate(&htmlBody, n.Template+".html", data); err != nil {
+		return models.OutboxMessage{}, err
+	}
+	if err := emailTextTemplates.ExecuteTemplate(&textBody, n.Template+".txt", data); err != nil {
+		return models.OutboxMessage{}, err
+	}
+
+	now := time.Now()
+	return models.OutboxMessage{
+		ID:            primitive.NewObjectID(),
+		Kind:          n.Kind,
+		DedupeKey:     dedupeKey,
+		SessionID:     n.SessionID,
+		To:            user.Email,
+		Subject:       n.Subject,
+		TextBody:      textBody.String(),
+		HTMLBody:      htmlBody.String(),
+		Attachments:   n.Attachments,
+		Status:        models.OutboxPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}, nil
+}
+
+func sessionUsernames(session models.Session) []string {
+	usernames := make([]string, 0, len(session.Participants))
+	for _, participant := range session.Participants {
+		usernames = append(usernames, participant.Username)
+	}
+	return usernames
+}
+
+func sessionLink(sessionID primitive.ObjectID) string {
+	return fmt.Sprintf("%s/sessions/%s", frontendURL(), sessionID.Hex())
+}
+
+// notifyInvitation sends the invitation with an .ics attachment to every
+// participant of a scheduled session.
+func notifyInvitation(ctx context.Context, session models.Session) {
+	if session.ScheduledStart == nil {
+		return
+	}
+	notifyUsernames(ctx, sessionUsernames(session), notification{
+		Kind:      "invitation",
+		Template:  "invitation",
+		Subject:   "Invitation: " + session.Name,
+		DedupeKey: "invitation:" + session.ID.Hex() + ":" + session.ScheduledStart.UTC().Format(time.RFC3339),
+		SessionID: session.ID,
+		Data: map[string]interface{}{
+			"Session": session,
+			"Link":    sessionLink(session.ID),
+		},
+		Attachments: []models.EmailAttachment{{
+			Filename:    "invite.ics",
+			ContentType: "text/calendar; method=REQUEST; charset=utf-8",
+			Data:        sessionInvitation(ctx, session),
+		}},
+	})
+}
+
+// notifyActionItemAssigned tells the assignee about a new or reassigned item
+func notifyActionItemAssigned(ctx context.Context, item models.ActionItem) {
+	if item.AssigneeUsername == "" {
+		return
+	}
+	var session models.Session
+	if err := sessionCollection.FindOne(ctx, notDeleted(bson.M{"_id": item.SessionID})).Decode(&session); err != nil {
+		logFor(ctx).Error("Failed to load session for action item notification", "action_item_id", item.ID.Hex(), "error", err)
+		return
+	}
+	notifyUsernames(ctx, []string{item.AssigneeUsername}, notification{
+		Kind:      "action_item",
+		Template:  "action_item",
+		Subject:   "Action item assigned: " + item.Title,
+		DedupeKey: "action_item:" + item.ID.Hex() + ":" + item.AssigneeUsername,
+		SessionID: session.ID,
+		Data: map[string]interface{}{
+			"Item":    item,
+			"Session": session,
+			"Link":    sessionLink(session.ID),
+		},
+	})
+}
+
+// sendReminders queues reminders for sessions starting soon. Each user's
+// reminder lead time comes from their preferences.
+func sendReminders(ctx context.Context) {
+	now := time.Now()
+	// 最长提前一天提醒
+	cursor, err := sessionCollection.Find(ctx, notDeleted(bson.M{
+		"scheduled_start": bson.M{"$gt": now, "$lte": now.Add(24 * time.Hour)},
+	}))
+	if err != nil {
+		logFor(ctx).Error("Failed to fetch upcoming sessions", "error", err)
+		return
+	}
+	var sessions []models.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		logFor(ctx).Error("Failed to parse upcoming sessions", "error", err)
+		return
+	}
+
+	for _, session := range sessions {
+		users, err := findUsersByUsername(ctx, sessionUsernames(session))
+		if err != nil {
+			logFor(ctx).Error("Failed to look up participants for reminders", "session_id", session.ID.Hex(), "error", err)
+			continue
+		}
+		var due []string
+		for username, user := range users {
+			prefs := notificationPreferences(user)
+			lead := time.Duration(prefs.ReminderMinutes) * time.Minute
+			if !session.ScheduledStart.Add(-lead).After(now) {
+				due = append(due, username)
+			}
+		}
+		notifyUsernames(ctx, due, notification{
+			Kind:      "reminder",
+			Template:  "reminder",
+			Subject:   "Reminder: " + session.Name,
+			DedupeKey: "reminder:" + session.ID.Hex() + ":" + session.ScheduledStart.UTC().Format(time.RFC3339),
+			SessionID: session.ID,
+			Data: map[string]interface{}{
+				"Session": session,
+				"Link":    sessionLink(session.ID),
+			},
+		})
+	}
+}
+
+// deliverOutbox sends due messages until none are left
+func deliverOutbox(ctx context.Context) {
+	for {
+		now := time.Now()
+		var message models.OutboxMessage
+		err := outboxCollection.FindOneAndUpdate(ctx,
+			bson.M{"$or": bson.A{
+				bson.M{"status": models.OutboxPending, "next_attempt_at": bson.M{"$lte": now}},
+				bson.M{"status": models.OutboxSending, "locked_at": bson.M{"$lt": now.Add(-outboxLockTimeout)}},
+			}},
+			bson.M{"$set": bson.M{"status": models.OutboxSending, "locked_at": now}},
+			options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetReturnDocument(options.After),
+		).Decode(&message)
+		if err == mongo.ErrNoDocuments {
+			return
+		} else if err != nil {
+			logFor(ctx).Error("Failed to claim outbox message", "error", err)
+			return
+		}
+
+		update := bson.M{"attempts": message.Attempts + 1}
+		if err := mailer.Send(message); err != nil {
+			update["last_error"] = err.Error()
+			if message.Attempts+1 >= outboxMaxAttempts {
+				update["status"] = models.OutboxFailed
+				logFor(ctx).Error("Giving up on e-mail", "message_id", message.ID.Hex(), "to", message.To, "error", err)
+			} else {
+				// 指数退避：1, 2, 4, 8... 分钟
+				backoff := outboxBaseBackoff * time.Duration(math.Pow(2, float64(message.Attempts)))
+				update["status"] = models.OutboxPending
+				update["next_attempt_at"] = time.Now().Add(backoff)
+				logFor(ctx).Warn("Failed to send e-mail, retrying", "message_id", message.ID.Hex(), "to", message.To, "backoff", backoff.String(), "error", err)
+			}
+		} else {
+			update["status"] = models.OutboxSent
+			update["sent_at"] = time.Now()
+		}
+
+		_, err = outboxCollection.UpdateOne(ctx, bson.M{"_id": message.ID}, bson.M{
+			"$set":   update,
+			"$unset": bson.M{"locked_at": ""},
+		})
+		if err != nil {
+			logFor(ctx).Error("Failed to update outbox message", "message_id", message.ID.Hex(), "error", err)
+		}
+	}
+}
+
+// StartNotificationWorkers starts the outbox delivery and reminder loops.
+// Nothing is started when SMTP is not configured.
+func StartNotificationWorkers(interval time.Duration) {
+	if !mailer.Enabled() {
+		notificationLog.Info("SMTP_HOST is not set, e-mail notifications are disabled")
+		return
+	}
+	go func() {
+		for {
+			ctx := withLogger(context.Background(), notificationLog)
+			sendReminders(ctx)
+			deliverOutbox(ctx)
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// GetNotificationPreferencesHandler returns the current user's preferences
+func GetNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notificationPreferences(*user))
+}
+
+// UpdateNotificationPreferencesHandler replaces the current user's preferences
+func UpdateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	prefs := notificationPreferences(*user)
+	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if prefs.ReminderMinutes < 0 || prefs.ReminderMinutes > 24*60 {
+		http.Error(w, "reminder_minutes must be between 0 and 1440", http.StatusBadRequest)
+		return
+	}
+
+	_, err := Client.Database("your-db-name").Collection("users").UpdateOne(r.Context(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"notification_preferences": prefs}},
+	)
+	if err != nil {
+		logFor(r.Context()).Error("Failed to save notification preferences", "error", err)
+		http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prefs)
+}
+
+// PublishMinutesHandler sends the minutes digest to every participant
+func PublishMinutesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+
+	var minutes models.Minutes
+	if err := minutesCollection.FindOne(r.Context(), bson.M{"session_id": session.ID}).Decode(&minutes); err != nil {
+		http.Error(w, "Minutes not found", http.StatusNotFound)
+		return
+	}
+	minutes.Content = stripActionItemMarkers(minutes.Content)
+
+	items := []models.ActionItem{}
+	cursor, err := actionItemCollection.Find(r.Context(), bson.M{"session_id": session.ID, "status": models.ActionItemOpen})
+	if err == nil {
+		err = cursor.All(r.Context(), &items)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch action items", http.StatusInternalServerError)
+		return
+	}
+
+	decisions := []models.Decision{}
+	cursor, err = decisionCollection.Find(r.Context(), bson.M{"session_id": session.ID})
+	if err == nil {
+		err = cursor.All(r.Context(), &decisions)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch decisions", http.StatusInternalServerError)
+		return
+	}
+
+	notifyUsernames(r.Context(), sessionUsernames(session), notification{
+		Kind:      "minutes_published",
+		Template:  "minutes_published",
+		Subject:   "Minutes: " + session.Name,
+		DedupeKey: "minutes:" + session.ID.Hex() + ":" + minutes.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		SessionID: session.ID,
+		Data: map[string]interface{}{
+			"Session":     session,
+			"Minutes":     minutes,
+			"ActionItems": items,
+			"Decisions":   decisions,
+			"PublishedBy": user.Username,
+			"Link":        sessionLink(session.ID),
+		},
+	})
+
+	Broadcast(session.ID.Hex(), map[string]interface{}{
+		"type":        "minutesPublished",
+		"publishedBy": user.Username,
+	})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Minutes published successfully"})
+}
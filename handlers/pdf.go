@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// pdfDocument is a minimal PDF writer for text documents. Latin text uses the
+// standard Helvetica fonts with WinAnsiEncoding; Chinese and other CJK text
+// uses the predefined STSong-Light font, which PDF readers provide without it
+// being embedded. Unsupported reports whether any text had characters neither
+// can show, such as emoji.
+type pdfDocument struct {
+	pages       []*bytes.Buffer
+	y           float64
+	Unsupported bool
+}
+
+// errPDFUnsupportedText is returned for documents the PDF writer cannot show
+var errPDFUnsupportedText = errors.New("text has characters the PDF fonts cannot show")
+
+const (
+	pdfPageWidth  = 595.28 // A4
+	pdfPageHeight = 841.89
+	pdfMargin     = 56.0
+)
+
+// pdfHelveticaWidths holds the Helvetica glyph widths for ASCII 32-126 in
+// 1/1000 of the font size.
+var pdfHelveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// pdfWinAnsiExtra maps the characters WinAnsiEncoding (cp1252) places at
+// 0x80-0x9F, such as smart quotes and dashes, with their Helvetica widths
+var pdfWinAnsiExtra = map[rune]struct {
+	code  byte
+	width int
+}{
+	'€': {0x80, 556}, '‚': {0x82, 222}, 'ƒ': {0x83, 556}, '„': {0x84, 333},
+	'…': {0x85, 1000}, '†': {0x86, 556}, '‡': {0x87, 556}, 'ˆ': {0x88, 333},
+	'‰': {0x89, 1000}, 'Š': {0x8A, 667}, '‹': {0x8B, 333}, 'Œ': {0x8C, 1000},
+	'Ž': {0x8E, 611}, '‘': {0x91, 222}, '’': {0x92, 222}, '“': {0x93, 333},
+	'”': {0x94, 333}, '•': {0x95, 350}, '–': {0x96, 556}, '—': {0x97, 1000},
+	'˜': {0x98, 333}, '™': {0x99, 1000}, 'š': {0x9A, 500}, '›': {0x9B, 333},
+	'œ': {0x9C, 944}, 'ž': {0x9E, 500}, 'Ÿ': {0x9F, 667},
+}
+
+func newPDFDocument() *pdfDocument {
+	return &pdfDocument{}
+}
+
+func (d *pdfDocument) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// Space adds vertical whitespace
+func (d *pdfDocument) Space(height float64) {
+	if len(d.pages) == 0 {
+		d.newPage()
+	}
+	d.y -= height
+}
+
+// Text writes a block of text, wrapping it to the page width
+func (d *pdfDocument) Text(text string, size float64, bold bool, indent float64) {
+	maxWidth := pdfPageWidth - 2*pdfMargin - indent
+	for _, r := range text {
+		if r >= 32 && !pdfEncodable(r) {
+			d.Unsupported = true
+		}
+	}
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := pdfNormalize(strings.TrimRight(paragraph, "\r"))
+		if len(line) == 0 {
+			d.writeLine(nil, size, bold, indent)
+			continue
+		}
+		for _, wrapped := range pdfWrap(line, size, bold, maxWidth) {
+			d.writeLine(wrapped, size, bold, indent)
+		}
+	}
+}
+
+func (d *pdfDocument) writeLine(line []rune, size float64, bold bool, indent float64) {
+	leading := size * 1.4
+	if len(d.pages) == 0 || d.y-leading < pdfMargin {
+		d.newPage()
+	}
+	d.y -= leading
+	if len(line) == 0 {
+		return
+	}
+
+	latin := "F1"
+	if bold {
+		latin = "F2"
+	}
+	page := d.pages[len(d.pages)-1]
+	fmt.Fprintf(page, "BT %.2f %.2f Td ", pdfMargin+indent, d.y)
+	// 同一行中拉丁文字和中文分段切换字体，Tj 之后文字位置自动前移
+	for len(line) > 0 {
+		cjk := pdfCJK(line[0])
+		n := 1
+		for n < len(line) && pdfCJK(line[n]) == cjk {
+			n++
+		}
+		if cjk {
+			fmt.Fprintf(page, "/F3 %.1f Tf <", size)
+			for _, r := range line[:n] {
+				fmt.Fprintf(page, "%04X", r)
+			}
+			page.WriteString("> Tj ")
+		} else {
+			fmt.Fprintf(page, "/%s %.1f Tf (", latin, size)
+			pdfEscape(page, pdfEncode(line[:n]))
+			page.WriteString(") Tj ")
+		}
+		line = line[n:]
+	}
+	page.WriteString("ET\n")
+}
+
+// Bytes serializes the document
+func (d *pdfDocument) Bytes() []byte {
+	if len(d.pages) == 0 {
+		d.newPage()
+	}
+
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+
+	// 1: catalog, 2: page tree, 3-4: Helvetica, 5-7: STSong-Light, then a page
+	// and content stream per page
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 8+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type0 /BaseFont /STSong-Light /Encoding /UniGB-UCS2-H /DescendantFonts [6 0 R] >>")
+	object("<< /Type /Font /Subtype /CIDFontType0 /BaseFont /STSong-Light /CIDSystemInfo << /Registry (Adobe) /Ordering (GB1) /Supplement 2 >> /FontDescriptor 7 0 R /DW 1000 >>")
+	object("<< /Type /FontDescriptor /FontName /STSong-Light /Flags 6 /FontBBox [-25 -254 1000 880] /ItalicAngle 0 /Ascent 880 /Descent -120 /CapHeight 880 /StemV 93 >>")
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 9+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes()
+}
+
+// pdfNormalize expands tabs, drops control characters and replaces the
+// characters no font can show with "?"
+func pdfNormalize(text string) []rune {
+	line := make([]rune, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r == '\t':
+			line = append(line, ' ', ' ', ' ', ' ')
+		case r < 32:
+			continue
+		case pdfEncodable(r):
+			line = append(line, r)
+		default:
+			line = append(line, '?')
+		}
+	}
+	return line
+}
+
+// pdfEncode converts Latin text to single-byte WinAnsi characters
+func pdfEncode(text []rune) []byte {
+	encoded := make([]byte, 0, len(text))
+	for _, r := range text {
+		if extra, ok := pdfWinAnsiExtra[r]; ok {
+			encoded = append(encoded, extra.code)
+		} else if r < 256 {
+			encoded = append(encoded, byte(r))
+		} else {
+			encoded = append(encoded, '?')
+		}
+	}
+	return encoded
+}
+
+// pdfEncodable reports whether a printable character can be shown, either
+// with a WinAnsi code or with the CJK font
+func pdfEncodable(r rune) bool {
+	if _, ok := pdfWinAnsiExtra[r]; ok {
+		return true
+	}
+	return r < 127 || (r >= 160 && r < 256) || pdfCJK(r)
+}
+
+// pdfCJK reports whether a character is shown with the CJK font: Han
+// characters, kana, CJK punctuation and full-width forms
+func pdfCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) && r <= 0xFFFF ||
+		r >= 0x3000 && r <= 0x30FF ||
+		r >= 0xFF00 && r <= 0xFFEF
+}
+
+func pdfEscape(buf *bytes.Buffer, line []byte) {
+	for _, c := range line {
+		switch c {
+		case '(', ')', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			if c >= 128 {
+				fmt.Fprintf(buf, "\\%03o", c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+}
+
+func pdfTextWidth(text []rune, size float64, bold bool) float64 {
+	total := 0
+	for _, r := range text {
+		switch extra, ok := pdfWinAnsiExtra[r]; {
+		case r >= 32 && r <= 126:
+			total += pdfHelveticaWidths[r-32]
+		case ok:
+			total += extra.width
+		case pdfCJK(r):
+			total += 1000
+		default:
+			total += 556
+		}
+	}
+	width := float64(total) * size / 1000
+	if bold {
+		// Helvetica-Bold 平均比常规字重宽约 6%
+		width *= 1.06
+	}
+	return width
+}
+
+// pdfWrap splits a line into chunks that fit maxWidth, breaking on spaces
+// where possible. Text without spaces, such as Chinese, is broken anywhere.
+func pdfWrap(line []rune, size float64, bold bool, maxWidth float64) [][]rune {
+	var lines [][]rune
+	var current []rune
+	for _, word := range splitRunes(line, ' ') {
+		candidate := word
+		if len(current) > 0 {
+			candidate = append(append(append([]rune{}, current...), ' '), word...)
+		}
+		if pdfTextWidth(candidate, size, bold) <= maxWidth {
+			current = candidate
+			continue
+		}
+		if len(current) > 0 {
+			lines = append(lines, current)
+		}
+		// 单词本身超过一行时强制断开
+		current = nil
+		for pdfTextWidth(word, size, bold) > maxWidth {
+			cut := len(word)
+			for cut > 1 && pdfTextWidth(word[:cut], size, bold) > maxWidth {
+				cut--
+			}
+			lines = append(lines, word[:cut])
+			word = word[cut:]
+		}
+		current = word
+	}
+	if len(current) > 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+func splitRunes(line []rune, sep rune) [][]rune {
+	var parts [][]rune
+	start := 0
+	for i, r := range line {
+		if r == sep {
+			parts = append(parts, line[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, line[start:])
+}
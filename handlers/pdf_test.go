@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPDFShowsSmartPunctuationAndChinese(t *testing.T) {
+	doc := newPDFDocument()
+	doc.Text("“Ship it” – Alice’s call… €5", 11, false, 0)
+	doc.Text("会议纪要：发布推迟到周五", 11, true, 0)
+	if doc.Unsupported {
+		t.Fatal("smart punctuation or Chinese reported as unsupported")
+	}
+
+	page := doc.pages[0].String()
+	if !strings.Contains(page, `(\223Ship it\224 \226 Alice\222s call\205 \2005)`) {
+		t.Errorf("punctuation not in WinAnsi:\n%s", page)
+	}
+	if !strings.Contains(page, "/F3 11.0 Tf <4F1A8BAE7EAA8981FF1A53D15E0363A88FDF523054684E94> Tj") {
+		t.Errorf("Chinese not written with the CJK font:\n%s", page)
+	}
+	if out := doc.Bytes(); !bytes.Contains(out, []byte("/BaseFont /STSong-Light /Encoding /UniGB-UCS2-H")) {
+		t.Error("CJK font missing from the document")
+	}
+}
+
+func TestPDFFlagsCharactersNoFontHas(t *testing.T) {
+	doc := newPDFDocument()
+	doc.Text("Shipped 🎉", 11, false, 0)
+	if !doc.Unsupported {
+		t.Error("emoji not reported as unsupported")
+	}
+}
+
+func TestPDFWrapsChineseWithoutSpaces(t *testing.T) {
+	line := []rune(strings.Repeat("会", 100))
+	lines := pdfWrap(line, 10, false, 200)
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines, want 5 lines of 20 characters", len(lines))
+	}
+	for _, wrapped := range lines {
+		if pdfTextWidth(wrapped, 10, false) > 200 {
+			t.Errorf("line %q is wider than the page", string(wrapped))
+		}
+	}
+}
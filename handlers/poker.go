@@ -0,0 +1,598 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 问号表示无法估算，不参与统计
+const pokerUnsure = "?"
+
+var pokerDecks = map[string][]string{
+	models.PokerDeckFibonacci: {"0", "1", "2", "3", "5", "8", "13", "21", "34", "55", "89", pokerUnsure},
+	models.PokerDeckTShirt:    {"XS", "S", "M", "L", "XL", "XXL", pokerUnsure},
+}
+
+const (
+	maxPokerCards     = 20
+	maxPokerCardLen   = 10
+	maxPokerItemTitle = 200
+)
+
+// preparePokerBoard sets up an empty planning poker board for a new session.
+// The deck is fibonacci, tshirt or custom with the cards of the request.
+func preparePokerBoard(session *models.Session) string {
+	board := models.PokerBoard{Deck: models.PokerDeckFibonacci}
+	if session.Poker != nil && session.Poker.Deck != "" {
+		board.Deck = session.Poker.Deck
+	}
+
+	if board.Deck == models.PokerDeckCustom {
+		seen := make(map[string]bool)
+		for _, card := range session.Poker.Cards {
+			card = strings.TrimSpace(card)
+			if card == "" || utf8.RuneCountInString(card) > maxPokerCardLen {
+				return "Cards need between 1 and 10 characters"
+			}
+			if seen[card] {
+				return "Cards must be unique"
+			}
+			seen[card] = true
+			board.Cards = append(board.Cards, card)
+		}
+		if len(board.Cards) < 2 || len(board.Cards) > maxPokerCards {
+			return "A custom deck needs between 2 and 20 cards"
+		}
+	} else {
+		cards, ok := pokerDecks[board.Deck]
+		if !ok {
+			return "Deck must be fibonacci, tshirt or custom"
+		}
+		board.Cards = cards
+	}
+	session.Poker = newPokerBoard(board)
+	return ""
+}
+
+// newPokerBoard returns a board with the deck of board and no items
+func newPokerBoard(board models.PokerBoard) *models.PokerBoard {
+	return &models.PokerBoard{
+		Deck:  board.Deck,
+		Cards: board.Cards,
+		Items: []models.PokerItem{},
+	}
+}
+
+// pokerStats summarizes a round of votes. The median and the outliers are
+// taken over the positions of the cards in the deck, so they work for
+// T-shirt sizes as well as numbers; the mean only for numeric decks.
+type pokerStats struct {
+	Votes     int      `json:"votes"`
+	Min       string   `json:"min,omitempty"`
+	Max       string   `json:"max,omitempty"`
+	Median    string   `json:"median,omitempty"`
+	Mean      *float64 `json:"mean,omitempty"`
+	Mode      string   `json:"mode,omitempty"`
+	Consensus bool     `json:"consensus"`
+	Outliers  []string `json:"outliers"` // voters more than one card away from the median
+	Unsure    int      `json:"unsure"`
+}
+
+func computePokerStats(cards []string, votes []models.PokerVote) pokerStats {
+	stats := pokerStats{Votes: len(votes), Outliers: []string{}}
+	position := make(map[string]int)
+	for i, card := range cards {
+		position[card] = i
+	}
+
+	var scored []models.PokerVote
+	for _, vote := range votes {
+		if _, ok := position[vote.Card]; ok && vote.Card != pokerUnsure {
+			scored = append(scored, vote)
+		} else {
+			stats.Unsure++
+		}
+	}
+	if len(scored) == 0 {
+		return stats
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool {
+		return position[scored[i].Card] < position[scored[j].Card]
+	})
+	stats.Min = scored[0].Card
+	stats.Max = scored[len(scored)-1].Card
+	median := scored[(len(scored)-1)/2].Card
+	stats.Median = median
+	stats.Consensus = stats.Min == stats.Max && stats.Unsure == 0
+
+	counts := make(map[string]int)
+	for _, vote := range scored {
+		counts[vote.Card]++
+		if counts[vote.Card] > counts[stats.Mode] {
+			stats.Mode = vote.Card
+		}
+	}
+
+	sum := 0.0
+	numeric := true
+	for _, vote := range scored {
+		n, err := strconv.ParseFloat(vote.Card, 64)
+		if err != nil {
+			numeric = false
+			break
+		}
+		sum += n
+	}
+	if numeric {
+		mean := sum / float64(len(scored))
+		stats.Mean = &mean
+	}
+
+	for _, vote := range scored {
+		if d := position[vote.Card] - position[median]; d > 1 || d < -1 {
+			stats.Outliers = append(stats.Outliers, vote.Voter)
+		}
+	}
+	return stats
+}
+
+// pokerItemView is an item as one participant sees it. While the item is
+// being voted on only the names of those who voted are shown.
+func pokerItemView(board *models.PokerBoard, item models.PokerItem, viewer string) map[string]interface{} {
+	voted := make([]string, 0, len(item.Votes))
+	myVote := ""
+	for _, vote := range item.Votes {
+		voted = append(voted, vote.Voter)
+		if vote.Voter == viewer {
+			myVote = vote.Card
+		}
+	}
+	rounds := make([]map[string]interface{}, 0, len(item.Rounds))
+	for _, round := range item.Rounds {
+		rounds = append(rounds, map[string]interface{}{
+			"round":       round.Round,
+			"votes":       round.Votes,
+			"stats":       computePokerStats(board.Cards, round.Votes),
+			"revealed_at": round.RevealedAt,
+		})
+	}
+
+	view := map[string]interface{}{
+		"_id":          item.ID,
+		"title":        item.Title,
+		"notes":        item.Notes,
+		"status":       item.Status,
+		"round":        item.Round,
+		"voted":        voted,
+		"my_vote":      myVote,
+		"rounds":       rounds,
+		"estimate":     item.Estimate,
+		"estimated_at": item.EstimatedAt,
+		"created_by":   item.CreatedBy,
+		"created_at":   item.CreatedAt,
+	}
+	if item.Status != models.PokerVoting {
+		view["votes"] = item.Votes
+		view["stats"] = computePokerStats(board.Cards, item.Votes)
+	}
+	return view
+}
+
+func pokerView(board *models.PokerBoard, viewer string) map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(board.Items))
+	for _, item := range board.Items {
+		items = append(items, pokerItemView(board, item, viewer))
+	}
+	return map[string]interface{}{
+		"deck":         board.Deck,
+		"cards":        board.Cards,
+		"current_item": board.CurrentItem,
+		"items":        items,
+	}
+}
+
+// redactPoker hides the estimates that are not revealed yet, for session
+// responses and exports
+func redactPoker(board *models.PokerBoard) {
+	if board == nil {
+		return
+	}
+	for i := range board.Items {
+		if board.Items[i].Status != models.PokerVoting {
+			continue
+		}
+		for j := range board.Items[i].Votes {
+			board.Items[i].Votes[j].Card = ""
+		}
+	}
+}
+
+func broadcastPokerBoard(session models.Session) {
+	if session.Poker == nil {
+		return
+	}
+	board := session.Poker
+	BroadcastEach(session.ID.Hex(), func(c *MeetingClient) interface{} {
+		return map[string]interface{}{
+			"type":  "pokerBoard",
+			"board": pokerView(board, c.username),
+		}
+	})
+}
+
+// broadcastPoker sends every client its view of the board after a change
+func broadcastPoker(client *MeetingClient) {
+	if session, err := loadClientSession(client); err == nil {
+		broadcastPokerBoard(session)
+	}
+}
+
+// loadPokerItem loads the client's session and the item of a poker action,
+// the current item when itemId is empty. Only the facilitator may run
+// actions with facilitatorOnly set.
+func loadPokerItem(client *MeetingClient, msg map[string]interface{}, action string, facilitatorOnly bool) (models.Session, models.PokerItem, bool) {
+	var item models.PokerItem
+	session, err := loadClientSession(client)
+	if err != nil {
+		sendError(client, action, "Session not found")
+		return session, item, false
+	}
+	if session.Poker == nil {
+		sendError(client, action, "Session is not a planning poker session")
+		return session, item, false
+	}
+	if facilitatorOnly && !isFacilitator(session, client.userID) {
+		sendError(client, action, "Only the facilitator can do that")
+		return session, item, false
+	}
+
+	itemID, _ := msg["itemId"].(string)
+	if itemID == "" {
+		itemID = session.Poker.CurrentItem.Hex()
+	}
+	for _, candidate := range session.Poker.Items {
+		if candidate.ID.Hex() == itemID {
+			return session, candidate, true
+		}
+	}
+	sendError(client, action, "Item not found")
+	return session, item, false
+}
+
+// updatePokerItem runs an update on an item of a poker session. status
+// guards against concurrent changes when it is not empty.
+func updatePokerItem(session models.Session, item models.PokerItem, status string, update bson.M) (bool, error) {
+	itemFilter := bson.M{"i._id": item.ID}
+	if status != "" {
+		itemFilter["i.status"] = status
+	}
+	result, err := sessionCollection.UpdateOne(context.Background(), bson.M{"_id": session.ID}, update,
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{itemFilter}}))
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func inDeck(board *models.PokerBoard, card string) bool {
+	for _, c := range board.Cards {
+		if c == card {
+			return true
+		}
+	}
+	return false
+}
+
+// handlePokerItem adds an item to estimate and makes it the current one
+func handlePokerItem(client *MeetingClient, msg map[string]interface{}) {
+	title, _ := msg["title"].(string)
+	notes, _ := msg["notes"].(string)
+	title = strings.TrimSpace(title)
+	if title == "" || utf8.RuneCountInString(title) > maxPokerItemTitle {
+		sendError(client, "pokerItem", "Items need a title of up to 200 characters")
+		return
+	}
+	session, err := loadClientSession(client)
+	if err != nil {
+		sendError(client, "pokerItem", "Session not found")
+		return
+	}
+	if session.Poker == nil {
+		sendError(client, "pokerItem", "Session is not a planning poker session")
+		return
+	}
+	if !isFacilitator(session, client.userID) {
+		sendError(client, "pokerItem", "Only the facilitator can add items")
+		return
+	}
+
+	item := models.PokerItem{
+		ID:        primitive.NewObjectID(),
+		Title:     title,
+		Notes:     strings.TrimSpace(notes),
+		Status:    models.PokerVoting,
+		Round:     1,
+		Votes:     []models.PokerVote{},
+		Rounds:    []models.PokerRound{},
+		CreatedBy: client.username,
+		CreatedAt: time.Now(),
+	}
+	_, err = sessionCollection.UpdateOne(context.Background(), bson.M{"_id": session.ID}, bson.M{
+		"$push": bson.M{"poker.items": item},
+		"$set":  bson.M{"poker.current_item": item.ID},
+	})
+	if err != nil {
+		client.log().Error("Failed to add poker item", "error", err)
+		sendError(client, "pokerItem", "Failed to add item")
+		return
+	}
+	broadcastPoker(client)
+}
+
+// handlePokerCurrent switches the item everyone is looking at
+func handlePokerCurrent(client *MeetingClient, msg map[string]interface{}) {
+	session, item, ok := loadPokerItem(client, msg, "pokerCurrent", true)
+	if !ok {
+		return
+	}
+	_, err := sessionCollection.UpdateOne(context.Background(), bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"poker.current_item": item.ID}})
+	if err != nil {
+		client.log().Error("Failed to switch poker item", "error", err)
+		sendError(client, "pokerCurrent", "Failed to switch item")
+		return
+	}
+	broadcastPoker(client)
+}
+
+// handleEstimate records or replaces the client's hidden estimate
+func handleEstimate(client *MeetingClient, msg map[string]interface{}) {
+	card, _ := msg["card"].(string)
+	session, item, ok := loadPokerItem(client, msg, "estimate", false)
+	if !ok {
+		return
+	}
+	if item.Status != models.PokerVoting {
+		sendError(client, "estimate", "Estimates are already revealed")
+		return
+	}
+	if !inDeck(session.Poker, card) {
+		sendError(client, "estimate", "Card is not in the deck")
+		return
+	}
+
+	recorded, err := castPokerVote(session, item, models.PokerVote{
+		Voter:  client.username,
+		Card:   card,
+		CastAt: time.Now(),
+	})
+	if err != nil {
+		client.log().Error("Failed to record estimate", "error", err)
+		sendError(client, "estimate", "Failed to record estimate")
+		return
+	}
+	if !recorded {
+		sendError(client, "estimate", "Estimates are already revealed")
+		return
+	}
+	broadcastPoker(client)
+}
+
+// castPokerVote replaces the voter's estimate for an item that is being
+// voted on, or adds it when the voter has none, like castPollVote. It
+// reports false when the estimates have been revealed.
+func castPokerVote(session models.Session, item models.PokerItem, vote models.PokerVote) (bool, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		result, err := sessionCollection.UpdateOne(context.Background(), bson.M{"_id": session.ID},
+			bson.M{"$set": bson.M{"poker.items.$[i].votes.$[v]": vote}},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{
+				bson.M{"i._id": item.ID, "i.status": models.PokerVoting},
+				bson.M{"v.voter": vote.Voter},
+			}}))
+		if err != nil || result.ModifiedCount > 0 {
+			return err == nil, err
+		}
+
+		result, err = sessionCollection.UpdateOne(context.Background(), bson.M{
+			"_id": session.ID,
+			"poker.items": bson.M{"$elemMatch": bson.M{
+				"_id":         item.ID,
+				"status":      models.PokerVoting,
+				"votes.voter": bson.M{"$ne": vote.Voter},
+			}},
+		}, bson.M{"$push": bson.M{"poker.items.$.votes": vote}})
+		if err != nil || result.ModifiedCount > 0 {
+			return err == nil, err
+		}
+	}
+	return false, nil
+}
+
+// handleRevealEstimates shows the votes of the current round with their
+// statistics
+func handleRevealEstimates(client *MeetingClient, msg map[string]interface{}) {
+	session, item, ok := loadPokerItem(client, msg, "revealEstimates", true)
+	if !ok {
+		return
+	}
+	if item.Status != models.PokerVoting {
+		sendError(client, "revealEstimates", "Estimates are already revealed")
+		return
+	}
+	if len(item.Votes) == 0 {
+		sendError(client, "revealEstimates", "Nobody has voted yet")
+		return
+	}
+
+	round := models.PokerRound{Round: item.Round, Votes: item.Votes, RevealedAt: time.Now()}
+	modified, err := updatePokerItem(session, item, models.PokerVoting, bson.M{
+		"$set":  bson.M{"poker.items.$[i].status": models.PokerRevealed},
+		"$push": bson.M{"poker.items.$[i].rounds": round},
+	})
+	if err != nil || !modified {
+		if err != nil {
+			client.log().Error("Failed to reveal estimates", "error", err)
+		}
+		sendError(client, "revealEstimates", "Failed to reveal estimates")
+		return
+	}
+	broadcastPoker(client)
+}
+
+// handleRevote starts a new round on an item. The revealed rounds are kept.
+func handleRevote(client *MeetingClient, msg map[string]interface{}) {
+	session, item, ok := loadPokerItem(client, msg, "revote", true)
+	if !ok {
+		return
+	}
+	if item.Status == models.PokerVoting {
+		sendError(client, "revote", "Reveal the estimates first")
+		return
+	}
+
+	_, err := updatePokerItem(session, item, item.Status, bson.M{
+		"$set": bson.M{
+			"poker.items.$[i].status": models.PokerVoting,
+			"poker.items.$[i].round":  item.Round + 1,
+			"poker.items.$[i].votes":  []models.PokerVote{},
+		},
+		"$unset": bson.M{"poker.items.$[i].estimate": "", "poker.items.$[i].estimated_at": ""},
+	})
+	if err != nil {
+		client.log().Error("Failed to start new poker round", "error", err)
+		sendError(client, "revote", "Failed to start a new round")
+		return
+	}
+	broadcastPoker(client)
+}
+
+// handleFinalEstimate stores the estimate the team agreed on
+func handleFinalEstimate(client *MeetingClient, msg map[string]interface{}) {
+	card, _ := msg["card"].(string)
+	session, item, ok := loadPokerItem(client, msg, "finalEstimate", true)
+	if !ok {
+		return
+	}
+	if item.Status == models.PokerVoting {
+		sendError(client, "finalEstimate", "Reveal the estimates first")
+		return
+	}
+	if !inDeck(session.Poker, card) || card == pokerUnsure {
+		sendError(client, "finalEstimate", "Card is not in the deck")
+		return
+	}
+
+	_, err := updatePokerItem(session, item, "", bson.M{"$set": bson.M{
+		"poker.items.$[i].status":       models.PokerEstimated,
+		"poker.items.$[i].estimate":     card,
+		"poker.items.$[i].estimated_at": time.Now(),
+	}})
+	if err != nil {
+		client.log().Error("Failed to store estimate", "error", err)
+		sendError(client, "finalEstimate", "Failed to store estimate")
+		return
+	}
+	broadcastPoker(client)
+}
+
+// GetPokerHandler returns the planning poker board as the current user sees
+// it
+func GetPokerHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if session.Poker == nil {
+		http.Error(w, "Session is not a planning poker session", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pokerView(session.Poker, user.Username))
+}
+
+// renderEstimatesCSV writes one row per item with its final estimate and the
+// statistics of the last revealed round
+func renderEstimatesCSV(board *models.PokerBoard) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"Item", "Notes", "Estimate", "Rounds", "Votes", "Min", "Median", "Max", "Mean", "Estimated At"})
+	for _, item := range board.Items {
+		row := []string{item.Title, item.Notes, item.Estimate, strconv.Itoa(len(item.Rounds)), "", "", "", "", "", ""}
+		if len(item.Rounds) > 0 {
+			last := item.Rounds[len(item.Rounds)-1]
+			votes := make([]string, 0, len(last.Votes))
+			for _, vote := range last.Votes {
+				votes = append(votes, vote.Voter+"="+vote.Card)
+			}
+			stats := computePokerStats(board.Cards, last.Votes)
+			row[4] = strings.Join(votes, "; ")
+			row[5], row[6], row[7] = stats.Min, stats.Median, stats.Max
+			if stats.Mean != nil {
+				row[8] = strconv.FormatFloat(*stats.Mean, 'f', 1, 64)
+			}
+		}
+		if item.EstimatedAt != nil {
+			row[9] = item.EstimatedAt.Format(time.RFC3339)
+		}
+		for i := range row {
+			row[i] = csvCell(row[i])
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// csvCell keeps spreadsheets from running a cell as a formula by prefixing
+// values that start like one with a quote
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// EstimatesCSVHandler exports the estimates of a planning poker session as
+// CSV
+func EstimatesCSVHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+	if session.Poker == nil {
+		http.Error(w, "Session is not a planning poker session", http.StatusNotFound)
+		return
+	}
+
+	body, err := renderEstimatesCSV(session.Poker)
+	if err != nil {
+		logFor(r.Context()).Error("Failed to render estimates", "error", err)
+		http.Error(w, "Failed to export estimates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName(session, "csv")))
+	w.Write(body)
+}
@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errNoVoterKey is returned for anonymous polls when SESSION_KEY is not set:
+// without the key anyone with the database could recompute the voter hashes
+// from the participants' GitHub IDs.
+var errNoVoterKey = errors.New("anonymous polls need SESSION_KEY to be set")
+
+// pollVoterKey identifies a voter. Anonymous polls store a hash keyed with
+// SESSION_KEY so a participant can change their vote without their name being
+// recorded.
+func pollVoterKey(poll models.Poll, client *MeetingClient) (string, error) {
+	if !poll.Anonymous {
+		return client.username, nil
+	}
+	key := os.Getenv("SESSION_KEY")
+	if key == "" {
+		return "", errNoVoterKey
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s:%d", poll.ID.Hex(), client.userID)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// tallyPoll counts the votes of a poll. Ranked polls use a Borda count: on a
+// ballot ranking k of n options the first choice gets n points, the second
+// n-1 and so on.
+func tallyPoll(poll models.Poll) []models.PollTally {
+	tally := make([]models.PollTally, len(poll.Options))
+	for i, option := range poll.Options {
+		tally[i].Option = option
+	}
+	n := len(poll.Options)
+	for _, vote := range poll.Votes {
+		for rank, choice := range vote.Choices {
+			if choice < 0 || choice >= n {
+				continue
+			}
+			if poll.Kind == models.PollRanked {
+				tally[choice].Points += n - rank
+				if rank == 0 {
+					tally[choice].Votes++
+				}
+			} else {
+				tally[choice].Votes++
+			}
+		}
+	}
+	return tally
+}
+
+// pollView is a poll as sent to clients, with the current tally and without
+// the voter keys of anonymous polls
+func pollView(poll models.Poll) map[string]interface{} {
+	tally := poll.Results
+	if poll.Status != models.PollClosed {
+		tally = tallyPoll(poll)
+	}
+	view := map[string]interface{}{
+		"_id":        poll.ID,
+		"question":   poll.Question,
+		"kind":       poll.Kind,
+		"options":    poll.Options,
+		"anonymous":  poll.Anonymous,
+		"status":     poll.Status,
+		"created_by": poll.CreatedBy,
+		"created_at": poll.CreatedAt,
+		"closed_at":  poll.ClosedAt,
+		"tally":      tally,
+		"voters":     len(poll.Votes),
+	}
+	if !poll.Anonymous {
+		view["votes"] = poll.Votes
+	}
+	return view
+}
+
+// validateChoices checks a ballot against the kind of poll
+func validateChoices(poll models.Poll, choices []int) string {
+	if len(choices) == 0 {
+		return "Choose at least one option"
+	}
+	if poll.Kind == models.PollSingle && len(choices) != 1 {
+		return "Choose exactly one option"
+	}
+	seen := make(map[int]bool)
+	for _, choice := range choices {
+		if choice < 0 || choice >= len(poll.Options) {
+			return "Invalid option"
+		}
+		if seen[choice] {
+			return "Options can only be chosen once"
+		}
+		seen[choice] = true
+	}
+	return ""
+}
+
+func findPoll(session models.Session, pollID string) (models.Poll, bool) {
+	for _, poll := range session.Polls {
+		if poll.ID.Hex() == pollID {
+			return poll, true
+		}
+	}
+	return models.Poll{}, false
+}
+
+func loadClientSession(client *MeetingClient) (models.Session, error) {
+	var session models.Session
+	objectID, err := primitive.ObjectIDFromHex(client.sessionID)
+	if err != nil {
+		return session, err
+	}
+	err = sessionCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": objectID})).Decode(&session)
+	return session, err
+}
+
+// sendError reports a failed WebSocket action to the client that sent it
+func sendError(client *MeetingClient, action, message string) {
+	if err := client.send(map[string]interface{}{
+		"type":    "error",
+		"action":  action,
+		"message": message,
+	}); err != nil {
+		client.log().Warn("Failed to send error message", "action", action, "error", err)
+	}
+}
+
+// decodeMessage converts a WebSocket message into a typed struct
+func decodeMessage(msg map[string]interface{}, v interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// handleOpenPoll opens a poll. Only the facilitator may open polls.
+func handleOpenPoll(client *MeetingClient, msg map[string]interface{}) {
+	var input struct {
+		Question  string   `json:"question"`
+		Kind      string   `json:"kind"`
+		Options   []string `json:"options"`
+		Anonymous bool     `json:"anonymous"`
+	}
+	if err := decodeMessage(msg, &input); err != nil {
+		sendError(client, "openPoll", "Invalid poll")
+		return
+	}
+
+	session, err := loadClientSession(client)
+	if err != nil {
+		sendError(client, "openPoll", "Session not found")
+		return
+	}
+	if !isFacilitator(session, client.userID) {
+		sendError(client, "openPoll", "Only the facilitator can open polls")
+		return
+	}
+
+	input.Question = strings.TrimSpace(input.Question)
+	if input.Question == "" {
+		sendError(client, "openPoll", "Question is required")
+		return
+	}
+	if input.Kind == "" {
+		input.Kind = models.PollSingle
+	}
+	if input.Kind != models.PollSingle && input.Kind != models.PollMultiple && input.Kind != models.PollRanked {
+		sendError(client, "openPoll", "Kind must be single, multiple or ranked")
+		return
+	}
+	choices := make([]string, 0, len(input.Options))
+	for _, option := range input.Options {
+		if option = strings.TrimSpace(option); option != "" {
+			choices = append(choices, option)
+		}
+	}
+	if len(choices) < 2 {
+		sendError(client, "openPoll", "A poll needs at least two options")
+		return
+	}
+	if input.Anonymous && os.Getenv("SESSION_KEY") == "" {
+		sendError(client, "openPoll", "Anonymous polls are not available: SESSION_KEY is not set")
+		return
+	}
+
+	poll := models.Poll{
+		ID:        primitive.NewObjectID(),
+		Question:  input.Question,
+		Kind:      input.Kind,
+		Options:   choices,
+		Anonymous: input.Anonymous,
+		Status:    models.PollOpen,
+		CreatedBy: client.username,
+		CreatedAt: time.Now(),
+		Votes:     []models.PollVote{},
+	}
+	_, err = sessionCollection.UpdateOne(context.Background(), bson.M{"_id": session.ID},
+		bson.M{"$push": bson.M{"polls": poll}})
+	if err != nil {
+		client.log().Error("Failed to open poll", "error", err)
+		sendError(client, "openPoll", "Failed to open poll")
+		return
+	}
+
+	Broadcast(client.sessionID, map[string]interface{}{
+		"type": "pollOpened",
+		"poll": pollView(poll),
+	})
+}
+
+// castPollVote replaces the voter's ballot in an open poll, or adds it when
+// the voter has none. Each step is a single update, so concurrent votes never
+// leave the voter with two ballots or none. It reports false when the poll
+// is closed.
+func castPollVote(sessionID, pollID primitive.ObjectID, vote models.PollVote) (bool, error) {
+	// 第二次尝试处理同一投票人并发的第一票
+	for attempt := 0; attempt < 2; attempt++ {
+		result, err := sessionCollection.UpdateOne(context.Background(), bson.M{"_id": sessionID},
+			bson.M{"$set": bson.M{"polls.$[p].votes.$[v]": vote}},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{
+				bson.M{"p._id": pollID, "p.status": models.PollOpen},
+				bson.M{"v.voter": vote.Voter},
+			}}))
+		if err != nil || result.ModifiedCount > 0 {
+			return err == nil, err
+		}
+
+		result, err = sessionCollection.UpdateOne(context.Background(), bson.M{
+			"_id": sessionID,
+			"polls": bson.M{"$elemMatch": bson.M{
+				"_id":         pollID,
+				"status":      models.PollOpen,
+				"votes.voter": bson.M{"$ne": vote.Voter},
+			}},
+		}, bson.M{"$push": bson.M{"polls.$.votes": vote}})
+		if err != nil || result.ModifiedCount > 0 {
+			return err == nil, err
+		}
+	}
+	return false, nil
+}
+
+// handleVote records or replaces the client's ballot and broadcasts the new
+// tally
+func handleVote(client *MeetingClient, msg map[string]interface{}) {
+	var input struct {
+		PollID  string `json:"pollId"`
+		Choices []int  `json:"choices"`
+	}
+	if err := decodeMessage(msg, &input); err != nil {
+		sendError(client, "vote", "Invalid vote")
+		return
+	}
+
+	session, err := loadClientSession(client)
+	if err != nil {
+		sendError(client, "vote", "Session not found")
+		return
+	}
+	poll, ok := findPoll(session, input.PollID)
+	if !ok {
+		sendError(client, "vote", "Poll not found")
+		return
+	}
+	if poll.Status != models.PollOpen {
+		sendError(client, "vote", "Poll is closed")
+		return
+	}
+	if problem := validateChoices(poll, input.Choices); problem != "" {
+		sendError(client, "vote", problem)
+		return
+	}
+
+	voter, err := pollVoterKey(poll, client)
+	if err != nil {
+		client.log().Error("Failed to record vote", "poll_id", poll.ID.Hex(), "error", err)
+		sendError(client, "vote", "Anonymous polls are not available: SESSION_KEY is not set")
+		return
+	}
+	recorded, err := castPollVote(session.ID, poll.ID, models.PollVote{
+		Voter:   voter,
+		Choices: input.Choices,
+		CastAt:  time.Now(),
+	})
+	if err != nil {
+		client.log().Error("Failed to record vote", "error", err)
+		sendError(client, "vote", "Failed to record vote")
+		return
+	}
+	if !recorded {
+		sendError(client, "vote", "Poll is closed")
+		return
+	}
+
+	if session, err = loadClientSession(client); err != nil {
+		return
+	}
+	if poll, ok = findPoll(session, input.PollID); !ok {
+		return
+	}
+	view := pollView(poll)
+	Broadcast(client.sessionID, map[string]interface{}{
+		"type":   "pollTally",
+		"pollId": poll.ID.Hex(),
+		"tally":  view["tally"],
+		"voters": view["voters"],
+		"votes":  view["votes"],
+	})
+}
+
+// handleClosePoll closes a poll and freezes its results
+func handleClosePoll(client *MeetingClient, msg map[string]interface{}) {
+	pollID, _ := msg["pollId"].(string)
+
+	session, err := loadClientSession(client)
+	if err != nil {
+		sendError(client, "closePoll", "Session not found")
+		return
+	}
+	if !isFacilitator(session, client.userID) {
+		sendError(client, "closePoll", "Only the facilitator can close polls")
+		return
+	}
+	poll, ok := findPoll(session, pollID)
+	if !ok {
+		sendError(client, "closePoll", "Poll not found")
+		return
+	}
+	if poll.Status == models.PollClosed {
+		sendError(client, "closePoll", "Poll is already closed")
+		return
+	}
+
+	now := time.Now()
+	poll.Status = models.PollClosed
+	poll.ClosedAt = &now
+	poll.Results = tallyPoll(poll)
+
+	_, err = sessionCollection.UpdateOne(context.Background(), bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{
+			"polls.$[p].status":    poll.Status,
+			"polls.$[p].closed_at": now,
+			"polls.$[p].results":   poll.Results,
+		}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"p._id": poll.ID}}}))
+	if err != nil {
+		client.log().Error("Failed to close poll", "error", err)
+		sendError(client, "closePoll", "Failed to close poll")
+		return
+	}
+
+	Broadcast(client.sessionID, map[string]interface{}{
+		"type": "pollClosed",
+		"poll": pollView(poll),
+	})
+}
+
+// GetPollsHandler returns the polls of a session with their results
+func GetPollsHandler(w http.ResponseWriter, r *http.Request) {
+	objectID, err := primitive.ObjectIDFromHex(mux.Vars(r)["sessionId"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	var session models.Session
+	if err := sessionCollection.FindOne(r.Context(), notDeleted(bson.M{"_id": objectID})).Decode(&session); err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	polls := make([]map[string]interface{}, 0, len(session.Polls))
+	for _, poll := range session.Polls {
+		polls = append(polls, pollView(poll))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(polls)
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"testing"
+
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAnonymousVoterKeyNeedsSessionKey(t *testing.T) {
+	poll := models.Poll{ID: primitive.NewObjectID(), Anonymous: true}
+	client := &MeetingClient{userID: 42, username: "alice"}
+
+	t.Setenv("SESSION_KEY", "")
+	if _, err := pollVoterKey(poll, client); err != errNoVoterKey {
+		t.Errorf("without SESSION_KEY: err = %v, want errNoVoterKey", err)
+	}
+
+	t.Setenv("SESSION_KEY", "test-session-key")
+	key, err := pollVoterKey(poll, client)
+	if err != nil || key == "" || key == "alice" {
+		t.Errorf("voter key = %q, %v", key, err)
+	}
+	other := models.Poll{ID: primitive.NewObjectID(), Anonymous: true}
+	if otherKey, _ := pollVoterKey(other, client); otherKey == key {
+		t.Error("voter has the same key in two polls")
+	}
+
+	poll.Anonymous = false
+	if key, err := pollVoterKey(poll, client); err != nil || key != "alice" {
+		t.Errorf("named poll: voter key = %q, %v", key, err)
+	}
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	presenceOnline       = "online"
+	presenceAway         = "away"
+	presenceReconnecting = "reconnecting"
+	presenceLeft         = "left"
+)
+
+// 断线后保留参与者的默认时长
+const defaultPresenceGrace = 30 * time.Second
+
+// presence is what the meeting knows about one participant across all of
+// their tabs
+type presence struct {
+	userID    int
+	username  string
+	avatarURL string
+	joinedAt  time.Time
+	lastSeen  time.Time
+	state     string
+	tabs      []*MeetingClient
+	grace     *time.Timer
+}
+
+// meetingRoom holds the open connections and the participants of a session
+type meetingRoom struct {
+	sync.Mutex
+	clients  []*MeetingClient
+	presence map[int]*presence
+}
+
+var meetingRooms = struct {
+	sync.Mutex
+	rooms map[string]*meetingRoom
+}{rooms: make(map[string]*meetingRoom)}
+
+// presenceGrace is how long a participant whose last tab disconnected stays
+// in the meeting as reconnecting
+func presenceGrace() time.Duration {
+	if value := os.Getenv("PRESENCE_GRACE_SECONDS"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		appLog.Warn("Invalid PRESENCE_GRACE_SECONDS, using default", "value", value)
+	}
+	return defaultPresenceGrace
+}
+
+func findRoom(sessionID string) (*meetingRoom, bool) {
+	meetingRooms.Lock()
+	defer meetingRooms.Unlock()
+	room, ok := meetingRooms.rooms[sessionID]
+	return room, ok
+}
+
+// roomClients returns a snapshot of the connections of a session
+func roomClients(sessionID string) []*MeetingClient {
+	room, ok := findRoom(sessionID)
+	if !ok {
+		return nil
+	}
+	room.Lock()
+	defer room.Unlock()
+	return append([]*MeetingClient{}, room.clients...)
+}
+
+// tabState is online if any of the tabs is in the foreground
+func (p *presence) tabState() string {
+	for _, tab := range p.tabs {
+		if !tab.away {
+			return presenceOnline
+		}
+	}
+	return presenceAway
+}
+
+// joinRoom registers a new connection. A participant may have several tabs
+// open; reconnecting within the grace window keeps their place.
+func joinRoom(client *MeetingClient) {
+	now := time.Now()
+
+	// 先锁全局表再锁房间，避免和 cleanupRoom 之间出现空房间被删除后再加入
+	meetingRooms.Lock()
+	room, ok := meetingRooms.rooms[client.room()]
+	if !ok {
+		room = &meetingRoom{presence: make(map[int]*presence)}
+		meetingRooms.rooms[client.room()] = room
+	}
+	room.Lock()
+	meetingRooms.Unlock()
+	defer room.Unlock()
+
+	room.clients = append(room.clients, client)
+	p, ok := room.presence[client.userID]
+	if !ok {
+		p = &presence{userID: client.userID, joinedAt: now}
+		room.presence[client.userID] = p
+	}
+	if p.grace != nil {
+		p.grace.Stop()
+		p.grace = nil
+	}
+	p.username = client.username
+	p.avatarURL = client.avatarURL
+	p.lastSeen = now
+	p.tabs = append(p.tabs, client)
+	p.state = p.tabState()
+}
+
+// leaveRoom removes a connection. When it was the participant's last tab they
+// are marked reconnecting and only leave once the grace window has passed.
+func leaveRoom(client *MeetingClient) {
+	room, ok := findRoom(client.room())
+	if !ok {
+		return
+	}
+
+	room.Lock()
+	for i, c := range room.clients {
+		if c == client {
+			room.clients = append(room.clients[:i], room.clients[i+1:]...)
+			break
+		}
+	}
+	p, ok := room.presence[client.userID]
+	if !ok {
+		room.Unlock()
+		return
+	}
+	for i, tab := range p.tabs {
+		if tab == client {
+			p.tabs = append(p.tabs[:i], p.tabs[i+1:]...)
+			break
+		}
+	}
+	p.lastSeen = time.Now()
+	if len(p.tabs) > 0 {
+		p.state = p.tabState()
+		room.Unlock()
+		broadcastParticipantsList(client.room())
+		return
+	}
+
+	grace := presenceGrace()
+	if grace == 0 {
+		room.Unlock()
+		expirePresence(client.room(), p)
+		return
+	}
+	p.state = presenceReconnecting
+	p.grace = time.AfterFunc(grace, func() { expirePresence(client.room(), p) })
+	room.Unlock()
+	broadcastParticipantsList(client.room())
+}
+
+// expirePresence removes a participant who did not come back and tells the
+// others they left
+func expirePresence(sessionID string, p *presence) {
+	room, ok := findRoom(sessionID)
+	if !ok {
+		return
+	}
+
+	room.Lock()
+	// 宽限期内重新连接过的参与者不处理
+	if room.presence[p.userID] != p || len(p.tabs) > 0 {
+		room.Unlock()
+		return
+	}
+	p.state = presenceLeft
+	p.grace = nil
+	delete(room.presence, p.userID)
+	room.Unlock()
+
+	leaveFloor(sessionID, p.userID)
+	Broadcast(sessionID, map[string]interface{}{
+		"type":     "participantLeft",
+		"id":       p.userID,
+		"username": p.username,
+		"state":    p.state,
+		"lastSeen": p.lastSeen,
+	})
+	broadcastParticipantsList(sessionID)
+	cleanupRoom(sessionID)
+}
+
+// cleanupRoom forgets a session once nobody is connected or about to
+// reconnect
+func cleanupRoom(sessionID string) {
+	meetingRooms.Lock()
+	defer meetingRooms.Unlock()
+	room, ok := meetingRooms.rooms[sessionID]
+	if !ok {
+		return
+	}
+	room.Lock()
+	defer room.Unlock()
+	if len(room.clients) > 0 || len(room.presence) > 0 {
+		return
+	}
+	delete(meetingRooms.rooms, sessionID)
+	dropEventLog(sessionID)
+}
+
+// touchPresence records activity on a connection
+func touchPresence(client *MeetingClient) {
+	room, ok := findRoom(client.room())
+	if !ok {
+		return
+	}
+	room.Lock()
+	defer room.Unlock()
+	if p, ok := room.presence[client.userID]; ok {
+		p.lastSeen = time.Now()
+	}
+}
+
+// handlePresence lets a tab report that it went to the background or came
+// back. A participant is away only when all of their tabs are.
+func handlePresence(client *MeetingClient, msg map[string]interface{}) {
+	state, _ := msg["state"].(string)
+	if state != presenceOnline && state != presenceAway {
+		sendError(client, "presence", "State must be online or away")
+		return
+	}
+
+	room, ok := findRoom(client.room())
+	if !ok {
+		return
+	}
+	room.Lock()
+	p, ok := room.presence[client.userID]
+	if !ok {
+		room.Unlock()
+		return
+	}
+	client.away = state == presenceAway
+	previous := p.state
+	p.state = p.tabState()
+	p.lastSeen = time.Now()
+	changed := p.state != previous
+	room.Unlock()
+
+	if changed {
+		broadcastParticipantsList(client.room())
+	}
+}
+
+// participantsSnapshot lists the participants of a session in the order they
+// joined
+func participantsSnapshot(sessionID string) []map[string]interface{} {
+	participants := []map[string]interface{}{}
+
+	room, ok := findRoom(sessionID)
+	if !ok {
+		return participants
+	}
+
+	room.Lock()
+	for _, p := range room.presence {
+		participants = append(participants, map[string]interface{}{
+			"id":        p.userID,
+			"username":  p.username,
+			"avatarUrl": p.avatarURL,
+			"joinedAt":  p.joinedAt,
+			"lastSeen":  p.lastSeen,
+			"state":     p.state,
+			"tabs":      len(p.tabs),
+		})
+	}
+	room.Unlock()
+
+	// 按加入时间排序
+	sort.Slice(participants, func(i, j int) bool {
+		iTime := participants[i]["joinedAt"].(time.Time)
+		jTime := participants[j]["joinedAt"].(time.Time)
+		return iTime.Before(jTime)
+	})
+	return participants
+}
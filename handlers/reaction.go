@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var reactionCollection *mongo.Collection
+
+// 同一会话内在这个窗口中的表情变化合并成一条广播
+const reactionBatchWindow = 500 * time.Millisecond
+
+func InitReactionCollection(client *mongo.Client) {
+	reactionCollection = client.Database("your-db-name").Collection("reactions")
+
+	// 每个用户对同一目标的同一表情只能有一条记录
+	_, err := reactionCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "target_id", Value: 1}, {Key: "emoji", Value: 1}, {Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		dbLog.Error("Failed to create indexes", "collection", "reactions", "error", err)
+	}
+	backfillSummaryIDs(context.Background())
+}
+
+// backfillSummaryIDs gives an ID to the summaries submitted before summaries
+// had one, so they can get reactions too
+func backfillSummaryIDs(ctx context.Context) {
+	cursor, err := sessionCollection.Find(ctx, bson.M{"summaries": bson.M{"$elemMatch": bson.M{"_id": bson.M{"$exists": false}}}})
+	if err != nil {
+		dbLog.Error("Failed to fetch sessions with summaries without ID", "error", err)
+		return
+	}
+	var sessions []models.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		dbLog.Error("Failed to fetch sessions with summaries without ID", "error", err)
+		return
+	}
+	for _, session := range sessions {
+		for i, summary := range session.Summaries {
+			if !summary.ID.IsZero() {
+				continue
+			}
+			// 只给仍然没有 ID 的位置赋值，重复启动不会覆盖已有的 ID
+			field := fmt.Sprintf("summaries.%d._id", i)
+			_, err := sessionCollection.UpdateOne(ctx, bson.M{"_id": session.ID, field: bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{field: primitive.NewObjectID()}})
+			if err != nil {
+				dbLog.Error("Failed to set summary ID", "session_id", session.ID.Hex(), "error", err)
+			}
+		}
+	}
+}
+
+var shortcodePattern = regexp.MustCompile(`^:[a-z0-9_+-]{1,30}:$`)
+
+// validEmoji accepts a short emoji sequence (including ZWJ sequences and
+// skin tone modifiers) or a :shortcode:
+func validEmoji(emoji string) bool {
+	if shortcodePattern.MatchString(emoji) {
+		return true
+	}
+	if emoji == "" || len(emoji) > 32 || !utf8.ValidString(emoji) {
+		return false
+	}
+	for _, r := range emoji {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsPunct(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// reactionCounts aggregates the reactions matching filter per target
+func reactionCounts(ctx context.Context, filter bson.M) (map[primitive.ObjectID][]models.ReactionCount, error) {
+	cursor, err := reactionCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.M{"created_at": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       bson.M{"target_id": "$target_id", "emoji": "$emoji"},
+			"count":     bson.M{"$sum": 1},
+			"usernames": bson.M{"$push": "$username"},
+			"first":     bson.M{"$min": "$created_at"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		ID struct {
+			TargetID primitive.ObjectID `bson:"target_id"`
+			Emoji    string             `bson:"emoji"`
+		} `bson:"_id"`
+		Count     int       `bson:"count"`
+		Usernames []string  `bson:"usernames"`
+		First     time.Time `bson:"first"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	// 按最早出现的顺序排列，避免计数变化时表情来回跳动
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].First.Before(rows[j].First) })
+	counts := make(map[primitive.ObjectID][]models.ReactionCount)
+	for _, row := range rows {
+		counts[row.ID.TargetID] = append(counts[row.ID.TargetID], models.ReactionCount{
+			Emoji:     row.ID.Emoji,
+			Count:     row.Count,
+			Usernames: row.Usernames,
+		})
+	}
+	return counts, nil
+}
+
+// attachReactions fills in the reaction counts of the summaries and comments
+// of the given sessions
+func attachReactions(ctx context.Context, sessions []models.Session) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	counts, err := reactionCounts(ctx, bson.M{"session_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+
+	for i := range sessions {
+		for j := range sessions[i].Summaries {
+			summary := &sessions[i].Summaries[j]
+			if !summary.ID.IsZero() {
+				summary.Reactions = counts[summary.ID]
+			}
+			for k := range summary.Comments {
+				summary.Comments[k].Reactions = counts[summary.Comments[k].ID]
+			}
+		}
+	}
+	return nil
+}
+
+// hasReactionTarget reports whether the summary or comment exists in the session
+func hasReactionTarget(session models.Session, targetType string, targetID primitive.ObjectID) bool {
+	for _, summary := range session.Summaries {
+		if targetType == models.ReactionOnSummary && summary.ID == targetID {
+			return true
+		}
+		if targetType == models.ReactionOnComment {
+			for _, comment := range summary.Comments {
+				if comment.ID == targetID {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+type reactionEvent struct {
+	added      bool
+	TargetType string `json:"targetType"`
+	TargetID   string `json:"targetId"`
+	Emoji      string `json:"emoji"`
+	Username   string `json:"username"`
+	Count      int    `json:"count"`
+}
+
+var reactionBatches = struct {
+	sync.Mutex
+	pending map[string][]reactionEvent
+}{pending: make(map[string][]reactionEvent)}
+
+// queueReactionEvent schedules a reaction change for the next batched
+// broadcast to the session
+func queueReactionEvent(sessionID string, event reactionEvent) {
+	reactionBatches.Lock()
+	defer reactionBatches.Unlock()
+	if _, ok := reactionBatches.pending[sessionID]; !ok {
+		time.AfterFunc(reactionBatchWindow, func() { flushReactionEvents(sessionID) })
+	}
+	reactionBatches.pending[sessionID] = append(reactionBatches.pending[sessionID], event)
+}
+
+// flushReactionEvents broadcasts one reactionAdded and one reactionRemoved
+// message with the net changes since the last flush. A reaction toggled on
+// and off again within the window is dropped.
+func flushReactionEvents(sessionID string) {
+	reactionBatches.Lock()
+	events := reactionBatches.pending[sessionID]
+	delete(reactionBatches.pending, sessionID)
+	reactionBatches.Unlock()
+
+	type key struct{ target, emoji, username string }
+	toggles := make(map[key]int)
+	last := make(map[key]int)
+	var order []key
+	for i, event := range events {
+		k := key{event.TargetID, event.Emoji, event.Username}
+		if _, seen := toggles[k]; !seen {
+			order = append(order, k)
+		}
+		toggles[k]++
+		last[k] = i
+	}
+
+	// 同一表情的计数取窗口内最后一次的值
+	latestCount := make(map[[2]string]int)
+	for _, event := range events {
+		latestCount[[2]string{event.TargetID, event.Emoji}] = event.Count
+	}
+
+	added, removed := []reactionEvent{}, []reactionEvent{}
+	for _, k := range order {
+		if toggles[k]%2 == 0 {
+			continue
+		}
+		event := events[last[k]]
+		event.Count = latestCount[[2]string{event.TargetID, event.Emoji}]
+		if event.added {
+			added = append(added, event)
+		} else {
+			removed = append(removed, event)
+		}
+	}
+
+	if len(added) > 0 {
+		Broadcast(sessionID, map[string]interface{}{"type": "reactionAdded", "reactions": added})
+	}
+	if len(removed) > 0 {
+		Broadcast(sessionID, map[string]interface{}{"type": "reactionRemoved", "reactions": removed})
+	}
+}
+
+// ToggleReactionHandler adds the emoji to a summary or comment, or removes
+// it if the user already reacted with it
+func ToggleReactionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(mux.Vars(r)["sessionId"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	var input struct {
+		TargetType string `json:"target_type"`
+		TargetID   string `json:"target_id"`
+		Emoji      string `json:"emoji"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if input.TargetType != models.ReactionOnSummary && input.TargetType != models.ReactionOnComment {
+		http.Error(w, "target_type must be summary or comment", http.StatusBadRequest)
+		return
+	}
+	targetID, err := primitive.ObjectIDFromHex(input.TargetID)
+	if err != nil {
+		http.Error(w, "Invalid target ID", http.StatusBadRequest)
+		return
+	}
+	if !validEmoji(input.Emoji) {
+		http.Error(w, "Invalid emoji", http.StatusBadRequest)
+		return
+	}
+
+	var session models.Session
+	if err := sessionCollection.FindOne(r.Context(), notDeleted(bson.M{"_id": sessionID})).Decode(&session); err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if !hasReactionTarget(session, input.TargetType, targetID) {
+		http.Error(w, "Target not found", http.StatusNotFound)
+		return
+	}
+
+	filter := bson.M{"target_id": targetID, "emoji": input.Emoji, "username": user.Username}
+	result, err := reactionCollection.DeleteOne(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Failed to update reaction", http.StatusInternalServerError)
+		return
+	}
+	added := result.DeletedCount == 0
+	if added {
+		_, err = reactionCollection.InsertOne(r.Context(), models.Reaction{
+			ID:         primitive.NewObjectID(),
+			SessionID:  sessionID,
+			TargetType: input.TargetType,
+			TargetID:   targetID,
+			Emoji:      input.Emoji,
+			Username:   user.Username,
+			CreatedAt:  time.Now(),
+		})
+		// 并发的重复点击会撞上唯一索引，视为已添加
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			logFor(r.Context()).Error("Failed to add reaction", "error", err)
+			http.Error(w, "Failed to update reaction", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	counts, err := reactionCounts(r.Context(), bson.M{"target_id": targetID})
+	if err != nil {
+		http.Error(w, "Failed to count reactions", http.StatusInternalServerError)
+		return
+	}
+	reactions := counts[targetID]
+	if reactions == nil {
+		reactions = []models.ReactionCount{}
+	}
+
+	count := 0
+	for _, reaction := range reactions {
+		if reaction.Emoji == input.Emoji {
+			count = reaction.Count
+		}
+	}
+	queueReactionEvent(sessionID.Hex(), reactionEvent{
+		added:      added,
+		TargetType: input.TargetType,
+		TargetID:   targetID.Hex(),
+		Emoji:      input.Emoji,
+		Username:   user.Username,
+		Count:      count,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"added":     added,
+		"reactions": reactions,
+	})
+}
@@ -0,0 +1,480 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // 部署环境中不一定有时区数据库
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// recurrenceRule is the subset of an RFC 5545 RRULE that sessions support:
+// FREQ (DAILY, WEEKLY, MONTHLY, YEARLY), INTERVAL, COUNT, UNTIL, BYDAY and
+// BYMONTHDAY.
+type recurrenceRule struct {
+	Freq     string
+	Interval int
+	Count    int
+	// Until is the last allowed start. A date-only UNTIL includes the whole
+	// day, and UNTIL without "Z" is a local time; both are read in the time
+	// zone of the series by untilIn.
+	Until         time.Time
+	UntilDate     bool
+	UntilFloating bool
+	ByDay         []time.Weekday
+	ByMonthDay    []int
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// maxRecurrenceIterations guards against rules that never produce a match
+const maxRecurrenceIterations = 10000
+
+func parseRRule(value string) (recurrenceRule, error) {
+	rule := recurrenceRule{Interval: 1}
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return rule, fmt.Errorf("invalid RRULE part %q", part)
+		}
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+			switch rule.Freq {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+			default:
+				return rule, fmt.Errorf("unsupported FREQ %q", val)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("invalid INTERVAL %q", val)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("invalid COUNT %q", val)
+			}
+			rule.Count = n
+		case "UNTIL":
+			var err error
+			for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+				if rule.Until, err = time.Parse(layout, val); err == nil {
+					rule.UntilDate = layout == "20060102"
+					rule.UntilFloating = !strings.HasSuffix(layout, "Z")
+					break
+				}
+			}
+			if err != nil {
+				return rule, fmt.Errorf("invalid UNTIL %q", val)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				weekday, ok := rruleWeekdays[strings.ToUpper(day)]
+				if !ok {
+					return rule, fmt.Errorf("unsupported BYDAY value %q", day)
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(day)
+				if err != nil || n < 1 || n > 31 {
+					return rule, fmt.Errorf("unsupported BYMONTHDAY value %q", day)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "WKST":
+			// 周一开始，忽略
+		default:
+			return rule, fmt.Errorf("unsupported RRULE part %q", key)
+		}
+	}
+
+	if rule.Freq == "" {
+		return rule, fmt.Errorf("FREQ is required")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return rule, fmt.Errorf("COUNT and UNTIL cannot both be set")
+	}
+	// 只实现了 DAILY/WEEKLY 的 BYDAY 和 MONTHLY 的 BYMONTHDAY，其余组合拒绝而不是忽略
+	if len(rule.ByDay) > 0 && rule.Freq != "DAILY" && rule.Freq != "WEEKLY" {
+		return rule, fmt.Errorf("BYDAY is only supported with FREQ=DAILY or FREQ=WEEKLY")
+	}
+	if len(rule.ByMonthDay) > 0 && rule.Freq != "MONTHLY" {
+		return rule, fmt.Errorf("BYMONTHDAY is only supported with FREQ=MONTHLY")
+	}
+	sort.Ints(rule.ByMonthDay)
+	return rule, nil
+}
+
+// untilIn returns the last allowed start, reading a date-only or floating
+// UNTIL in loc
+func (rule recurrenceRule) untilIn(loc *time.Location) time.Time {
+	if !rule.UntilFloating {
+		return rule.Until
+	}
+	u := rule.Until
+	if rule.UntilDate {
+		return time.Date(u.Year(), u.Month(), u.Day()+1, 0, 0, 0, 0, loc).Add(-time.Nanosecond)
+	}
+	return time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), 0, loc)
+}
+
+func (rule recurrenceRule) hasWeekday(day time.Weekday) bool {
+	for _, d := range rule.ByDay {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates returns the occurrence candidates of the n-th period, in order
+func (rule recurrenceRule) candidates(start time.Time, n int) []time.Time {
+	loc := start.Location()
+	y, m, d := start.Date()
+	hh, mm, ss := start.Clock()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hh, mm, ss, 0, loc)
+	}
+	step := n * rule.Interval
+
+	switch rule.Freq {
+	case "DAILY":
+		t := at(y, m, d+step)
+		if len(rule.ByDay) > 0 && !rule.hasWeekday(t.Weekday()) {
+			return nil
+		}
+		return []time.Time{t}
+	case "WEEKLY":
+		if len(rule.ByDay) == 0 {
+			return []time.Time{at(y, m, d+7*step)}
+		}
+		// 以周一为一周的开始
+		offset := (int(start.Weekday()) + 6) % 7
+		monday := d - offset + 7*step
+		var result []time.Time
+		for i := 0; i < 7; i++ {
+			t := at(y, m, monday+i)
+			if rule.hasWeekday(t.Weekday()) {
+				result = append(result, t)
+			}
+		}
+		return result
+	case "MONTHLY":
+		days := rule.ByMonthDay
+		if len(days) == 0 {
+			days = []int{d}
+		}
+		first := at(y, m+time.Month(step), 1)
+		var result []time.Time
+		for _, day := range days {
+			t := at(first.Year(), first.Month(), day)
+			// 跳过不存在的日期，例如 2 月 30 日
+			if t.Month() == first.Month() {
+				result = append(result, t)
+			}
+		}
+		return result
+	case "YEARLY":
+		t := at(y+step, m, d)
+		if t.Month() != m {
+			return nil
+		}
+		return []time.Time{t}
+	}
+	return nil
+}
+
+// occurrences expands the rule from start and returns every occurrence that
+// begins before the given time. start itself is always the first occurrence.
+func (rule recurrenceRule) occurrences(start, before time.Time) []time.Time {
+	result := []time.Time{}
+	if !start.Before(before) {
+		return result
+	}
+	result = append(result, start)
+	until := rule.untilIn(start.Location())
+
+	for n := 0; n < maxRecurrenceIterations; n++ {
+		for _, t := range rule.candidates(start, n) {
+			if !t.After(start) {
+				continue
+			}
+			if rule.Count > 0 && len(result) >= rule.Count {
+				return result
+			}
+			if !rule.Until.IsZero() && t.After(until) {
+				return result
+			}
+			if !t.Before(before) {
+				return result
+			}
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// validateSchedule checks the scheduling fields of a session
+func validateSchedule(session *models.Session) string {
+	if session.ScheduledStart == nil {
+		if session.ScheduledEnd != nil || session.Recurrence != "" {
+			return "scheduled_start is required"
+		}
+		return ""
+	}
+	if session.ScheduledEnd != nil && !session.ScheduledEnd.After(*session.ScheduledStart) {
+		return "scheduled_end must be after scheduled_start"
+	}
+	if session.TimeZone != "" {
+		if _, err := time.LoadLocation(session.TimeZone); err != nil {
+			return "Unknown time zone"
+		}
+	}
+	if session.Recurrence != "" {
+		if _, err := parseRRule(session.Recurrence); err != nil {
+			return "Invalid recurrence: " + err.Error()
+		}
+		if !session.SeriesID.IsZero() {
+			return "An occurrence of a series cannot recur itself"
+		}
+	}
+	return ""
+}
+
+func recurrenceHorizon() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("RECURRENCE_HORIZON_DAYS"))
+	if err != nil || days <= 0 {
+		days = 14
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// materializeSeries brings the upcoming occurrences of a recurring session in
+// line with its schedule: it creates the occurrences within the recurrence
+// horizon that do not exist yet, moves the end and time zone of the ones that
+// stay, and moves future occurrences that have not started and no longer fit
+// the rule, start or time zone to the trash. It returns how many occurrences
+// it created.
+func materializeSeries(ctx context.Context, master models.Session) (int, error) {
+	now := time.Now()
+	existing, err := upcomingOccurrences(ctx, master.ID, now)
+	if err != nil {
+		return 0, err
+	}
+
+	// 以毫秒为键，和数据库中时间的精度一致
+	wanted := make(map[int64]time.Time)
+	var duration time.Duration
+	if master.Recurrence != "" && master.ScheduledStart != nil {
+		rule, err := parseRRule(master.Recurrence)
+		if err != nil {
+			return 0, err
+		}
+		loc := time.UTC
+		if master.TimeZone != "" {
+			if loc, err = time.LoadLocation(master.TimeZone); err != nil {
+				return 0, err
+			}
+		}
+		if master.ScheduledEnd != nil {
+			duration = master.ScheduledEnd.Sub(*master.ScheduledStart)
+		}
+
+		// 已建好的场次可能超出缩短后的范围，一并计算以免被误删
+		until := now.Add(recurrenceHorizon())
+		for _, occurrence := range existing {
+			if occurrence.ScheduledStart.After(until) {
+				until = occurrence.ScheduledStart.Add(time.Second)
+			}
+		}
+		start := master.ScheduledStart.In(loc)
+		for _, occurrence := range rule.occurrences(start, until) {
+			// 第一次由主会话本身表示；过去的场次不再补建
+			if occurrence.Equal(start) || occurrence.Before(now) {
+				continue
+			}
+			wanted[occurrence.UnixMilli()] = occurrence.UTC()
+		}
+	}
+
+	// 规则、开始时间或时区变了：不再符合的未开始场次移到回收站（可能已有评论或纪要，
+	// 由主持人决定是否恢复），保留的场次同步结束时间和时区
+	for _, occurrence := range existing {
+		occurrenceStart := occurrence.ScheduledStart.UTC()
+		if _, ok := wanted[occurrenceStart.UnixMilli()]; !ok {
+			_, err := sessionCollection.UpdateOne(ctx, notDeleted(bson.M{"_id": occurrence.ID}),
+				bson.M{"$set": bson.M{"deleted_at": now}})
+			if err != nil {
+				return 0, err
+			}
+			logFor(ctx).Info("Moved stale occurrence to the trash", "series_id", master.ID.Hex(), "session_id", occurrence.ID.Hex())
+			continue
+		}
+		set := bson.M{"time_zone": master.TimeZone, "scheduled_end": nil}
+		if duration > 0 {
+			set["scheduled_end"] = occurrenceStart.Add(duration)
+		}
+		if _, err := sessionCollection.UpdateOne(ctx, bson.M{"_id": occurrence.ID}, bson.M{"$set": set}); err != nil {
+			return 0, err
+		}
+	}
+
+	created := 0
+	for _, occurrenceStart := range wanted {
+		// 已存在（包括被删除到回收站）的场次不再创建；唯一索引防止和定时任务并发时重复
+		session := newSeriesOccurrence(master, occurrenceStart, duration)
+		if _, err := sessionCollection.InsertOne(ctx, session); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			return created, err
+		}
+		created++
+	}
+	return created, nil
+}
+
+// upcomingOccurrences returns the occurrences of a series that start after now
+// and have not started. Occurrences in the trash are left alone.
+func upcomingOccurrences(ctx context.Context, seriesID primitive.ObjectID, now time.Time) ([]models.Session, error) {
+	cursor, err := sessionCollection.Find(ctx, notDeleted(bson.M{
+		"series_id":       seriesID,
+		"scheduled_start": bson.M{"$gt": now},
+		"started_at":      bson.M{"$exists": false},
+	}), options.Find().SetProjection(bson.M{"_id": 1, "scheduled_start": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []models.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// ensureSeriesIndex makes sure a series has at most one occurrence per start
+func ensureSeriesIndex(ctx context.Context) {
+	_, err := sessionCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "series_id", Value: 1}, {Key: "scheduled_start", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"series_id": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		dbLog.Error("Failed to create indexes", "collection", "sessions", "error", err)
+	}
+}
+
+func newSeriesOccurrence(master models.Session, start time.Time, duration time.Duration) models.Session {
+	session := models.Session{
+		ID:             primitive.NewObjectID(),
+		Name:           master.Name,
+		CreatedAt:      time.Now(),
+		Participants:   []models.Participant{},
+		FacilitatorID:  master.FacilitatorID,
+		Agenda:         []models.AgendaItem{},
+		ScheduledStart: &start,
+		TimeZone:       master.TimeZone,
+		SeriesID:       master.ID,
+		Status:         models.SessionScheduled,
+		// 场次沿用主会议的设置
+		AnonymousFeedback: master.AnonymousFeedback,
+		SpeakerTimebox:    master.SpeakerTimebox,
+		MaxStars:          master.MaxStars,
+		TemplateID:        master.TemplateID,
+		Summaries: []models.Summary{{
+			ID:            primitive.NewObjectID(),
+			ParticipantID: primitive.NilObjectID,
+			Content:       "",
+			Comments:      []models.Comment{},
+			CreatedAt:     time.Now(),
+		}},
+	}
+	if master.Retro != nil {
+		session.Mode = master.Mode
+		session.Retro = newRetroBoard(*master.Retro)
+	}
+	if master.Poker != nil {
+		session.Mode = master.Mode
+		session.Poker = newPokerBoard(*master.Poker)
+	}
+	if duration > 0 {
+		end := start.Add(duration)
+		session.ScheduledEnd = &end
+	}
+	for _, participant := range master.Participants {
+		participant.Summarized = false
+		session.Participants = append(session.Participants, participant)
+	}
+	for _, item := range master.Agenda {
+		session.Agenda = append(session.Agenda, models.AgendaItem{
+			ID:              primitive.NewObjectID(),
+			Title:           item.Title,
+			Owner:           item.Owner,
+			AllottedMinutes: item.AllottedMinutes,
+			Notes:           item.Notes,
+		})
+	}
+	return session
+}
+
+// materializeAllSeries materializes every recurring session
+func materializeAllSeries(ctx context.Context) {
+	cursor, err := sessionCollection.Find(ctx, notDeleted(bson.M{"recurrence": bson.M{"$nin": bson.A{"", nil}}}))
+	if err != nil {
+		logFor(ctx).Error("Failed to fetch recurring sessions", "error", err)
+		return
+	}
+	var masters []models.Session
+	if err := cursor.All(ctx, &masters); err != nil {
+		logFor(ctx).Error("Failed to parse recurring sessions", "error", err)
+		return
+	}
+
+	for _, master := range masters {
+		created, err := materializeSeries(ctx, master)
+		if err != nil {
+			logFor(ctx).Error("Failed to materialize series", "series_id", master.ID.Hex(), "error", err)
+			continue
+		}
+		if created > 0 {
+			logFor(ctx).Info("Materialized occurrences", "series_id", master.ID.Hex(), "created", created)
+		}
+	}
+}
+
+// StartRecurrenceScheduler periodically materializes upcoming occurrences of
+// recurring sessions.
+func StartRecurrenceScheduler(interval time.Duration) {
+	go func() {
+		for {
+			materializeAllSeries(withLogger(context.Background(), recurrenceLog))
+			time.Sleep(interval)
+		}
+	}()
+}
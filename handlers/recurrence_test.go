@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRRuleRejectsBYDAYOutsideDailyAndWeekly(t *testing.T) {
+	for _, value := range []string{"FREQ=MONTHLY;BYDAY=MO", "FREQ=YEARLY;BYDAY=FR", "FREQ=WEEKLY;BYMONTHDAY=1"} {
+		if _, err := parseRRule(value); err == nil {
+			t.Errorf("%s accepted", value)
+		}
+	}
+}
+
+func TestRRuleUntilDateIncludesLastDay(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	start := time.Date(2026, 3, 2, 18, 0, 0, 0, loc)
+
+	rule, err := parseRRule("FREQ=DAILY;UNTIL=20260304")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rule.occurrences(start, start.AddDate(0, 1, 0)); len(got) != 3 {
+		t.Errorf("date-only UNTIL: got %d occurrences, want 3", len(got))
+	}
+
+	// 无 Z 后缀的 UNTIL 按会议时区理解
+	rule, err = parseRRule("FREQ=DAILY;UNTIL=20260304T180000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rule.occurrences(start, start.AddDate(0, 1, 0)); len(got) != 3 {
+		t.Errorf("floating UNTIL: got %d occurrences, want 3", len(got))
+	}
+}
+
+func TestRRuleSortsBYMONTHDAYBeforeCount(t *testing.T) {
+	start := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	rule, err := parseRRule("FREQ=MONTHLY;BYMONTHDAY=20,5;COUNT=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := rule.occurrences(start, start.AddDate(1, 0, 0))
+	if len(got) != 3 || got[1].Day() != 5 || got[2].Day() != 20 || got[2].Month() != time.January {
+		t.Errorf("got %v, want 1, 5 and 20 January", got)
+	}
+}
+
+func TestInvitationDeclaresItsTimeZone(t *testing.T) {
+	if _, err := time.LoadLocation("America/New_York"); err != nil {
+		t.Skip(err)
+	}
+	start := time.Date(2026, 10, 19, 14, 0, 0, 0, time.UTC)
+	session := models.Session{
+		ID:             primitive.NewObjectID(),
+		Name:           "Weekly sync",
+		ScheduledStart: &start,
+		Recurrence:     "FREQ=WEEKLY;BYDAY=MO",
+		TimeZone:       "America/New_York",
+	}
+	ics := string(renderCalendar(session.Name, "REQUEST", []models.Session{session}, nil))
+
+	for _, want := range []string{
+		"BEGIN:VTIMEZONE\r\nTZID:America/New_York\r\n",
+		// 2026-11-01 02:00 EDT 切换到 EST
+		"BEGIN:STANDARD\r\nDTSTART:20261101T020000\r\nTZOFFSETFROM:-0400\r\nTZOFFSETTO:-0500\r\nTZNAME:EST\r\n",
+		"BEGIN:DAYLIGHT\r\nDTSTART:20270314T020000\r\nTZOFFSETFROM:-0500\r\nTZOFFSETTO:-0400\r\nTZNAME:EDT\r\n",
+		"DTSTART;TZID=America/New_York:20261019T100000",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("invitation lacks %q:\n%s", want, ics)
+		}
+	}
+	if strings.Index(ics, "BEGIN:VTIMEZONE") > strings.Index(ics, "BEGIN:VEVENT") {
+		t.Error("VTIMEZONE written after the event that uses it")
+	}
+
+	if feed := string(renderCalendar(session.Name, "", []models.Session{session}, nil)); strings.Contains(feed, "VTIMEZONE") {
+		t.Error("feed without TZID times has a VTIMEZONE")
+	}
+}
@@ -0,0 +1,672 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxRetroCardLength = 500
+	defaultVoteBudget  = 3
+	maxVoteBudget      = 20
+	maxRetroColumns    = 10
+)
+
+var defaultRetroColumns = []string{"Went well", "To improve", "Actions"}
+
+// prepareRetroBoard sets up an empty retro board for a new session. Columns
+// and the vote budget may come from the request.
+func prepareRetroBoard(session *models.Session) string {
+	board := models.RetroBoard{VoteBudget: defaultVoteBudget}
+	var columns []string
+	if session.Retro != nil {
+		for _, column := range session.Retro.Columns {
+			columns = append(columns, column.Title)
+		}
+		if session.Retro.VoteBudget != 0 {
+			board.VoteBudget = session.Retro.VoteBudget
+		}
+	}
+	if len(columns) == 0 {
+		columns = defaultRetroColumns
+	}
+	if len(columns) > maxRetroColumns {
+		return "A retro board can have at most 10 columns"
+	}
+	if board.VoteBudget < 1 || board.VoteBudget > maxVoteBudget {
+		return "vote_budget must be between 1 and 20"
+	}
+	for _, title := range columns {
+		if title = strings.TrimSpace(title); title == "" {
+			return "Columns need a title"
+		}
+		board.Columns = append(board.Columns, models.RetroColumn{ID: primitive.NewObjectID(), Title: title})
+	}
+	session.Retro = newRetroBoard(board)
+	return ""
+}
+
+// newRetroBoard returns an empty board with the columns and budget of board.
+// Series occurrences start from the board of the series this way.
+func newRetroBoard(board models.RetroBoard) *models.RetroBoard {
+	return &models.RetroBoard{
+		Columns:    board.Columns,
+		Phase:      models.RetroPhaseCollect,
+		VoteBudget: board.VoteBudget,
+		Cards:      []models.RetroCard{},
+		Votes:      []models.RetroVote{},
+	}
+}
+
+func findRetroCard(board *models.RetroBoard, cardID string) (models.RetroCard, bool) {
+	for _, card := range board.Cards {
+		if card.ID.Hex() == cardID {
+			return card, true
+		}
+	}
+	return models.RetroCard{}, false
+}
+
+// retroGroup returns the card that heads the group of a card
+func retroGroup(card models.RetroCard) primitive.ObjectID {
+	if !card.GroupID.IsZero() {
+		return card.GroupID
+	}
+	return card.ID
+}
+
+func retroVotes(board *models.RetroBoard) map[primitive.ObjectID]int {
+	votes := make(map[primitive.ObjectID]int)
+	for _, vote := range board.Votes {
+		votes[vote.CardID]++
+	}
+	return votes
+}
+
+// retroView is the board as one participant sees it. Cards stay face down
+// for everyone but their author until they are revealed, and the dots of
+// others stay hidden until the discussion.
+func retroView(board *models.RetroBoard, viewer string) map[string]interface{} {
+	mine := make(map[primitive.ObjectID]int)
+	used := 0
+	for _, vote := range board.Votes {
+		if vote.Voter == viewer {
+			mine[vote.CardID]++
+			used++
+		}
+	}
+	totals := retroVotes(board)
+
+	cards := make([]map[string]interface{}, 0, len(board.Cards))
+	for _, card := range board.Cards {
+		view := map[string]interface{}{
+			"_id":        card.ID,
+			"column_id":  card.ColumnID,
+			"revealed":   card.Revealed,
+			"created_at": card.CreatedAt,
+			"my_votes":   mine[card.ID],
+		}
+		if card.Revealed || card.Author == viewer {
+			view["content"] = card.Content
+			view["author"] = card.Author
+			view["mine"] = card.Author == viewer
+		}
+		if !card.GroupID.IsZero() {
+			view["group_id"] = card.GroupID
+		}
+		if !card.ActionItemID.IsZero() {
+			view["action_item_id"] = card.ActionItemID
+		}
+		if board.Phase == models.RetroPhaseDiscuss {
+			view["votes"] = totals[card.ID]
+		}
+		cards = append(cards, view)
+	}
+
+	return map[string]interface{}{
+		"columns":     board.Columns,
+		"phase":       board.Phase,
+		"vote_budget": board.VoteBudget,
+		"votes_left":  board.VoteBudget - used,
+		"cards":       cards,
+	}
+}
+
+// redactRetro hides what retroView would hide from someone who wrote no cards
+// and cast no votes, for session responses and exports
+func redactRetro(board *models.RetroBoard) {
+	if board == nil {
+		return
+	}
+	for i := range board.Cards {
+		if !board.Cards[i].Revealed {
+			board.Cards[i].Content = ""
+			board.Cards[i].Author = ""
+		}
+	}
+	if board.Phase != models.RetroPhaseDiscuss {
+		board.Votes = []models.RetroVote{}
+	}
+	for i := range board.Votes {
+		board.Votes[i].Voter = ""
+	}
+}
+
+// broadcastRetro sends every client its view of the board after a change
+func broadcastRetro(client *MeetingClient) {
+	if session, err := loadClientSession(client); err == nil {
+		broadcastRetroBoard(session)
+	}
+}
+
+func broadcastRetroBoard(session models.Session) {
+	if session.Retro == nil {
+		return
+	}
+	board := session.Retro
+	BroadcastEach(session.ID.Hex(), func(c *MeetingClient) interface{} {
+		return map[string]interface{}{
+			"type":  "retroBoard",
+			"board": retroView(board, c.username),
+		}
+	})
+}
+
+// loadRetroSession loads the client's session and checks that it is a retro
+// in the given phase. An empty phase accepts any phase.
+func loadRetroSession(client *MeetingClient, action, phase string) (models.Session, bool) {
+	session, err := loadClientSession(client)
+	if err != nil {
+		sendError(client, action, "Session not found")
+		return session, false
+	}
+	if session.Retro == nil {
+		sendError(client, action, "Session is not a retro")
+		return session, false
+	}
+	if phase != "" && session.Retro.Phase != phase {
+		sendError(client, action, "Not possible in the "+session.Retro.Phase+" phase")
+		return session, false
+	}
+	return session, true
+}
+
+// updateRetro runs an update on a retro session with array filters
+func updateRetro(sessionID primitive.ObjectID, filter bson.M, update bson.M, arrayFilters ...interface{}) (bool, error) {
+	filter["_id"] = sessionID
+	opts := options.Update()
+	if len(arrayFilters) > 0 {
+		opts.SetArrayFilters(options.ArrayFilters{Filters: arrayFilters})
+	}
+	result, err := sessionCollection.UpdateOne(context.Background(), filter, update, opts)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func handleAddCard(client *MeetingClient, msg map[string]interface{}) {
+	var input struct {
+		ColumnID string `json:"columnId"`
+		Content  string `json:"content"`
+	}
+	if err := decodeMessage(msg, &input); err != nil {
+		sendError(client, "addCard", "Invalid card")
+		return
+	}
+	content := strings.TrimSpace(input.Content)
+	if content == "" || utf8.RuneCountInString(content) > maxRetroCardLength {
+		sendError(client, "addCard", "Cards need between 1 and 500 characters")
+		return
+	}
+	session, ok := loadRetroSession(client, "addCard", models.RetroPhaseCollect)
+	if !ok {
+		return
+	}
+	var column primitive.ObjectID
+	for _, c := range session.Retro.Columns {
+		if c.ID.Hex() == input.ColumnID {
+			column = c.ID
+		}
+	}
+	if column.IsZero() {
+		sendError(client, "addCard", "Column not found")
+		return
+	}
+
+	card := models.RetroCard{
+		ID:        primitive.NewObjectID(),
+		ColumnID:  column,
+		Content:   content,
+		Author:    client.username,
+		CreatedAt: time.Now(),
+	}
+	matched, err := updateRetro(session.ID, bson.M{"retro.phase": models.RetroPhaseCollect},
+		bson.M{"$push": bson.M{"retro.cards": card}})
+	if err != nil || !matched {
+		if err != nil {
+			client.log().Error("Failed to add retro card", "error", err)
+		}
+		sendError(client, "addCard", "Failed to add card")
+		return
+	}
+	broadcastRetro(client)
+}
+
+// handleDeleteCard removes a card before the votes are counted. Authors can
+// delete their own cards, the facilitator any card.
+func handleDeleteCard(client *MeetingClient, msg map[string]interface{}) {
+	cardID, _ := msg["cardId"].(string)
+	session, ok := loadRetroSession(client, "deleteCard", "")
+	if !ok {
+		return
+	}
+	card, found := findRetroCard(session.Retro, cardID)
+	if !found {
+		sendError(client, "deleteCard", "Card not found")
+		return
+	}
+	if card.Author != client.username && !isFacilitator(session, client.userID) {
+		sendError(client, "deleteCard", "You can only delete your own cards")
+		return
+	}
+	if session.Retro.Phase == models.RetroPhaseDiscuss {
+		sendError(client, "deleteCard", "Cards can't be deleted during the discussion")
+		return
+	}
+
+	// 先解散以该卡片为首的分组，再删除卡片和它的票
+	_, err := updateRetro(session.ID, bson.M{},
+		bson.M{"$unset": bson.M{"retro.cards.$[c].group_id": ""}},
+		bson.M{"c.group_id": card.ID})
+	if err == nil {
+		_, err = updateRetro(session.ID, bson.M{}, bson.M{"$pull": bson.M{
+			"retro.cards": bson.M{"_id": card.ID},
+			"retro.votes": bson.M{"card_id": card.ID},
+		}})
+	}
+	if err != nil {
+		client.log().Error("Failed to delete retro card", "error", err)
+		sendError(client, "deleteCard", "Failed to delete card")
+		return
+	}
+	broadcastRetro(client)
+}
+
+// handleRetroPhase moves the board to another phase. Only the facilitator
+// may do that.
+func handleRetroPhase(client *MeetingClient, msg map[string]interface{}) {
+	phase, _ := msg["phase"].(string)
+	switch phase {
+	case models.RetroPhaseCollect, models.RetroPhaseReveal, models.RetroPhaseVote, models.RetroPhaseDiscuss:
+	default:
+		sendError(client, "retroPhase", "Phase must be collect, reveal, vote or discuss")
+		return
+	}
+	session, ok := loadRetroSession(client, "retroPhase", "")
+	if !ok {
+		return
+	}
+	if !isFacilitator(session, client.userID) {
+		sendError(client, "retroPhase", "Only the facilitator can change the phase")
+		return
+	}
+
+	if _, err := updateRetro(session.ID, bson.M{}, bson.M{"$set": bson.M{"retro.phase": phase}}); err != nil {
+		client.log().Error("Failed to change retro phase", "error", err)
+		sendError(client, "retroPhase", "Failed to change phase")
+		return
+	}
+	broadcastRetro(client)
+}
+
+// handleRevealCard flips a card, or every card when no card is given
+func handleRevealCard(client *MeetingClient, msg map[string]interface{}) {
+	cardID, _ := msg["cardId"].(string)
+	session, ok := loadRetroSession(client, "revealCard", "")
+	if !ok {
+		return
+	}
+	if !isFacilitator(session, client.userID) {
+		sendError(client, "revealCard", "Only the facilitator can reveal cards")
+		return
+	}
+	if session.Retro.Phase == models.RetroPhaseCollect {
+		sendError(client, "revealCard", "Move to the reveal phase first")
+		return
+	}
+
+	var err error
+	if cardID == "" {
+		_, err = updateRetro(session.ID, bson.M{}, bson.M{"$set": bson.M{"retro.cards.$[].revealed": true}})
+	} else {
+		card, found := findRetroCard(session.Retro, cardID)
+		if !found {
+			sendError(client, "revealCard", "Card not found")
+			return
+		}
+		_, err = updateRetro(session.ID, bson.M{},
+			bson.M{"$set": bson.M{"retro.cards.$[c].revealed": true}},
+			bson.M{"c._id": card.ID})
+	}
+	if err != nil {
+		client.log().Error("Failed to reveal retro card", "error", err)
+		sendError(client, "revealCard", "Failed to reveal card")
+		return
+	}
+	broadcastRetro(client)
+}
+
+// handleGroupCard puts a card and the cards grouped under it into the group
+// of another card. Without intoCardId the card leaves its group.
+func handleGroupCard(client *MeetingClient, msg map[string]interface{}) {
+	cardID, _ := msg["cardId"].(string)
+	intoCardID, _ := msg["intoCardId"].(string)
+	session, ok := loadRetroSession(client, "groupCard", models.RetroPhaseReveal)
+	if !ok {
+		return
+	}
+	card, found := findRetroCard(session.Retro, cardID)
+	if !found || !card.Revealed {
+		sendError(client, "groupCard", "Card not found")
+		return
+	}
+
+	if intoCardID == "" {
+		if _, err := updateRetro(session.ID, bson.M{},
+			bson.M{"$unset": bson.M{"retro.cards.$[c].group_id": ""}},
+			bson.M{"c._id": card.ID}); err != nil {
+			client.log().Error("Failed to ungroup retro card", "error", err)
+			sendError(client, "groupCard", "Failed to ungroup card")
+			return
+		}
+		broadcastRetro(client)
+		return
+	}
+
+	target, found := findRetroCard(session.Retro, intoCardID)
+	if !found || !target.Revealed {
+		sendError(client, "groupCard", "Card not found")
+		return
+	}
+	group := retroGroup(target)
+	if group == card.ID {
+		sendError(client, "groupCard", "A card can't be grouped with itself")
+		return
+	}
+
+	// 卡片原来的分组成员一起移动，票数归到新分组
+	moved := bson.M{"$or": bson.A{bson.M{"c._id": card.ID}, bson.M{"c.group_id": card.ID}}}
+	_, err := updateRetro(session.ID, bson.M{},
+		bson.M{"$set": bson.M{"retro.cards.$[c].group_id": group}}, moved)
+	if err == nil {
+		_, err = updateRetro(session.ID, bson.M{},
+			bson.M{"$set": bson.M{"retro.votes.$[v].card_id": group}},
+			bson.M{"v.card_id": card.ID})
+	}
+	if err != nil {
+		client.log().Error("Failed to group retro card", "error", err)
+		sendError(client, "groupCard", "Failed to group card")
+		return
+	}
+	broadcastRetro(client)
+}
+
+// handleDot puts one of the client's dots on a card, or takes one back when
+// remove is set. Dots on a grouped card count for its group.
+func handleDot(client *MeetingClient, msg map[string]interface{}) {
+	cardID, _ := msg["cardId"].(string)
+	remove, _ := msg["remove"].(bool)
+	session, ok := loadRetroSession(client, "dot", models.RetroPhaseVote)
+	if !ok {
+		return
+	}
+	card, found := findRetroCard(session.Retro, cardID)
+	if !found || !card.Revealed {
+		sendError(client, "dot", "Card not found")
+		return
+	}
+	group := retroGroup(card)
+
+	var own *models.RetroVote
+	used := 0
+	for i, vote := range session.Retro.Votes {
+		if vote.Voter != client.username {
+			continue
+		}
+		used++
+		if vote.CardID == group {
+			own = &session.Retro.Votes[i]
+		}
+	}
+
+	var err error
+	if remove {
+		if own == nil {
+			sendError(client, "dot", "You have no dot on this card")
+			return
+		}
+		_, err = updateRetro(session.ID, bson.M{},
+			bson.M{"$pull": bson.M{"retro.votes": bson.M{"_id": own.ID}}})
+	} else {
+		if used >= session.Retro.VoteBudget {
+			sendError(client, "dot", "You have no dots left")
+			return
+		}
+		// 预算也在更新条件里检查，同时点的两个点不会超出预算
+		var matched bool
+		matched, err = updateRetro(session.ID, bson.M{
+			"retro.phase": models.RetroPhaseVote,
+			"$expr": bson.M{"$lt": bson.A{
+				bson.M{"$size": bson.M{"$filter": bson.M{
+					"input": bson.M{"$ifNull": bson.A{"$retro.votes", bson.A{}}},
+					"cond":  bson.M{"$eq": bson.A{"$$this.voter", client.username}},
+				}}},
+				"$retro.vote_budget",
+			}},
+		}, bson.M{"$push": bson.M{"retro.votes": models.RetroVote{
+			ID:     primitive.NewObjectID(),
+			CardID: group,
+			Voter:  client.username,
+			CastAt: time.Now(),
+		}}})
+		if err == nil && !matched {
+			if _, ok := loadRetroSession(client, "dot", models.RetroPhaseVote); ok {
+				sendError(client, "dot", "You have no dots left")
+			}
+			return
+		}
+	}
+	if err != nil {
+		client.log().Error("Failed to record dot", "error", err)
+		sendError(client, "dot", "Failed to record dot")
+		return
+	}
+	broadcastRetro(client)
+}
+
+// rankedRetroCards returns the cards that head a group, or stand alone, with
+// the most votes first
+func rankedRetroCards(board *models.RetroBoard) []models.RetroCard {
+	votes := retroVotes(board)
+	var ranked []models.RetroCard
+	for _, card := range board.Cards {
+		if card.GroupID.IsZero() && card.Revealed {
+			ranked = append(ranked, card)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return votes[ranked[i].ID] > votes[ranked[j].ID]
+	})
+	return ranked
+}
+
+// GetRetroHandler returns the retro board as the current user sees it
+func GetRetroHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if session.Retro == nil {
+		http.Error(w, "Session is not a retro", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(retroView(session.Retro, user.Username))
+}
+
+// ConvertRetroCardsHandler turns retro cards into action items of the
+// session. It takes card_ids, or top for the most voted cards that have not
+// been converted yet. Only the facilitator may convert cards.
+func ConvertRetroCardsHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadAuthorizedSession(w, r)
+	if !ok {
+		return
+	}
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !isFacilitator(session, user.GitHubID) {
+		http.Error(w, "Only the facilitator can convert cards", http.StatusForbidden)
+		return
+	}
+	if session.Retro == nil {
+		http.Error(w, "Session is not a retro", http.StatusNotFound)
+		return
+	}
+	if session.Retro.Phase != models.RetroPhaseDiscuss {
+		http.Error(w, "Cards can be converted during the discussion", http.StatusConflict)
+		return
+	}
+
+	var input struct {
+		CardIDs  []string `json:"card_ids"`
+		Top      int      `json:"top"`
+		Assignee string   `json:"assignee"`
+		DueDate  string   `json:"due_date"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var cards []models.RetroCard
+	switch {
+	case len(input.CardIDs) > 0:
+		for _, cardID := range input.CardIDs {
+			card, found := findRetroCard(session.Retro, cardID)
+			if !found || !card.Revealed {
+				http.Error(w, "Card not found: "+cardID, http.StatusBadRequest)
+				return
+			}
+			if !card.ActionItemID.IsZero() {
+				http.Error(w, "Card already converted: "+cardID, http.StatusConflict)
+				return
+			}
+			cards = append(cards, card)
+		}
+	case input.Top > 0:
+		for _, card := range rankedRetroCards(session.Retro) {
+			if len(cards) == input.Top {
+				break
+			}
+			if card.ActionItemID.IsZero() {
+				cards = append(cards, card)
+			}
+		}
+	default:
+		http.Error(w, "Pass card_ids or top", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	items := []models.ActionItem{}
+	for _, card := range cards {
+		item := models.ActionItem{
+			ID:        primitive.NewObjectID(),
+			SessionID: session.ID,
+			Status:    models.ActionItemOpen,
+			Source:    "retro",
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		title := retroCardTitle(card.Content)
+		fields := actionItemInput{Title: &title}
+		if input.Assignee != "" {
+			fields.Assignee = &input.Assignee
+		}
+		if input.DueDate != "" {
+			fields.DueDate = &input.DueDate
+		}
+		if msg := fields.apply(session, &item); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		items = append(items, item)
+	}
+
+	created := []models.ActionItem{}
+	for i, item := range items {
+		// 先占用卡片再创建行动项；双击或两个标签页同时转换时，已被占用的卡片跳过
+		unconverted := bson.M{"$in": bson.A{nil, primitive.NilObjectID}}
+		result, err := sessionCollection.UpdateOne(r.Context(),
+			bson.M{"_id": session.ID, "retro.cards": bson.M{"$elemMatch": bson.M{"_id": cards[i].ID, "action_item_id": unconverted}}},
+			bson.M{"$set": bson.M{"retro.cards.$[c].action_item_id": item.ID}},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"c._id": cards[i].ID}}}))
+		if err != nil {
+			logFor(r.Context()).Error("Failed to link retro card", "card_id", cards[i].ID.Hex(), "error", err)
+			http.Error(w, "Failed to create action items", http.StatusInternalServerError)
+			return
+		}
+		if result.MatchedCount == 0 {
+			continue
+		}
+		if _, err := actionItemCollection.InsertOne(r.Context(), item); err != nil {
+			logFor(r.Context()).Error("Failed to create action item from retro card", "card_id", cards[i].ID.Hex(), "error", err)
+			sessionCollection.UpdateOne(r.Context(), bson.M{"_id": session.ID},
+				bson.M{"$unset": bson.M{"retro.cards.$[c].action_item_id": ""}},
+				options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"c._id": cards[i].ID, "c.action_item_id": item.ID}}}))
+			http.Error(w, "Failed to create action items", http.StatusInternalServerError)
+			return
+		}
+		created = append(created, item)
+		broadcastActionItem("actionItemCreated", item)
+		notifyActionItemAssigned(r.Context(), item)
+	}
+	if len(created) == 0 && len(items) > 0 && len(input.CardIDs) > 0 {
+		http.Error(w, "Cards already converted", http.StatusConflict)
+		return
+	}
+
+	if err := sessionCollection.FindOne(r.Context(), bson.M{"_id": session.ID}).Decode(&session); err == nil {
+		broadcastRetroBoard(session)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// retroCardTitle shortens a card to the first line for an action item title
+func retroCardTitle(content string) string {
+	title, _, _ := strings.Cut(content, "\n")
+	return strings.TrimSpace(title)
+}
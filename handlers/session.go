@@ -4,12 +4,11 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"log"
 	"net/http"
+	"strings"
 	"time"
 	"your-project/models"
 
-	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -19,6 +18,8 @@ var sessionCollection *mongo.Collection
 
 func InitSessionCollection(client *mongo.Client) {
 	sessionCollection = client.Database("your-db-name").Collection("sessions")
+	backfillSessionStatuses(context.Background())
+	ensureSeriesIndex(context.Background())
 }
 
 // CreateSessionHandler creates a new session
@@ -33,8 +34,69 @@ func CreateSessionHandler(w http.ResponseWriter, r *http.Request) {
 	session.ID = primitive.NewObjectID()
 	session.CreatedAt = time.Now()
 
+	// 创建者即主持人
+	session.FacilitatorID = 0
+	if authSession, err := store.Get(r, "auth-session"); err == nil {
+		if userID, ok := authSession.Values["user_id"].(int); ok {
+			session.FacilitatorID = userID
+		}
+	}
+
+	// 从模板创建时补全未填写的字段
+	var template models.SessionTemplate
+	if !session.TemplateID.IsZero() {
+		if err := templateCollection.FindOne(r.Context(), bson.M{"_id": session.TemplateID}).Decode(&template); err != nil {
+			http.Error(w, "Template not found", http.StatusBadRequest)
+			return
+		}
+		if err := applyTemplate(r.Context(), &session, template); err != nil {
+			logFor(r.Context()).Error("Failed to apply template", "template_id", template.ID.Hex(), "error", err)
+			http.Error(w, "Failed to apply template", http.StatusInternalServerError)
+			return
+		}
+	}
+	if session.MaxStars < 0 || session.MaxStars > maxMaxStars {
+		http.Error(w, "max_stars must be between 1 and 10", http.StatusBadRequest)
+		return
+	}
+	if session.SpeakerTimebox < 0 {
+		http.Error(w, "speaker_timebox must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	// 复盘和估算模式的看板
+	if msg := prepareSessionMode(&session); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// 为议程项分配 ID
+	if session.Agenda == nil {
+		session.Agenda = []models.AgendaItem{}
+	}
+	for i := range session.Agenda {
+		session.Agenda[i].ID = primitive.NewObjectID()
+		session.Agenda[i].Completed = false
+		session.Agenda[i].CompletedAt = nil
+	}
+	session.CurrentAgendaItem = primitive.NilObjectID
+
+	// 校验排期和重复规则
+	session.SeriesID = primitive.NilObjectID
+	if msg := validateSchedule(&session); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// 状态由服务端维护
+	session.StartedAt, session.EndedAt = nil, nil
+	session.Breakouts = nil
+	session.ArchivedAt, session.DeletedAt, session.DeletedBy = nil, nil, ""
+	session.Status = timelineStatus(session)
+
 	// 创建一个初始的 summary
 	initialSummary := models.Summary{
+		ID:            primitive.NewObjectID(),
 		ParticipantID: primitive.NilObjectID, // 或者从请求中获取参与者ID
 		Content:       "",                    // 初始内容为空
 		Comments:      []models.Comment{},    // 显式初始化空的评论数组
@@ -47,20 +109,70 @@ func CreateSessionHandler(w http.ResponseWriter, r *http.Request) {
 	// 插入到数据库
 	result, err := sessionCollection.InsertOne(context.Background(), session)
 	if err != nil {
-		log.Printf("Failed to create session: %v", err)
+		logFor(r.Context()).Error("Failed to create session", "error", err)
 		http.Error(w, "Failed to create session", http.StatusInternalServerError)
 		return
 	}
 
+	if err := createTemplateMinutes(context.Background(), session, template); err != nil {
+		logFor(r.Context()).Error("Failed to create minutes", "session_id", session.ID.Hex(), "error", err)
+	}
+
+	// 生成重复会议的后续场次
+	if _, err := materializeSeries(context.Background(), session); err != nil {
+		logFor(r.Context()).Error("Failed to materialize series", "series_id", session.ID.Hex(), "error", err)
+	}
+
+	// 邮件邀请参与者
+	notifyInvitation(context.Background(), session)
+
+	emitWebhookEvent(context.Background(), session.ID, models.EventSessionCreated, session)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"id": result.InsertedID,
 	})
 }
 
-// GetSessionsHandler retrieves all sessions
+// prepareSessionMode checks the mode of a new session and sets up the board
+// the mode needs
+func prepareSessionMode(session *models.Session) string {
+	switch session.Mode {
+	case "":
+		session.Retro, session.Poker = nil, nil
+		return ""
+	case models.SessionModeRetro:
+		session.Poker = nil
+		return prepareRetroBoard(session)
+	case models.SessionModePoker:
+		session.Retro = nil
+		return preparePokerBoard(session)
+	}
+	return "Mode must be empty, retro or poker"
+}
+
+// GetSessionsHandler retrieves all sessions that are not archived or in the
+// trash. ?status= lists the sessions with the given comma separated statuses,
+// including archived ones.
 func GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
-	cursor, err := sessionCollection.Find(context.Background(), bson.M{})
+	filter := notDeleted(bson.M{})
+	if value := r.URL.Query().Get("status"); value != "" {
+		statuses := bson.A{}
+		for _, status := range strings.Split(value, ",") {
+			switch status = strings.TrimSpace(status); status {
+			case models.SessionDraft, models.SessionScheduled, models.SessionLive, models.SessionEnded, models.SessionArchived:
+				statuses = append(statuses, status)
+			default:
+				http.Error(w, "Unknown status "+status, http.StatusBadRequest)
+				return
+			}
+		}
+		filter["status"] = bson.M{"$in": statuses}
+	} else {
+		filter["status"] = bson.M{"$ne": models.SessionArchived}
+	}
+
+	cursor, err := sessionCollection.Find(context.Background(), filter)
 	if err != nil {
 		http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
 		return
@@ -73,32 +185,61 @@ func GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := attachReactions(r.Context(), sessions); err != nil {
+		logFor(r.Context()).Error("Failed to load reactions", "error", err)
+	}
+	admin := requestIsAdmin(r)
+	for i := range sessions {
+		sessions[i].Status = sessionStatus(sessions[i])
+		redactSession(&sessions[i], admin)
+	}
+
 	json.NewEncoder(w).Encode(sessions)
 }
 
-// DeleteSessionHandler deletes a session by its ID
+// DeleteSessionHandler moves a session to the trash. It is purged with its
+// minutes, action items and other records once the retention period is over.
+// Like restoring and purging, only the facilitator can do it.
 func DeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	sessionID := vars["sessionId"]
-
-	// Convert sessionID to MongoDB ObjectID
-	objectID, err := primitive.ObjectIDFromHex(sessionID)
-	if err != nil {
-		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+	session, ok := loadFacilitatedSession(w, r, notDeleted(bson.M{}), "delete")
+	if !ok {
 		return
 	}
+	user, _ := currentUser(r)
 
-	filter := bson.M{"_id": objectID}
-	result, err := sessionCollection.DeleteOne(context.Background(), filter)
+	result, err := sessionCollection.UpdateOne(r.Context(), notDeleted(bson.M{"_id": session.ID}), bson.M{
+		"$set": bson.M{"deleted_at": time.Now(), "deleted_by": user.Username},
+	})
 	if err != nil {
 		http.Error(w, "Failed to delete session", http.StatusInternalServerError)
 		return
 	}
 
-	if result.DeletedCount == 0 {
+	if result.MatchedCount == 0 {
 		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Session deleted successfully"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Session moved to trash"})
+}
+
+// isFacilitator reports whether the user may run the session. Sessions
+// created before facilitators were recorded can be run by anyone.
+func isFacilitator(session models.Session, userID int) bool {
+	return session.FacilitatorID == 0 || session.FacilitatorID == userID
+}
+
+// isSessionMember reports whether the user is a participant or the recorded
+// facilitator of the session. Unlike isFacilitator it does not let everyone
+// into sessions created before facilitators were recorded.
+func isSessionMember(session models.Session, user authUser) bool {
+	if session.FacilitatorID != 0 && session.FacilitatorID == user.GitHubID {
+		return true
+	}
+	for _, participant := range session.Participants {
+		if strings.EqualFold(participant.Username, user.Username) {
+			return true
+		}
+	}
+	return false
 }
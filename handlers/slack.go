@@ -0,0 +1,711 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Slack 要求请求时间戳在五分钟以内，防止重放
+const slackTimestampTolerance = 5 * time.Minute
+
+// slackLinkTTL is how long the link sent to an unlinked Slack user stays valid
+const slackLinkTTL = 15 * time.Minute
+
+// The clock and the storage behind the Slack commands, replaced in tests
+var (
+	slackNow             = time.Now
+	slackUserLookup      = findSlackUser
+	slackSessionLookup   = findSessionByRef
+	slackSessionInsert   = insertSlackSession
+	slackSessionsBetween = findSessionsBetween
+	slackSummaryAppend   = appendSummary
+	slackMinutesLookup   = findMinutes
+	slackRecordsLookup   = findMeetingRecords
+)
+
+// errAmbiguousSessionRef is returned by findSessionByRef when several
+// sessions have the given name
+var errAmbiguousSessionRef = errors.New("several sessions have this name")
+
+// slackMessage is a Slack message as returned to slash commands and posted
+// to incoming webhooks
+type slackMessage struct {
+	ResponseType    string        `json:"response_type,omitempty"` // ephemeral or in_channel
+	ReplaceOriginal bool          `json:"replace_original,omitempty"`
+	Text            string        `json:"text"`
+	Blocks          []interface{} `json:"blocks,omitempty"`
+}
+
+// verifySlackSignature checks a request against Slack's v0 signing scheme:
+// the signature is the hex HMAC-SHA256 of "v0:<timestamp>:<body>".
+func verifySlackSignature(secret, timestamp, signature string, body []byte, now time.Time) error {
+	if secret == "" {
+		return errors.New("SLACK_SIGNING_SECRET is not set")
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid request timestamp")
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > slackTimestampTolerance || age < -slackTimestampTolerance {
+		return errors.New("request timestamp is too old")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// readSlackForm verifies the request signature and parses the form body,
+// writing an error response on failure.
+func readSlackForm(w http.ResponseWriter, r *http.Request) (url.Values, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return nil, false
+	}
+
+	err = verifySlackSignature(os.Getenv("SLACK_SIGNING_SECRET"),
+		r.Header.Get("X-Slack-Request-Timestamp"), r.Header.Get("X-Slack-Signature"), body, slackNow())
+	if err != nil {
+		logFor(r.Context()).Warn("Rejected Slack request", "error", err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return nil, false
+	}
+	return form, true
+}
+
+// 一个 Slack 账号（team_id + user_id）对应一个用户。Slack 的 user_name 可以随意修改，
+// 不能用来识别用户，所以未关联的 Slack 账号一律拒绝，并回复关联链接。
+
+// findSlackUser loads the user linked to a Slack account
+func findSlackUser(ctx context.Context, teamID, slackUserID string) (authUser, error) {
+	var user authUser
+	if teamID == "" || slackUserID == "" {
+		return user, mongo.ErrNoDocuments
+	}
+	err := Client.Database("your-db-name").Collection("users").FindOne(ctx,
+		bson.M{"slack_team_id": teamID, "slack_user_id": slackUserID}).Decode(&user)
+	return user, err
+}
+
+// signSlackLink returns a token naming the Slack account and when it expires,
+// signed with SLACK_SIGNING_SECRET
+func signSlackLink(secret, teamID, slackUserID string, expires time.Time) string {
+	payload := fmt.Sprintf("%s:%s:%d", teamID, slackUserID, expires.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return payload + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseSlackLink checks a token made by signSlackLink and returns the Slack
+// account it names
+func parseSlackLink(secret, token string, now time.Time) (teamID, slackUserID string, err error) {
+	if secret == "" {
+		return "", "", errors.New("SLACK_SIGNING_SECRET is not set")
+	}
+	parts := strings.Split(token, ":")
+	if len(parts) != 4 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New("malformed link token")
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", "", errors.New("malformed link token")
+	}
+	expected := signSlackLink(secret, parts[0], parts[1], time.Unix(expires, 0))
+	if !hmac.Equal([]byte(expected), []byte(token)) {
+		return "", "", errors.New("signature mismatch")
+	}
+	if now.After(time.Unix(expires, 0)) {
+		return "", "", errors.New("link has expired")
+	}
+	return parts[0], parts[1], nil
+}
+
+// slackLinkMessage asks a Slack user to link their account
+func slackLinkMessage(teamID, slackUserID string) slackMessage {
+	token := signSlackLink(os.Getenv("SLACK_SIGNING_SECRET"), teamID, slackUserID, slackNow().Add(slackLinkTTL))
+	link := frontendURL() + "/api/integrations/slack/link?token=" + url.QueryEscape(token)
+	return slackText(fmt.Sprintf("Your Slack account is not linked yet. Log in to the meeting app, then <%s|link your account> (the link expires in %d minutes).",
+		link, int(slackLinkTTL.Minutes())))
+}
+
+// slackUser resolves the linked user of a Slack request. ok is false, and
+// message is the reply to send, when the account is not linked or the lookup
+// failed.
+func slackUser(ctx context.Context, teamID, slackUserID string) (authUser, slackMessage, bool) {
+	user, err := slackUserLookup(ctx, teamID, slackUserID)
+	if err == mongo.ErrNoDocuments {
+		return user, slackLinkMessage(teamID, slackUserID), false
+	} else if err != nil {
+		logFor(ctx).Error("Failed to look up Slack user", "team_id", teamID, "slack_user_id", slackUserID, "error", err)
+		return user, slackText("Failed to look up your account"), false
+	}
+	return user, slackMessage{}, true
+}
+
+// SlackLinkHandler links the Slack account named in a link token to the
+// logged-in user. A Slack account is linked to at most one user.
+func SlackLinkHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Log in first, then open the link from Slack again", http.StatusUnauthorized)
+		return
+	}
+	teamID, slackUserID, err := parseSlackLink(os.Getenv("SLACK_SIGNING_SECRET"), r.URL.Query().Get("token"), slackNow())
+	if err != nil {
+		logFor(r.Context()).Warn("Rejected Slack link", "error", err)
+		http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+
+	users := Client.Database("your-db-name").Collection("users")
+	_, err = users.UpdateMany(r.Context(),
+		bson.M{"slack_team_id": teamID, "slack_user_id": slackUserID, "_id": bson.M{"$ne": user.ID}},
+		bson.M{"$unset": bson.M{"slack_team_id": "", "slack_user_id": ""}})
+	if err == nil {
+		_, err = users.UpdateOne(r.Context(), bson.M{"_id": user.ID},
+			bson.M{"$set": bson.M{"slack_team_id": teamID, "slack_user_id": slackUserID}})
+	}
+	if err != nil {
+		logFor(r.Context()).Error("Failed to link Slack account", "username", user.Username, "error", err)
+		http.Error(w, "Failed to link Slack account", http.StatusInternalServerError)
+		return
+	}
+
+	slackLog.Info("Linked Slack account", "username", user.Username, "team_id", teamID, "slack_user_id", slackUserID)
+	http.Redirect(w, r, frontendURL()+"?slack_connected=true", http.StatusFound)
+}
+
+func writeSlackMessage(w http.ResponseWriter, message slackMessage) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+func slackText(text string) slackMessage {
+	return slackMessage{ResponseType: "ephemeral", Text: text}
+}
+
+func slackSection(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "section",
+		"text": map[string]interface{}{"type": "mrkdwn", "text": text},
+	}
+}
+
+// slackSessionActions builds the buttons shown under a session in Slack
+func slackSessionActions(session models.Session) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "actions",
+		"elements": []interface{}{
+			map[string]interface{}{
+				"type": "button",
+				"text": map[string]interface{}{"type": "plain_text", "text": "Open"},
+				"url":  sessionLink(session.ID),
+			},
+			map[string]interface{}{
+				"type":      "button",
+				"text":      map[string]interface{}{"type": "plain_text", "text": "Minutes"},
+				"action_id": "minutes",
+				"value":     session.ID.Hex(),
+			},
+			map[string]interface{}{
+				"type":      "button",
+				"text":      map[string]interface{}{"type": "plain_text", "text": "Post results"},
+				"action_id": "post_results",
+				"value":     session.ID.Hex(),
+			},
+		},
+	}
+}
+
+// findSessionByRef resolves a session by ID or, failing that, by name. A name
+// shared by several sessions is not guessed at: errAmbiguousSessionRef is
+// returned instead.
+func findSessionByRef(ctx context.Context, ref string) (models.Session, error) {
+	var session models.Session
+	if objectID, err := primitive.ObjectIDFromHex(ref); err == nil {
+		if err := sessionCollection.FindOne(ctx, notDeleted(bson.M{"_id": objectID})).Decode(&session); err == nil {
+			return session, nil
+		}
+	}
+	cursor, err := sessionCollection.Find(ctx, notDeleted(bson.M{"name": ref}), options.Find().SetLimit(2))
+	if err != nil {
+		return session, err
+	}
+	var sessions []models.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return session, err
+	}
+	switch len(sessions) {
+	case 0:
+		return session, mongo.ErrNoDocuments
+	case 1:
+		return sessions[0], nil
+	}
+	return session, errAmbiguousSessionRef
+}
+
+// slackMemberSession resolves a session reference for a Slack user. The
+// message is the reply to send when the session is unknown, ambiguous or not
+// one the user takes part in; the last two cases are not told apart so that
+// session names don't leak.
+func slackMemberSession(ctx context.Context, user authUser, ref string) (models.Session, slackMessage, bool) {
+	session, err := slackSessionLookup(ctx, ref)
+	switch {
+	case err == errAmbiguousSessionRef:
+		return session, slackText(fmt.Sprintf("Several sessions are named %s; use the session ID instead", ref)), false
+	case err == mongo.ErrNoDocuments || (err == nil && !isSessionMember(session, user)):
+		return session, slackText("Session not found: " + ref), false
+	case err != nil:
+		logFor(ctx).Error("Failed to look up session from Slack", "ref", ref, "error", err)
+		return session, slackText("Failed to look up session"), false
+	}
+	return session, slackMessage{}, true
+}
+
+// insertSlackSession stores a session created with /meeting start
+func insertSlackSession(ctx context.Context, session models.Session) error {
+	if _, err := sessionCollection.InsertOne(ctx, session); err != nil {
+		return err
+	}
+	emitWebhookEvent(ctx, session.ID, models.EventSessionCreated, session)
+	return nil
+}
+
+// findSessionsBetween returns the sessions scheduled or created in
+// [start, end), earliest first
+func findSessionsBetween(ctx context.Context, start, end time.Time) ([]models.Session, error) {
+	day := bson.M{"$gte": start, "$lt": end}
+	cursor, err := sessionCollection.Find(ctx,
+		notDeleted(bson.M{"$or": bson.A{bson.M{"scheduled_start": day}, bson.M{"createdat": day}}}),
+		options.Find().SetSort(bson.D{{Key: "scheduled_start", Value: 1}, {Key: "createdat", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []models.Session
+	err = cursor.All(ctx, &sessions)
+	return sessions, err
+}
+
+// findMinutes loads the minutes of a session
+func findMinutes(ctx context.Context, sessionID primitive.ObjectID) (models.Minutes, error) {
+	var minutes models.Minutes
+	err := minutesCollection.FindOne(ctx, bson.M{"session_id": sessionID}).Decode(&minutes)
+	return minutes, err
+}
+
+// findMeetingRecords loads the decisions and open action items of a session
+func findMeetingRecords(ctx context.Context, sessionID primitive.ObjectID) ([]models.Decision, []models.ActionItem) {
+	var decisions []models.Decision
+	if cursor, err := decisionCollection.Find(ctx, bson.M{"session_id": sessionID}); err == nil {
+		cursor.All(ctx, &decisions)
+	}
+	var items []models.ActionItem
+	if cursor, err := actionItemCollection.Find(ctx, bson.M{"session_id": sessionID, "status": models.ActionItemOpen}); err == nil {
+		cursor.All(ctx, &items)
+	}
+	return decisions, items
+}
+
+// appendSummary stores a summary on the session and announces it to the room.
+// The author is matched to a participant by username when possible.
+func appendSummary(ctx context.Context, session models.Session, username, content string) (models.Summary, error) {
+	summary := models.Summary{
+		ID:            primitive.NewObjectID(),
+		ParticipantID: primitive.NilObjectID,
+		Content:       content,
+		Comments:      []models.Comment{},
+		CreatedAt:     time.Now(),
+	}
+	update := bson.M{"$push": bson.M{"summaries": summary}}
+	filter := bson.M{"_id": session.ID}
+	if participant, ok := resolveAssignee(session, username); ok {
+		summary.ParticipantID = participant.ID
+		update = bson.M{
+			"$push": bson.M{"summaries": summary},
+			"$set":  bson.M{"participants.$.summarized": true},
+		}
+		filter["participants.username"] = participant.Username
+	}
+
+	if _, err := sessionCollection.UpdateOne(ctx, filter, update); err != nil {
+		return summary, err
+	}
+
+	message := map[string]interface{}{
+		"type":     "summarySubmitted",
+		"username": username,
+		"content":  content,
+	}
+	Broadcast(session.ID.Hex(), message)
+	emitWebhookEvent(ctx, session.ID, models.EventSummarySubmitted, map[string]interface{}{
+		"session_id": session.ID,
+		"summary":    message,
+	})
+	return summary, nil
+}
+
+// meetingResults summarizes a session for posting to chat
+func meetingResults(ctx context.Context, session models.Session) slackMessage {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s*\n", session.Name)
+	summaries := 0
+	for _, summary := range session.Summaries {
+		if summary.Content != "" {
+			summaries++
+		}
+	}
+	fmt.Fprintf(&b, "%d participants, %d summaries\n", len(session.Participants), summaries)
+
+	decisions, items := slackRecordsLookup(ctx, session.ID)
+	if len(decisions) > 0 {
+		b.WriteString("\n*Decisions*\n")
+		for _, decision := range decisions {
+			fmt.Fprintf(&b, "• %s\n", decision.Statement)
+		}
+	}
+
+	if len(items) > 0 {
+		b.WriteString("\n*Action items*\n")
+		for _, item := range items {
+			if item.AssigneeUsername != "" {
+				fmt.Fprintf(&b, "• @%s %s\n", item.AssigneeUsername, item.Title)
+			} else {
+				fmt.Fprintf(&b, "• %s\n", item.Title)
+			}
+		}
+	}
+	fmt.Fprintf(&b, "\n<%s|Open in browser>", sessionLink(session.ID))
+
+	return slackMessage{
+		ResponseType: "in_channel",
+		Text:         "Meeting results: " + session.Name,
+		Blocks:       []interface{}{slackSection(b.String())},
+	}
+}
+
+// postSlackWebhook posts a message to an incoming webhook URL
+func postSlackWebhook(webhookURL string, message slackMessage) error {
+	if webhookURL == "" {
+		return errors.New("no webhook URL")
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// postMeetingResults posts the results of a finished meeting to the
+// SLACK_WEBHOOK_URL channel, if configured
+func postMeetingResults(ctx context.Context, sessionID primitive.ObjectID) {
+	webhookURL := os.Getenv("SLACK_WEBHOOK_URL")
+	if webhookURL == "" {
+		return
+	}
+	var session models.Session
+	if err := sessionCollection.FindOne(ctx, notDeleted(bson.M{"_id": sessionID})).Decode(&session); err != nil {
+		slackLog.Error("Failed to load session for Slack", "session_id", sessionID.Hex(), "error", err)
+		return
+	}
+	if err := postSlackWebhook(webhookURL, meetingResults(ctx, session)); err != nil {
+		slackLog.Error("Failed to post meeting results", "session_id", sessionID.Hex(), "error", err)
+	}
+}
+
+const slackHelp = "Usage:\n" +
+	"• `/meeting start <name>` – create a session\n" +
+	"• `/meeting today` – list today's sessions\n" +
+	"• `/meeting summary <session> <text>` – post your summary\n" +
+	"• `/meeting minutes <session>` – show the minutes\n" +
+	"• `/meeting link` – link your Slack account to your meeting account\n" +
+	"`<session>` is a session ID or name; quote names with spaces."
+
+// splitSlackArgs splits command text on spaces, keeping "quoted phrases"
+// together
+func splitSlackArgs(text string) []string {
+	var args []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range strings.TrimSpace(text) {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if current.Len() > 0 {
+				args = append(args, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// runSlackCommand executes the text of a /meeting slash command for the user
+// linked to the Slack account
+func runSlackCommand(ctx context.Context, user authUser, text string) slackMessage {
+	username := user.Username
+	args := splitSlackArgs(text)
+	if len(args) == 0 {
+		return slackText(slackHelp)
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "start":
+		if len(args) < 2 {
+			return slackText("Usage: `/meeting start <name>`")
+		}
+		now := slackNow()
+		session := models.Session{
+			ID:           primitive.NewObjectID(),
+			Name:         strings.Join(args[1:], " "),
+			CreatedAt:    now,
+			Participants: []models.Participant{},
+			Summaries: []models.Summary{{
+				ID:            primitive.NewObjectID(),
+				ParticipantID: primitive.NilObjectID,
+				Comments:      []models.Comment{},
+				CreatedAt:     now,
+			}},
+			Agenda:            []models.AgendaItem{},
+			CurrentAgendaItem: primitive.NilObjectID,
+			SeriesID:          primitive.NilObjectID,
+			FacilitatorID:     user.GitHubID,
+		}
+		session.Status = timelineStatus(session)
+		session.Participants = append(session.Participants, models.Participant{
+			ID:        primitive.NewObjectID(),
+			Username:  user.Username,
+			AvatarURL: user.AvatarURL,
+		})
+		if err := slackSessionInsert(ctx, session); err != nil {
+			logFor(ctx).Error("Failed to create session from Slack", "username", username, "error", err)
+			return slackText("Failed to create session")
+		}
+
+		return slackMessage{
+			ResponseType: "in_channel",
+			Text:         fmt.Sprintf("%s started %s", username, session.Name),
+			Blocks: []interface{}{
+				slackSection(fmt.Sprintf("*%s* started by %s\n`%s`", session.Name, username, session.ID.Hex())),
+				slackSessionActions(session),
+			},
+		}
+
+	case "today":
+		now := slackNow()
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		found, err := slackSessionsBetween(ctx, start, start.AddDate(0, 0, 1))
+		if err != nil {
+			return slackText("Failed to fetch sessions")
+		}
+		// 只列出用户参与的会议
+		var sessions []models.Session
+		for _, session := range found {
+			if isSessionMember(session, user) {
+				sessions = append(sessions, session)
+			}
+		}
+		if len(sessions) == 0 {
+			return slackText("No sessions today.")
+		}
+		var b strings.Builder
+		b.WriteString("*Today's sessions*\n")
+		for _, session := range sessions {
+			when := session.CreatedAt
+			if session.ScheduledStart != nil {
+				when = *session.ScheduledStart
+			}
+			fmt.Fprintf(&b, "• %s <%s|%s> `%s`\n", when.In(now.Location()).Format("15:04"), sessionLink(session.ID), session.Name, session.ID.Hex())
+		}
+		return slackMessage{ResponseType: "ephemeral", Text: "Today's sessions", Blocks: []interface{}{slackSection(b.String())}}
+
+	case "summary":
+		if len(args) < 3 {
+			return slackText("Usage: `/meeting summary <session> <text>`")
+		}
+		session, reply, ok := slackMemberSession(ctx, user, args[1])
+		if !ok {
+			return reply
+		}
+		if _, err := slackSummaryAppend(ctx, session, username, strings.Join(args[2:], " ")); err != nil {
+			logFor(ctx).Error("Failed to save summary from Slack", "username", username, "error", err)
+			return slackText("Failed to save summary")
+		}
+		return slackText("Summary posted to " + session.Name)
+
+	case "minutes":
+		if len(args) < 2 {
+			return slackText("Usage: `/meeting minutes <session>`")
+		}
+		session, reply, ok := slackMemberSession(ctx, user, args[1])
+		if !ok {
+			return reply
+		}
+		return minutesMessage(ctx, session)
+	}
+
+	return slackText(slackHelp)
+}
+
+func minutesMessage(ctx context.Context, session models.Session) slackMessage {
+	minutes, err := slackMinutesLookup(ctx, session.ID)
+	if err != nil || strings.TrimSpace(minutes.Content) == "" {
+		return slackText("No minutes yet for " + session.Name)
+	}
+	// Slack 单个 section 最多 3000 字符
+	content := truncateSlackText(stripActionItemMarkers(minutes.Content), 2900)
+	return slackMessage{
+		ResponseType: "ephemeral",
+		Text:         "Minutes: " + session.Name,
+		Blocks:       []interface{}{slackSection(fmt.Sprintf("*Minutes: %s*\n%s", session.Name, content))},
+	}
+}
+
+// truncateSlackText shortens text to at most max bytes plus an ellipsis,
+// cutting on a rune boundary
+func truncateSlackText(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "…"
+}
+
+// SlackCommandHandler handles the /meeting slash command
+func SlackCommandHandler(w http.ResponseWriter, r *http.Request) {
+	form, ok := readSlackForm(w, r)
+	if !ok {
+		return
+	}
+	teamID, slackUserID := form.Get("team_id"), form.Get("user_id")
+	if strings.EqualFold(strings.TrimSpace(form.Get("text")), "link") {
+		writeSlackMessage(w, slackLinkMessage(teamID, slackUserID))
+		return
+	}
+	user, reply, ok := slackUser(r.Context(), teamID, slackUserID)
+	if !ok {
+		writeSlackMessage(w, reply)
+		return
+	}
+	writeSlackMessage(w, runSlackCommand(r.Context(), user, form.Get("text")))
+}
+
+// SlackInteractivityHandler handles button clicks on messages posted by the
+// slash command
+func SlackInteractivityHandler(w http.ResponseWriter, r *http.Request) {
+	form, ok := readSlackForm(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Type string `json:"type"`
+		User struct {
+			ID     string `json:"id"`
+			TeamID string `json:"team_id"`
+		} `json:"user"`
+		Team struct {
+			ID string `json:"id"`
+		} `json:"team"`
+		ResponseURL string `json:"response_url"`
+		Actions     []struct {
+			ActionID string `json:"action_id"`
+			Value    string `json:"value"`
+		} `json:"actions"`
+	}
+	if err := json.Unmarshal([]byte(form.Get("payload")), &payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Type != "block_actions" || len(payload.Actions) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	teamID := payload.Team.ID
+	if teamID == "" {
+		teamID = payload.User.TeamID
+	}
+	message, ok := slackInteraction(r.Context(), teamID, payload.User.ID, payload.Actions[0].ActionID, payload.Actions[0].Value)
+	if !ok {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// 交互请求的回复要通过 response_url 发送
+	if payload.ResponseURL != "" {
+		if err := postSlackWebhook(payload.ResponseURL, message); err != nil {
+			logFor(r.Context()).Error("Failed to reply to Slack interaction", "error", err)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// slackInteraction builds the reply to a button click. ok is false for
+// actions that need no reply.
+func slackInteraction(ctx context.Context, teamID, slackUserID, actionID, value string) (slackMessage, bool) {
+	if actionID != "minutes" && actionID != "post_results" {
+		return slackMessage{}, false
+	}
+	user, reply, ok := slackUser(ctx, teamID, slackUserID)
+	if !ok {
+		return reply, true
+	}
+	session, reply, ok := slackMemberSession(ctx, user, value)
+	if !ok {
+		return reply, true
+	}
+	if actionID == "minutes" {
+		return minutesMessage(ctx, session), true
+	}
+	return meetingResults(ctx, session), true
+}
@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"your-project/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// slackFixture is a request recorded from Slack, signed with
+// testSlackSecret at its timestamp
+type slackFixture struct {
+	Timestamp string `json:"timestamp"`
+	Signature string `json:"signature"`
+	Body      string `json:"body"`
+}
+
+const testSlackSecret = "test-signing-secret"
+
+func loadSlackFixture(t *testing.T, name string) slackFixture {
+	t.Helper()
+	data, err := os.ReadFile("testdata/slack/" + name + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture slackFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		t.Fatal(err)
+	}
+	return fixture
+}
+
+func (f slackFixture) request(path string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(f.Body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", f.Timestamp)
+	req.Header.Set("X-Slack-Signature", f.Signature)
+	return req
+}
+
+// setupSlack pins the clock to the fixtures' timestamp and links only the
+// given Slack users
+func setupSlack(t *testing.T, linked map[string]authUser) {
+	t.Helper()
+	t.Setenv("SLACK_SIGNING_SECRET", testSlackSecret)
+	t.Setenv("FRONTEND_URL", "https://meetings.example.com")
+	now, lookup := slackNow, slackUserLookup
+	t.Cleanup(func() { slackNow, slackUserLookup = now, lookup })
+
+	slackNow = func() time.Time { return time.Unix(1760000030, 0) }
+	slackUserLookup = func(ctx context.Context, teamID, slackUserID string) (authUser, error) {
+		if user, ok := linked[teamID+"/"+slackUserID]; ok {
+			return user, nil
+		}
+		return authUser{}, mongo.ErrNoDocuments
+	}
+}
+
+// slackStore is an in-memory stand-in for the storage of the Slack commands
+type slackStore struct {
+	sessions  []models.Session
+	inserted  []models.Session
+	summaries []string
+	minutes   map[primitive.ObjectID]string
+	decisions map[primitive.ObjectID][]models.Decision
+}
+
+// setupSlackStore serves the Slack commands from the given sessions. A
+// session is found by ID or name, like findSessionByRef does.
+func setupSlackStore(t *testing.T, sessions ...models.Session) *slackStore {
+	t.Helper()
+	s := &slackStore{
+		sessions:  sessions,
+		minutes:   map[primitive.ObjectID]string{},
+		decisions: map[primitive.ObjectID][]models.Decision{},
+	}
+	lookup, insert, between := slackSessionLookup, slackSessionInsert, slackSessionsBetween
+	summary, minutes, records := slackSummaryAppend, slackMinutesLookup, slackRecordsLookup
+	t.Cleanup(func() {
+		slackSessionLookup, slackSessionInsert, slackSessionsBetween = lookup, insert, between
+		slackSummaryAppend, slackMinutesLookup, slackRecordsLookup = summary, minutes, records
+	})
+
+	slackSessionLookup = func(ctx context.Context, ref string) (models.Session, error) {
+		var found []models.Session
+		for _, session := range s.sessions {
+			if session.ID.Hex() == ref {
+				return session, nil
+			}
+			if session.Name == ref {
+				found = append(found, session)
+			}
+		}
+		switch len(found) {
+		case 0:
+			return models.Session{}, mongo.ErrNoDocuments
+		case 1:
+			return found[0], nil
+		}
+		return models.Session{}, errAmbiguousSessionRef
+	}
+	slackSessionInsert = func(ctx context.Context, session models.Session) error {
+		s.inserted = append(s.inserted, session)
+		return nil
+	}
+	slackSessionsBetween = func(ctx context.Context, start, end time.Time) ([]models.Session, error) {
+		return s.sessions, nil
+	}
+	slackSummaryAppend = func(ctx context.Context, session models.Session, username, content string) (models.Summary, error) {
+		s.summaries = append(s.summaries, session.Name+"/"+username+": "+content)
+		return models.Summary{Content: content}, nil
+	}
+	slackMinutesLookup = func(ctx context.Context, sessionID primitive.ObjectID) (models.Minutes, error) {
+		content, ok := s.minutes[sessionID]
+		if !ok {
+			return models.Minutes{}, mongo.ErrNoDocuments
+		}
+		return models.Minutes{SessionID: sessionID, Content: content}, nil
+	}
+	slackRecordsLookup = func(ctx context.Context, sessionID primitive.ObjectID) ([]models.Decision, []models.ActionItem) {
+		return s.decisions[sessionID], nil
+	}
+	return s
+}
+
+// runSlackFixture sends a recorded slash command and returns the reply
+func runSlackFixture(t *testing.T, name string) slackMessage {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	SlackCommandHandler(rec, loadSlackFixture(t, name).request("/api/integrations/slack/commands"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	return decodeSlackMessage(t, rec.Body)
+}
+
+// slackReplyText joins the text and section blocks of a reply
+func slackReplyText(message slackMessage) string {
+	text := message.Text
+	for _, block := range message.Blocks {
+		if section, ok := block.(map[string]interface{}); ok {
+			if inner, ok := section["text"].(map[string]interface{}); ok {
+				text += "\n" + inner["text"].(string)
+			}
+		}
+	}
+	return text
+}
+
+// testSlackSessionID is the session the recorded minutes and post_results
+// requests refer to
+var testSlackSessionID, _ = primitive.ObjectIDFromHex("64b7f0c2e4b0a1a2b3c4d5e6")
+
+var testSlackAlice = authUser{GitHubID: 42, Username: "alice", AvatarURL: "https://avatars.example.com/alice"}
+
+func decodeSlackMessage(t *testing.T, body io.Reader) slackMessage {
+	t.Helper()
+	var message slackMessage
+	if err := json.NewDecoder(body).Decode(&message); err != nil {
+		t.Fatalf("decode reply: %v", err)
+	}
+	return message
+}
+
+// linkToken extracts and checks the token of the link in a reply
+func linkToken(t *testing.T, text string) (teamID, slackUserID string) {
+	t.Helper()
+	start := strings.Index(text, "<https://meetings.example.com/api/integrations/slack/link?")
+	end := strings.Index(text, "|")
+	if start < 0 || end < start {
+		t.Fatalf("reply has no link: %q", text)
+	}
+	link, err := url.Parse(text[start+1 : end])
+	if err != nil {
+		t.Fatal(err)
+	}
+	teamID, slackUserID, err = parseSlackLink(testSlackSecret, link.Query().Get("token"), slackNow())
+	if err != nil {
+		t.Fatalf("link token: %v", err)
+	}
+	return teamID, slackUserID
+}
+
+func TestVerifySlackSignatureAgainstSlackExample(t *testing.T) {
+	// Slack 文档中的签名示例
+	body := "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+	secret := "8f742231b10e8888abcd99yyyzzz85a5"
+	signature := "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+	now := time.Unix(1531420618, 0)
+
+	if err := verifySlackSignature(secret, "1531420618", signature, []byte(body), now); err != nil {
+		t.Errorf("valid signature rejected: %v", err)
+	}
+	if err := verifySlackSignature(secret, "1531420618", signature, []byte(body+"x"), now); err == nil {
+		t.Error("signature of a modified body accepted")
+	}
+	if err := verifySlackSignature(secret, "1531420618", signature, []byte(body), now.Add(6*time.Minute)); err == nil {
+		t.Error("stale request accepted")
+	}
+}
+
+func TestSlackCommandFromLinkedUser(t *testing.T) {
+	setupSlack(t, map[string]authUser{"T0ACME01/U0ALICE1": {Username: "alice"}})
+
+	rec := httptest.NewRecorder()
+	SlackCommandHandler(rec, loadSlackFixture(t, "command_help").request("/api/integrations/slack/commands"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if reply := decodeSlackMessage(t, rec.Body); reply.Text != slackHelp {
+		t.Errorf("reply = %q, want the help text", reply.Text)
+	}
+}
+
+func TestSlackCommandFromUnlinkedUserIsRejected(t *testing.T) {
+	// U0MALLORY 把 Slack 显示名改成了 alice
+	setupSlack(t, map[string]authUser{"T0ACME01/U0ALICE1": {Username: "alice"}})
+
+	rec := httptest.NewRecorder()
+	SlackCommandHandler(rec, loadSlackFixture(t, "command_unlinked").request("/api/integrations/slack/commands"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	reply := decodeSlackMessage(t, rec.Body)
+	if reply.ResponseType != "ephemeral" {
+		t.Errorf("response_type = %q, want ephemeral", reply.ResponseType)
+	}
+	if teamID, slackUserID := linkToken(t, reply.Text); teamID != "T0ACME01" || slackUserID != "U0MALLORY" {
+		t.Errorf("link is for %s/%s, want T0ACME01/U0MALLORY", teamID, slackUserID)
+	}
+}
+
+func TestSlackCommandWithBadSignatureIsRejected(t *testing.T) {
+	setupSlack(t, nil)
+
+	fixture := loadSlackFixture(t, "command_help")
+	fixture.Body = strings.Replace(fixture.Body, "text=help", "text=start+hijack", 1)
+	rec := httptest.NewRecorder()
+	SlackCommandHandler(rec, fixture.request("/api/integrations/slack/commands"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+
+	slackNow = func() time.Time { return time.Unix(1760000000, 0).Add(time.Hour) }
+	rec = httptest.NewRecorder()
+	SlackCommandHandler(rec, loadSlackFixture(t, "command_help").request("/api/integrations/slack/commands"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("replayed request: status = %d, want 401", rec.Code)
+	}
+}
+
+// roundTripFunc lets a test answer the requests of an http.Client
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestSlackInteractionFromUnlinkedUserIsRejected(t *testing.T) {
+	setupSlack(t, nil)
+
+	var posted []*http.Request
+	var replies []slackMessage
+	transport := webhookClient.Transport
+	t.Cleanup(func() { webhookClient.Transport = transport })
+	webhookClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		posted = append(posted, r)
+		replies = append(replies, decodeSlackMessage(t, r.Body))
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader("ok")), Request: r}, nil
+	})
+
+	rec := httptest.NewRecorder()
+	SlackInteractivityHandler(rec, loadSlackFixture(t, "interactivity_unlinked").request("/api/integrations/slack/interactivity"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if len(posted) != 1 {
+		t.Fatalf("posted %d replies, want 1", len(posted))
+	}
+	if got := posted[0].URL.String(); got != "https://hooks.slack.com/actions/T0ACME01/1234567890/abcdefABCDEF" {
+		t.Errorf("reply posted to %s", got)
+	}
+	if teamID, slackUserID := linkToken(t, replies[0].Text); teamID != "T0ACME01" || slackUserID != "U0MALLORY" {
+		t.Errorf("link is for %s/%s, want T0ACME01/U0MALLORY", teamID, slackUserID)
+	}
+}
+
+func TestSlackLinkTokens(t *testing.T) {
+	now := time.Unix(1760000000, 0)
+	token := signSlackLink(testSlackSecret, "T0ACME01", "U0ALICE1", now.Add(slackLinkTTL))
+
+	if _, _, err := parseSlackLink(testSlackSecret, token, now.Add(slackLinkTTL+time.Second)); err == nil {
+		t.Error("expired token accepted")
+	}
+	if _, _, err := parseSlackLink("other-secret", token, now); err == nil {
+		t.Error("token signed with another secret accepted")
+	}
+	forged := strings.Replace(token, "U0ALICE1", "U0MALLORY", 1)
+	if _, _, err := parseSlackLink(testSlackSecret, forged, now); err == nil {
+		t.Error("token for another user accepted")
+	}
+}
+
+func TestTruncateSlackTextKeepsRunesWhole(t *testing.T) {
+	text := strings.Repeat("会", 1000) // 3000 bytes
+	got := truncateSlackText(text, 2900)
+	if !strings.HasSuffix(got, "…") || len(got) > 2900+len("…") {
+		t.Errorf("len = %d", len(got))
+	}
+	if trimmed := strings.TrimSuffix(got, "…"); trimmed != strings.Repeat("会", 966) {
+		t.Errorf("cut inside a rune: %q", trimmed[len(trimmed)-3:])
+	}
+	if got := truncateSlackText("short", 2900); got != "short" {
+		t.Errorf("short text changed to %q", got)
+	}
+}
+
+func TestSlackStartCreatesDraftSession(t *testing.T) {
+	setupSlack(t, map[string]authUser{"T0ACME01/U0ALICE1": testSlackAlice})
+	store := setupSlackStore(t)
+
+	reply := runSlackFixture(t, "command_start")
+	if len(store.inserted) != 1 {
+		t.Fatalf("created %d sessions, want 1", len(store.inserted))
+	}
+	session := store.inserted[0]
+	if session.Name != "Sprint review" || session.Status != models.SessionDraft || session.FacilitatorID != 42 {
+		t.Errorf("created %q with status %q and facilitator %d", session.Name, session.Status, session.FacilitatorID)
+	}
+	if len(session.Participants) != 1 || session.Participants[0].Username != "alice" {
+		t.Errorf("participants = %+v, want alice", session.Participants)
+	}
+	if reply.ResponseType != "in_channel" || !strings.Contains(slackReplyText(reply), session.ID.Hex()) {
+		t.Errorf("reply = %+v", reply)
+	}
+}
+
+func TestSlackTodayListsOwnSessions(t *testing.T) {
+	setupSlack(t, map[string]authUser{"T0ACME01/U0ALICE1": testSlackAlice})
+	setupSlackStore(t,
+		models.Session{ID: primitive.NewObjectID(), Name: "Sprint review", FacilitatorID: 42, CreatedAt: slackNow()},
+		models.Session{ID: primitive.NewObjectID(), Name: "Board meeting", FacilitatorID: 7, CreatedAt: slackNow()},
+	)
+
+	text := slackReplyText(runSlackFixture(t, "command_today"))
+	if !strings.Contains(text, "Sprint review") {
+		t.Errorf("own session missing: %q", text)
+	}
+	if strings.Contains(text, "Board meeting") {
+		t.Errorf("other user's session listed: %q", text)
+	}
+}
+
+func TestSlackSummaryNeedsMembership(t *testing.T) {
+	setupSlack(t, map[string]authUser{"T0ACME01/U0ALICE1": testSlackAlice})
+
+	member := models.Session{ID: primitive.NewObjectID(), Name: "Sprint review", Participants: []models.Participant{{Username: "Alice"}}}
+	store := setupSlackStore(t, member)
+	if reply := runSlackFixture(t, "command_summary"); reply.Text != "Summary posted to Sprint review" {
+		t.Errorf("reply = %q", reply.Text)
+	}
+	if len(store.summaries) != 1 || store.summaries[0] != "Sprint review/alice: Shipped the importer" {
+		t.Errorf("summaries = %q", store.summaries)
+	}
+
+	store = setupSlackStore(t, models.Session{ID: primitive.NewObjectID(), Name: "Sprint review", FacilitatorID: 7})
+	if reply := runSlackFixture(t, "command_summary"); reply.Text != "Session not found: Sprint review" {
+		t.Errorf("non-member: reply = %q", reply.Text)
+	}
+	if len(store.summaries) != 0 {
+		t.Errorf("non-member posted a summary: %q", store.summaries)
+	}
+
+	// 同名会议不再默认选最新的那个
+	store = setupSlackStore(t, member, models.Session{ID: primitive.NewObjectID(), Name: "Sprint review", FacilitatorID: 42})
+	if reply := runSlackFixture(t, "command_summary"); !strings.Contains(reply.Text, "Several sessions are named Sprint review") {
+		t.Errorf("ambiguous name: reply = %q", reply.Text)
+	}
+	if len(store.summaries) != 0 {
+		t.Errorf("summary posted to a guessed session: %q", store.summaries)
+	}
+}
+
+func TestSlackMinutesNeedMembership(t *testing.T) {
+	setupSlack(t, map[string]authUser{"T0ACME01/U0ALICE1": testSlackAlice})
+
+	store := setupSlackStore(t, models.Session{ID: testSlackSessionID, Name: "Sprint review", FacilitatorID: 42})
+	store.minutes[testSlackSessionID] = "Release on Friday"
+	if text := slackReplyText(runSlackFixture(t, "command_minutes")); !strings.Contains(text, "Release on Friday") {
+		t.Errorf("minutes missing: %q", text)
+	}
+
+	store = setupSlackStore(t, models.Session{ID: testSlackSessionID, Name: "Board meeting", FacilitatorID: 7})
+	store.minutes[testSlackSessionID] = "Acquisition terms"
+	text := slackReplyText(runSlackFixture(t, "command_minutes"))
+	if strings.Contains(text, "Acquisition terms") || !strings.Contains(text, "Session not found") {
+		t.Errorf("non-member: reply = %q", text)
+	}
+}
+
+func TestSlackPostResultsFromLinkedUser(t *testing.T) {
+	setupSlack(t, map[string]authUser{"T0ACME01/U0ALICE1": testSlackAlice})
+
+	var posted []slackMessage
+	transport := webhookClient.Transport
+	t.Cleanup(func() { webhookClient.Transport = transport })
+	webhookClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if got := r.URL.String(); got != "https://hooks.slack.com/actions/T0ACME01/1234567890/abcdefABCDEF" {
+			t.Errorf("reply posted to %s", got)
+		}
+		posted = append(posted, decodeSlackMessage(t, r.Body))
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader("ok")), Request: r}, nil
+	})
+	click := func() {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		SlackInteractivityHandler(rec, loadSlackFixture(t, "interactivity_post_results").request("/api/integrations/slack/interactivity"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	store := setupSlackStore(t, models.Session{ID: testSlackSessionID, Name: "Sprint review", FacilitatorID: 42})
+	store.decisions[testSlackSessionID] = []models.Decision{{Statement: "Ship the importer"}}
+	click()
+	if len(posted) != 1 {
+		t.Fatalf("posted %d replies, want 1", len(posted))
+	}
+	if posted[0].ResponseType != "in_channel" || !strings.Contains(slackReplyText(posted[0]), "• Ship the importer") {
+		t.Errorf("results = %+v", posted[0])
+	}
+
+	store = setupSlackStore(t, models.Session{ID: testSlackSessionID, Name: "Board meeting", FacilitatorID: 7})
+	store.decisions[testSlackSessionID] = []models.Decision{{Statement: "Accept the offer"}}
+	click()
+	if len(posted) != 2 {
+		t.Fatalf("posted %d replies, want 2", len(posted))
+	}
+	if text := slackReplyText(posted[1]); posted[1].ResponseType == "in_channel" || strings.Contains(text, "Accept the offer") {
+		t.Errorf("non-member posted results: %+v", posted[1])
+	}
+}
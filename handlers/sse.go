@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 代理通常会断开长时间没有数据的连接
+const sseHeartbeatInterval = 15 * time.Second
+
+var errStreamClosed = errors.New("event stream closed")
+
+type streamFrame struct {
+	seq  int64
+	data []byte
+}
+
+// eventStream queues the messages of a client until its writer sends them:
+// the request goroutine of a Server-Sent Events subscriber, or writeLoop of a
+// WebSocket. A client that falls too far behind is disconnected and resumes
+// from the last event it got.
+type eventStream struct {
+	frames chan streamFrame
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{
+		// 足够放下一次完整的回放
+		frames: make(chan streamFrame, eventLogSize()+32),
+		done:   make(chan struct{}),
+	}
+}
+
+func (stream *eventStream) push(seq int64, data []byte) error {
+	select {
+	case <-stream.done:
+		return errStreamClosed
+	default:
+	}
+	select {
+	case stream.frames <- streamFrame{seq, data}:
+		return nil
+	default:
+		stream.close()
+		return errors.New("event stream is too slow")
+	}
+}
+
+func (stream *eventStream) close() {
+	stream.once.Do(func() { close(stream.done) })
+}
+
+// parseEventID splits an event ID of the form epoch:seq
+func parseEventID(id string) (string, int64, bool) {
+	epoch, seq, ok := strings.Cut(id, ":")
+	if !ok {
+		return "", 0, false
+	}
+	n, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil || n < 0 {
+		return "", 0, false
+	}
+	return epoch, n, true
+}
+
+// SessionEventsHandler streams the room events of a session as Server-Sent
+// Events, for browsers that can't keep a WebSocket open. Subscribers join the
+// same room as WebSocket clients and send their actions to
+// SessionActionHandler.
+func SessionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !sessionLive(r.Context(), mux.Vars(r)["sessionId"]) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	client := &MeetingClient{
+		sessionID: mux.Vars(r)["sessionId"],
+		userID:    user.GitHubID,
+		username:  user.Username,
+		avatarURL: user.AvatarURL,
+		id:        hex.EncodeToString(buf),
+		stream:    newEventStream(),
+	}
+	client.logger = clientLogger(r, client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// 关闭 nginx 的响应缓冲
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// EventSource 重连时会带上 Last-Event-ID，错过的事件在加入房间前排好队
+	var resume *resumePoint
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	lastEpoch, lastSeq, validEventID := parseEventID(lastEventID)
+	if validEventID {
+		resume = &resumePoint{epoch: lastEpoch, lastSeq: lastSeq}
+	}
+
+	welcome := map[string]interface{}{
+		"type":     "connected",
+		"message":  "Successfully connected to session",
+		"clientId": client.id,
+	}
+	enterRoom(client, welcome, resume)
+	epoch := welcome["epoch"].(string)
+	if lastEventID != "" && !validEventID {
+		sendError(client, "resume", "Invalid Last-Event-ID")
+	}
+	broadcastParticipantsList(client.sessionID)
+	defer func() {
+		client.stream.close()
+		leaveRoom(client)
+	}()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case frame := <-client.stream.frames:
+			if frame.seq > 0 {
+				fmt.Fprintf(w, "id: %s:%d\n", epoch, frame.seq)
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", frame.data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			touchPresence(client)
+		case <-client.stream.done:
+			client.log().Warn("Closing slow event stream")
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// SessionActionHandler takes the actions an event stream subscriber would
+// otherwise send over the WebSocket, such as votes or raising a hand. The
+// X-Client-ID header names the stream, which receives any error replies.
+func SessionActionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	clientID := r.Header.Get("X-Client-ID")
+	var client *MeetingClient
+	for _, c := range roomClients(mux.Vars(r)["sessionId"]) {
+		if c.id != "" && c.id == clientID && c.userID == user.GitHubID {
+			client = c
+			break
+		}
+	}
+	if client == nil {
+		http.Error(w, "Event stream not found", http.StatusNotFound)
+		return
+	}
+
+	var msg map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if _, ok := msg["type"].(string); !ok {
+		http.Error(w, "Message type is required", http.StatusBadRequest)
+		return
+	}
+
+	touchPresence(client)
+	handleWebSocketMessage(client, msg)
+	w.WriteHeader(http.StatusAccepted)
+}
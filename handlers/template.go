@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var templateCollection *mongo.Collection
+
+// 星级评分默认 1 到 10
+const (
+	defaultMaxStars = 10
+	maxMaxStars     = 10
+)
+
+func InitTemplateCollection(client *mongo.Client) {
+	templateCollection = client.Database("your-db-name").Collection("session_templates")
+}
+
+// sessionMaxStars returns the top of the star scale of a session
+func sessionMaxStars(session models.Session) int {
+	if session.MaxStars > 0 {
+		return session.MaxStars
+	}
+	return defaultMaxStars
+}
+
+type templateInput struct {
+	Name              string                      `json:"name"`
+	Description       string                      `json:"description"`
+	Agenda            []models.TemplateAgendaItem `json:"agenda"`
+	SpeakerTimebox    int                         `json:"speaker_timebox"`
+	AnonymousFeedback bool                        `json:"anonymous_feedback"`
+	MaxStars          int                         `json:"max_stars"`
+	MinutesSkeleton   string                      `json:"minutes_skeleton"`
+	Participants      []string                    `json:"participants"`
+}
+
+// apply validates the input and copies it onto the template
+func (input templateInput) apply(template *models.SessionTemplate) string {
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		return "Name is required"
+	}
+	if input.SpeakerTimebox < 0 {
+		return "speaker_timebox must not be negative"
+	}
+	if input.MaxStars < 0 || input.MaxStars > maxMaxStars {
+		return "max_stars must be between 1 and 10"
+	}
+
+	agenda := make([]models.TemplateAgendaItem, 0, len(input.Agenda))
+	for _, item := range input.Agenda {
+		item.Title = strings.TrimSpace(item.Title)
+		if item.Title == "" {
+			return "Agenda items need a title"
+		}
+		if item.AllottedMinutes < 0 {
+			return "allotted_minutes must not be negative"
+		}
+		item.Owner = strings.TrimPrefix(strings.TrimSpace(item.Owner), "@")
+		agenda = append(agenda, item)
+	}
+	participants := []string{}
+	seen := make(map[string]bool)
+	for _, username := range input.Participants {
+		username = strings.TrimPrefix(strings.TrimSpace(username), "@")
+		if username != "" && !seen[strings.ToLower(username)] {
+			seen[strings.ToLower(username)] = true
+			participants = append(participants, username)
+		}
+	}
+
+	template.Name = input.Name
+	template.Description = strings.TrimSpace(input.Description)
+	template.Agenda = agenda
+	template.SpeakerTimebox = input.SpeakerTimebox
+	template.AnonymousFeedback = input.AnonymousFeedback
+	template.MaxStars = input.MaxStars
+	template.MinutesSkeleton = input.MinutesSkeleton
+	template.Participants = participants
+	return ""
+}
+
+// applyTemplate fills in what a new session did not set itself from the
+// template. Participants of both are kept.
+func applyTemplate(ctx context.Context, session *models.Session, template models.SessionTemplate) error {
+	session.TemplateID = template.ID
+	if strings.TrimSpace(session.Name) == "" {
+		session.Name = template.Name
+	}
+	if len(session.Agenda) == 0 {
+		for _, item := range template.Agenda {
+			session.Agenda = append(session.Agenda, models.AgendaItem{
+				Title:           item.Title,
+				Owner:           item.Owner,
+				AllottedMinutes: item.AllottedMinutes,
+				Notes:           item.Notes,
+			})
+		}
+	}
+	if session.SpeakerTimebox == 0 {
+		session.SpeakerTimebox = template.SpeakerTimebox
+	}
+	if session.MaxStars == 0 {
+		session.MaxStars = template.MaxStars
+	}
+	session.AnonymousFeedback = session.AnonymousFeedback || template.AnonymousFeedback
+
+	present := make(map[string]bool)
+	for _, participant := range session.Participants {
+		present[strings.ToLower(participant.Username)] = true
+	}
+	var missing []string
+	for _, username := range template.Participants {
+		if !present[strings.ToLower(username)] {
+			missing = append(missing, username)
+		}
+	}
+	users, err := findUsersByUsername(ctx, missing)
+	if err != nil {
+		return err
+	}
+	for _, username := range missing {
+		participant := models.Participant{ID: primitive.NewObjectID(), Username: username}
+		if user, ok := users[username]; ok {
+			participant.AvatarURL = user.AvatarURL
+		}
+		session.Participants = append(session.Participants, participant)
+	}
+	return nil
+}
+
+// createTemplateMinutes stores the minutes skeleton of the template as the
+// first version of the session's minutes
+func createTemplateMinutes(ctx context.Context, session models.Session, template models.SessionTemplate) error {
+	if strings.TrimSpace(template.MinutesSkeleton) == "" {
+		return nil
+	}
+	content := template.MinutesSkeleton
+	if agenda := scaffoldMinutes(session.Agenda); agenda != "" {
+		content = strings.TrimRight(content, "\n") + "\n\n" + agenda
+	}
+	_, err := minutesCollection.InsertOne(ctx, models.Minutes{
+		ID:        primitive.NewObjectID(),
+		SessionID: session.ID,
+		Content:   content,
+		CreatedAt: session.CreatedAt,
+		UpdatedAt: session.CreatedAt,
+	})
+	return err
+}
+
+// loadEditableTemplate loads a template that the current user created or, as
+// a workspace admin, may manage
+func loadEditableTemplate(w http.ResponseWriter, r *http.Request) (models.SessionTemplate, bool) {
+	var template models.SessionTemplate
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return template, false
+	}
+	objectID, err := primitive.ObjectIDFromHex(mux.Vars(r)["templateId"])
+	if err != nil {
+		http.Error(w, "Invalid template ID", http.StatusBadRequest)
+		return template, false
+	}
+	if err := templateCollection.FindOne(r.Context(), bson.M{"_id": objectID}).Decode(&template); err != nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return template, false
+	}
+	if template.CreatedBy != user.Username && !isWorkspaceAdmin(*user) {
+		http.Error(w, "Only the creator or a workspace admin can change this template", http.StatusForbidden)
+		return template, false
+	}
+	return template, true
+}
+
+// GetTemplatesHandler lists the session templates of the workspace
+func GetTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentUser(r); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := templateCollection.Find(r.Context(), bson.M{}, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch templates", http.StatusInternalServerError)
+		return
+	}
+	templates := []models.SessionTemplate{}
+	if err := cursor.All(r.Context(), &templates); err != nil {
+		http.Error(w, "Failed to fetch templates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+// GetTemplateHandler returns a single template
+func GetTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentUser(r); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	objectID, err := primitive.ObjectIDFromHex(mux.Vars(r)["templateId"])
+	if err != nil {
+		http.Error(w, "Invalid template ID", http.StatusBadRequest)
+		return
+	}
+
+	var template models.SessionTemplate
+	if err := templateCollection.FindOne(r.Context(), bson.M{"_id": objectID}).Decode(&template); err != nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(template)
+}
+
+// CreateTemplateHandler adds a session template to the workspace
+func CreateTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input templateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	template := models.SessionTemplate{
+		ID:        primitive.NewObjectID(),
+		CreatedBy: user.Username,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if msg := input.apply(&template); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := templateCollection.InsertOne(r.Context(), template); err != nil {
+		logFor(r.Context()).Error("Failed to create template", "error", err)
+		http.Error(w, "Failed to create template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(template)
+}
+
+// UpdateTemplateHandler replaces a template. Sessions created from it earlier
+// are not changed.
+func UpdateTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	template, ok := loadEditableTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	var input templateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if msg := input.apply(&template); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	template.UpdatedAt = time.Now()
+
+	if _, err := templateCollection.ReplaceOne(r.Context(), bson.M{"_id": template.ID}, template); err != nil {
+		logFor(r.Context()).Error("Failed to update template", "template_id", template.ID.Hex(), "error", err)
+		http.Error(w, "Failed to update template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(template)
+}
+
+// DeleteTemplateHandler removes a template
+func DeleteTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	template, ok := loadEditableTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := templateCollection.DeleteOne(r.Context(), bson.M{"_id": template.ID}); err != nil {
+		http.Error(w, "Failed to delete template", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Template deleted successfully"})
+}
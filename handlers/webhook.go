@@ -0,0 +1,480 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"your-project/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	webhookCollection         *mongo.Collection
+	webhookDeliveryCollection *mongo.Collection
+
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+	// 有新事件时唤醒投递协程，不必等到下一次轮询
+	webhookWake = make(chan struct{}, 1)
+)
+
+const (
+	webhookMaxAttempts  = 8
+	webhookBaseBackoff  = 30 * time.Second
+	webhookLockTimeout  = 5 * time.Minute
+	webhookResponseSize = 2048
+)
+
+func InitWebhookCollections(client *mongo.Client) {
+	webhookCollection = client.Database("your-db-name").Collection("webhooks")
+	webhookDeliveryCollection = client.Database("your-db-name").Collection("webhook_deliveries")
+}
+
+// signWebhookPayload returns the value of the X-Webhook-Signature header.
+// The timestamp of X-Webhook-Timestamp is signed with the body, as
+// "<timestamp>.<body>", so receivers can reject replayed requests.
+func signWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// emitWebhookEvent queues a delivery of the event to every active webhook
+// subscribed to it.
+func emitWebhookEvent(ctx context.Context, sessionID primitive.ObjectID, event string, data interface{}) {
+	if webhookCollection == nil {
+		return
+	}
+
+	cursor, err := webhookCollection.Find(ctx, bson.M{"active": true, "events": event})
+	if err != nil {
+		webhookLog.Error("Failed to fetch webhooks", "event", event, "error", err)
+		return
+	}
+	var hooks []models.Webhook
+	if err := cursor.All(ctx, &hooks); err != nil {
+		webhookLog.Error("Failed to parse webhooks", "event", event, "error", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":         primitive.NewObjectID().Hex(),
+		"event":      event,
+		"created_at": now,
+		"data":       data,
+	})
+	if err != nil {
+		webhookLog.Error("Failed to encode payload", "event", event, "error", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		delivery := models.WebhookDelivery{
+			ID:            primitive.NewObjectID(),
+			WebhookID:     hook.ID,
+			SessionID:     sessionID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        models.DeliveryPending,
+			Attempts:      []models.DeliveryAttempt{},
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		if _, err := webhookDeliveryCollection.InsertOne(ctx, delivery); err != nil {
+			webhookLog.Error("Failed to queue delivery", "event", event, "webhook_id", hook.ID.Hex(), "error", err)
+		}
+	}
+	wakeWebhookWorker()
+}
+
+func wakeWebhookWorker() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// postWebhook sends one delivery and records the outcome of the request
+func postWebhook(hook models.Webhook, delivery models.WebhookDelivery) models.DeliveryAttempt {
+	attempt := models.DeliveryAttempt{At: time.Now()}
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "golangwebsite-webhooks")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID.Hex())
+	timestamp := attempt.At.Unix()
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", signWebhookPayload(hook.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := webhookClient.Do(req)
+	attempt.DurationMS = time.Since(attempt.At).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseSize))
+	attempt.ResponseCode = resp.StatusCode
+	attempt.ResponseBody = string(body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = resp.Status
+	}
+	return attempt
+}
+
+// deliverWebhooks sends due deliveries until none are left
+func deliverWebhooks(ctx context.Context) {
+	for {
+		now := time.Now()
+		var delivery models.WebhookDelivery
+		err := webhookDeliveryCollection.FindOneAndUpdate(ctx,
+			bson.M{"$or": bson.A{
+				bson.M{"status": models.DeliveryPending, "next_attempt_at": bson.M{"$lte": now}},
+				bson.M{"status": models.DeliverySending, "locked_at": bson.M{"$lt": now.Add(-webhookLockTimeout)}},
+			}},
+			bson.M{"$set": bson.M{"status": models.DeliverySending, "locked_at": now}},
+			options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetReturnDocument(options.After),
+		).Decode(&delivery)
+		if err == mongo.ErrNoDocuments {
+			return
+		} else if err != nil {
+			logFor(ctx).Error("Failed to claim webhook delivery", "error", err)
+			return
+		}
+
+		update := bson.M{}
+		var push bson.M
+		var hook models.Webhook
+		if err := webhookCollection.FindOne(ctx, bson.M{"_id": delivery.WebhookID}).Decode(&hook); err != nil {
+			// webhook 已被删除
+			update["status"] = models.DeliveryFailed
+		} else {
+			attempt := postWebhook(hook, delivery)
+			attempts := len(delivery.Attempts) + 1
+			push = bson.M{"attempts": attempt}
+			update["response_code"] = attempt.ResponseCode
+			if attempt.Error == "" {
+				update["status"] = models.DeliveryDelivered
+				update["delivered_at"] = time.Now()
+			} else if attempts >= webhookMaxAttempts {
+				update["status"] = models.DeliveryFailed
+				logFor(ctx).Error("Giving up on webhook delivery", "delivery_id", delivery.ID.Hex(), "url", hook.URL, "error", attempt.Error)
+			} else {
+				// 指数退避：30 秒, 1, 2, 4... 分钟
+				backoff := webhookBaseBackoff * time.Duration(math.Pow(2, float64(attempts-1)))
+				update["status"] = models.DeliveryPending
+				update["next_attempt_at"] = time.Now().Add(backoff)
+				logFor(ctx).Warn("Webhook delivery failed, retrying", "delivery_id", delivery.ID.Hex(), "url", hook.URL, "backoff", backoff.String(), "error", attempt.Error)
+			}
+		}
+
+		change := bson.M{"$set": update, "$unset": bson.M{"locked_at": ""}}
+		if push != nil {
+			change["$push"] = push
+		}
+		if _, err := webhookDeliveryCollection.UpdateOne(ctx, bson.M{"_id": delivery.ID}, change); err != nil {
+			logFor(ctx).Error("Failed to update webhook delivery", "delivery_id", delivery.ID.Hex(), "error", err)
+		}
+	}
+}
+
+// StartWebhookWorker delivers queued webhook events. It runs every interval
+// and whenever a new event is emitted.
+func StartWebhookWorker(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			deliverWebhooks(withLogger(context.Background(), webhookLog))
+			select {
+			case <-ticker.C:
+			case <-webhookWake:
+			}
+		}
+	}()
+}
+
+type webhookInput struct {
+	URL    *string   `json:"url"`
+	Secret *string   `json:"secret"`
+	Events *[]string `json:"events"`
+	Active *bool     `json:"active"`
+}
+
+// apply validates the input and copies it onto hook
+func (input webhookInput) apply(hook *models.Webhook) string {
+	if input.URL != nil {
+		hook.URL = strings.TrimSpace(*input.URL)
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "url must be an absolute http or https URL"
+	}
+
+	if input.Secret != nil {
+		hook.Secret = *input.Secret
+	}
+	if hook.Secret == "" {
+		hook.Secret = newWebhookSecret()
+	}
+
+	if input.Events != nil {
+		hook.Events = []string{}
+		for _, event := range *input.Events {
+			known := false
+			for _, e := range models.WebhookEvents {
+				if e == event {
+					known = true
+					break
+				}
+			}
+			if !known {
+				return "Unknown event: " + event
+			}
+			hook.Events = append(hook.Events, event)
+		}
+	}
+	if len(hook.Events) == 0 {
+		return "At least one event is required"
+	}
+
+	if input.Active != nil {
+		hook.Active = *input.Active
+	}
+	return ""
+}
+
+func newWebhookSecret() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// CreateWebhookHandler registers a webhook. The signing secret is only
+// returned in this response.
+func CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var input webhookInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	hook := models.Webhook{
+		ID:        primitive.NewObjectID(),
+		Active:    true,
+		CreatedBy: user.Username,
+		CreatedAt: time.Now(),
+	}
+	if msg := input.apply(&hook); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := webhookCollection.InsertOne(r.Context(), hook); err != nil {
+		logFor(r.Context()).Error("Failed to create webhook", "error", err)
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		models.Webhook
+		Secret string `json:"secret"`
+	}{hook, hook.Secret})
+}
+
+// GetWebhooksHandler lists the registered webhooks
+func GetWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentAdmin(w, r); !ok {
+		return
+	}
+
+	hooks := []models.Webhook{}
+	cursor, err := webhookCollection.Find(r.Context(), bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err == nil {
+		err = cursor.All(r.Context(), &hooks)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+func loadWebhook(w http.ResponseWriter, r *http.Request) (models.Webhook, bool) {
+	var hook models.Webhook
+	objectID, err := primitive.ObjectIDFromHex(mux.Vars(r)["webhookId"])
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return hook, false
+	}
+	if err := webhookCollection.FindOne(r.Context(), bson.M{"_id": objectID}).Decode(&hook); err != nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return hook, false
+	}
+	return hook, true
+}
+
+// UpdateWebhookHandler changes the URL, secret, events or active flag
+func UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentAdmin(w, r); !ok {
+		return
+	}
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var input webhookInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if msg := input.apply(&hook); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := webhookCollection.ReplaceOne(r.Context(), bson.M{"_id": hook.ID}, hook); err != nil {
+		logFor(r.Context()).Error("Failed to update webhook", "webhook_id", hook.ID.Hex(), "error", err)
+		http.Error(w, "Failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hook)
+}
+
+// DeleteWebhookHandler removes a webhook together with its delivery log
+func DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentAdmin(w, r); !ok {
+		return
+	}
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := webhookCollection.DeleteOne(r.Context(), bson.M{"_id": hook.ID}); err != nil {
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	if _, err := webhookDeliveryCollection.DeleteMany(r.Context(), bson.M{"webhook_id": hook.ID}); err != nil {
+		logFor(r.Context()).Error("Failed to delete webhook deliveries", "webhook_id", hook.ID.Hex(), "error", err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Webhook deleted successfully"})
+}
+
+// GetWebhookDeliveriesHandler returns the delivery log of a webhook, newest
+// first. ?status= filters by delivery status.
+func GetWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentAdmin(w, r); !ok {
+		return
+	}
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"webhook_id": hook.ID}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(100)
+	cursor, err := webhookDeliveryCollection.Find(r.Context(), filter, opts)
+	if err == nil {
+		err = cursor.All(r.Context(), &deliveries)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// RedeliverWebhookHandler queues a new delivery with the payload of an
+// earlier one
+func RedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := currentAdmin(w, r); !ok {
+		return
+	}
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := primitive.ObjectIDFromHex(mux.Vars(r)["deliveryId"])
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+	var original models.WebhookDelivery
+	err = webhookDeliveryCollection.FindOne(r.Context(), bson.M{"_id": deliveryID, "webhook_id": hook.ID}).Decode(&original)
+	if err != nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	delivery := models.WebhookDelivery{
+		ID:            primitive.NewObjectID(),
+		WebhookID:     hook.ID,
+		SessionID:     original.SessionID,
+		Event:         original.Event,
+		Payload:       original.Payload,
+		Status:        models.DeliveryPending,
+		Attempts:      []models.DeliveryAttempt{},
+		NextAttemptAt: now,
+		RedeliveryOf:  &original.ID,
+		CreatedAt:     now,
+	}
+	if _, err := webhookDeliveryCollection.InsertOne(r.Context(), delivery); err != nil {
+		logFor(r.Context()).Error("Failed to queue redelivery", "error", err)
+		http.Error(w, "Failed to queue redelivery", http.StatusInternalServerError)
+		return
+	}
+	wakeWebhookWorker()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
@@ -2,15 +2,16 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"context"
 	"os"
-	"sort"
+	"strconv"
 
 	"encoding/json"
+	"your-project/models"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -31,23 +32,114 @@ type MeetingClient struct {
 	userID    int
 	username  string
 	avatarURL string
-	joinedAt  time.Time
+	away      bool
+
+	// 通过 Server-Sent Events 订阅的客户端没有 conn
+	id     string
+	stream *eventStream
+
+	// 分组讨论室的客户端只收到该讨论室的消息
+	breakoutID string
+
+	// WebSocket 消息排队后由 writeLoop 发送，广播不会被慢连接拖住
+	outgoing *eventStream
+
+	// 加入房间时的事件序号，之后的事件都已实时发送
+	joinedSeq int64
+
+	logger *slog.Logger
 }
 
-var MeetingClients = make(map[string][]*MeetingClient) // sessionID -> MeetingClients
+// log returns the logger of the connection
+func (client *MeetingClient) log() *slog.Logger {
+	if client.logger != nil {
+		return client.logger
+	}
+	return wsLog.With("session_id", client.sessionID, "user_id", client.userID)
+}
 
-func Broadcast(sessionID string, message interface{}) {
-	for _, client := range MeetingClients[sessionID] {
-		err := client.conn.WriteJSON(message)
-		if err != nil {
-			log.Println("WebSocket write error:", err)
+// room is the key of the room the client is in: its session, or a breakout
+// room of the session
+func (client *MeetingClient) room() string {
+	if client.breakoutID != "" {
+		return breakoutRoom(client.sessionID, client.breakoutID)
+	}
+	return client.sessionID
+}
+
+func (client *MeetingClient) send(message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return client.sendRaw(data)
+}
+
+func (client *MeetingClient) sendRaw(data []byte) error {
+	return client.deliver(0, data)
+}
+
+// deliver writes an encoded message to the client. seq is the room event the
+// message belongs to, or 0 for direct replies.
+func (client *MeetingClient) deliver(seq int64, data []byte) error {
+	if client.stream != nil {
+		return client.stream.push(seq, data)
+	}
+	return client.outgoing.push(seq, data)
+}
+
+// writeLoop sends the queued messages of a WebSocket client. When the queue
+// overflows or a write fails the connection is closed, which ends the read
+// loop; the client reconnects and resumes from the last event it got.
+func (client *MeetingClient) writeLoop() {
+	defer client.conn.Close()
+	for {
+		select {
+		case frame := <-client.outgoing.frames:
+			client.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := client.conn.WriteMessage(websocket.TextMessage, frame.data); err != nil {
+				client.log().Warn("Failed to write message", "seq", frame.seq, "error", err)
+				client.outgoing.close()
+				return
+			}
+		case <-client.outgoing.done:
+			return
+		}
+	}
+}
+
+// Broadcast sends a message to everyone in the session. Each broadcast gets
+// the next sequence number of the room so reconnecting clients can resume;
+// it is returned so the sender can log it.
+func Broadcast(sessionID string, message interface{}) int64 {
+	return publish(sessionID, roomEvent{message: message})
+}
+
+// BroadcastEach sends every client of the session its own message, for
+// events whose content depends on who receives them
+func BroadcastEach(sessionID string, build func(client *MeetingClient) interface{}) int64 {
+	return publish(sessionID, roomEvent{build: build})
+}
+
+// broadcastTransient sends a message that is not numbered or kept for replay,
+// such as typing indicators
+func broadcastTransient(sessionID string, message interface{}) {
+	clients := roomClients(sessionID)
+	broadcastFanout.WithLabelValues("transient").Observe(float64(len(clients)))
+	for _, client := range clients {
+		if err := client.send(message); err != nil {
+			client.log().Warn("Failed to send transient message", "error", err)
+			broadcastWriteErrors.WithLabelValues("transient").Inc()
 		}
 	}
 }
 
+// WebSocketHandler connects a client to the room of a session, or with a
+// breakoutId to one of its breakout rooms
 func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	sessionID := vars["sessionId"]
+	breakoutID := vars["breakoutId"]
 
 	session, err := store.Get(r, "auth-session")
 	if err != nil {
@@ -61,17 +153,6 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 检查用户是否已经在会话中
-	clients := MeetingClients[sessionID]
-	for _, existingClient := range clients {
-		if existingClient.userID == userID {
-			// 关闭现有连接
-			existingClient.conn.Close()
-			removeClient(sessionID, existingClient)
-			break
-		}
-	}
-
 	// 获取用户信息
 	var user struct {
 		Username  string `bson:"username"`
@@ -88,6 +169,21 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 回收站里的会话不能再加入
+	if !sessionLive(r.Context(), sessionID) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	// 只有被分到该讨论室的参与者和主持人可以加入
+	var breakout models.Breakout
+	if breakoutID != "" {
+		var ok bool
+		if breakout, ok = findOpenBreakout(w, sessionID, breakoutID, userID, user.Username); !ok {
+			return
+		}
+	}
+
 	// 设置 CORS headers
 	upgrader.CheckOrigin = func(r *http.Request) bool {
 		// 获取请求的Origin
@@ -109,50 +205,67 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		log.Printf("Rejected WebSocket connection from origin: %s", origin)
+		logFor(r.Context()).Warn("Rejected WebSocket connection", "origin", origin)
 		return false
 	}
 
 	// 升级HTTP连接为WebSocket连接
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		logFor(r.Context()).Warn("WebSocket upgrade failed", "error", err)
 		return
 	}
 	defer conn.Close()
 
 	client := &MeetingClient{
-		conn:      conn,
-		sessionID: sessionID,
-		userID:    userID,
-		username:  user.Username,
-		avatarURL: user.AvatarURL,
-		joinedAt:  time.Now(),
+		conn:       conn,
+		sessionID:  sessionID,
+		userID:     userID,
+		username:   user.Username,
+		avatarURL:  user.AvatarURL,
+		breakoutID: breakoutID,
+		outgoing:   newEventStream(),
 	}
+	client.logger = clientLogger(r, client)
+	client.log().Info("WebSocket connected")
+	go client.writeLoop()
 
-	// 添加新客户端到会话
-	if MeetingClients[sessionID] == nil {
-		MeetingClients[sessionID] = make([]*MeetingClient, 0)
+	// 连接成功消息带上当前事件序号供断线重连时 resume；
+	// 重连时在 URL 里带上 epoch 和 lastSeq，错过的事件会在加入房间前排好队
+	welcome := map[string]interface{}{
+		"type":    "connected",
+		"message": "Successfully connected to session",
+	}
+	if breakoutID != "" {
+		welcome["breakout"] = breakout
+	}
+	var resume *resumePoint
+	if query := r.URL.Query(); query.Has("lastSeq") {
+		lastSeq, err := strconv.ParseInt(query.Get("lastSeq"), 10, 64)
+		if err != nil || lastSeq < 0 {
+			client.log().Warn("Invalid resume position", "lastSeq", query.Get("lastSeq"))
+			client.outgoing.close()
+			return
+		}
+		resume = &resumePoint{epoch: query.Get("epoch"), lastSeq: lastSeq}
 	}
-	MeetingClients[sessionID] = append(MeetingClients[sessionID], client)
-
-	// 广播更新后的参与者列表
-	broadcastParticipantsList(sessionID)
 
+	// 添加新客户端到会话；同一用户可以同时打开多个标签页
+	if err := enterRoom(client, welcome, resume); err != nil {
+		client.log().Warn("Failed to send welcome message", "error", err)
+		client.outgoing.close()
+		return
+	}
 	defer func() {
+		client.outgoing.close()
 		conn.Close()
-		removeClient(sessionID, client)
-		broadcastParticipantsList(sessionID)
+		// 最后一个标签页断开后进入宽限期，期满才算离开
+		leaveRoom(client)
+		client.log().Info("WebSocket disconnected")
 	}()
 
-	// 发送连接成功消息
-	if err := conn.WriteJSON(map[string]interface{}{
-		"type":    "connected",
-		"message": "Successfully connected to session",
-	}); err != nil {
-		log.Printf("Error sending welcome message: %v", err)
-		return
-	}
+	// 广播更新后的参与者列表
+	broadcastParticipantsList(client.room())
 
 	// 设置更合理的超时时间
 	conn.SetReadLimit(1024 * 1024) // 1MB
@@ -166,7 +279,7 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-				log.Printf("WebSocket unexpected close error: %v", err)
+				client.log().Warn("WebSocket closed unexpectedly", "error", err)
 			}
 			break
 		}
@@ -176,82 +289,118 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 		if err := json.Unmarshal(message, &msg); err != nil {
 			continue
 		}
+		touchPresence(client)
 
 		if msg["type"] == "ping" {
 			// 发送 pong 响应
 			pongMsg := map[string]string{"type": "pong"}
-			if err := conn.WriteJSON(pongMsg); err != nil {
-				log.Printf("Error sending pong: %v", err)
+			if err := client.send(pongMsg); err != nil {
+				client.log().Warn("Failed to send pong", "error", err)
 				break
 			}
 			continue
 		}
 
 		// 处理其他消息类型
-		handleWebSocketMessage(sessionID, msg)
-	}
-}
-
-func removeClient(sessionID string, client *MeetingClient) {
-	clients := MeetingClients[sessionID]
-	for i, c := range clients {
-		if c == client {
-			MeetingClients[sessionID] = append(clients[:i], clients[i+1:]...)
-			break
+		client.log().Debug("Message received", "type", msg["type"])
+		if client.breakoutID != "" {
+			handleBreakoutMessage(client, msg)
+		} else {
+			handleWebSocketMessage(client, msg)
 		}
 	}
-	// 如果没有客户端了，清理会话
-	if len(MeetingClients[sessionID]) == 0 {
-		delete(MeetingClients, sessionID)
-	}
 }
 
-func handleWebSocketMessage(sessionID string, msg map[string]interface{}) {
+func handleWebSocketMessage(client *MeetingClient, msg map[string]interface{}) {
+	sessionID := client.sessionID
 	switch msg["type"] {
 	case "joinSession":
-		log.Printf("Client joined session: %s", sessionID)
+		client.log().Debug("Client joined session")
 		// 立即广播更新后的参与者列表
 		broadcastParticipantsList(sessionID)
 	case "summarySubmitted":
-		log.Printf("Summary submitted in session: %s", sessionID)
+		// 只转发给会议室；消息内容来自客户端，summary.submitted 由保存 summary 的服务端代码发出
+		client.log().Info("Summary submitted")
 		Broadcast(sessionID, msg)
 	case "newComment":
-		log.Printf("New comment in session: %s", sessionID)
+		client.log().Debug("New comment broadcast")
 		Broadcast(sessionID, msg)
+	case "openPoll":
+		handleOpenPoll(client, msg)
+	case "vote":
+		handleVote(client, msg)
+	case "closePoll":
+		handleClosePoll(client, msg)
+	case "chat":
+		handleChatMessage(client, msg)
+	case "editChat":
+		handleEditChat(client, msg)
+	case "deleteChat":
+		handleDeleteChat(client, msg)
+	case "typing":
+		handleTyping(client, msg)
+	case "resume":
+		handleResume(client, msg)
+	case "presence":
+		handlePresence(client, msg)
+	case "raiseHand":
+		handleRaiseHand(client)
+	case "lowerHand":
+		handleLowerHand(client)
+	case "callNext":
+		handleCallNext(client, msg)
+	case "yieldFloor":
+		handleYieldFloor(client)
+	case "addCard":
+		handleAddCard(client, msg)
+	case "deleteCard":
+		handleDeleteCard(client, msg)
+	case "retroPhase":
+		handleRetroPhase(client, msg)
+	case "revealCard":
+		handleRevealCard(client, msg)
+	case "groupCard":
+		handleGroupCard(client, msg)
+	case "dot":
+		handleDot(client, msg)
+	case "pokerItem":
+		handlePokerItem(client, msg)
+	case "pokerCurrent":
+		handlePokerCurrent(client, msg)
+	case "estimate":
+		handleEstimate(client, msg)
+	case "revealEstimates":
+		handleRevealEstimates(client, msg)
+	case "revote":
+		handleRevote(client, msg)
+	case "finalEstimate":
+		handleFinalEstimate(client, msg)
 	default:
-		log.Printf("Unknown message type received: %v", msg["type"])
+		client.log().Warn("Unknown message type received", "type", msg["type"])
 	}
 }
 
 func broadcastParticipantsList(sessionID string) {
-	// 使用 map 来确保每个用户只出现一次
-	uniqueParticipants := make(map[int]map[string]interface{})
-
-	for _, client := range MeetingClients[sessionID] {
-		uniqueParticipants[client.userID] = map[string]interface{}{
-			"id":        client.userID,
-			"username":  client.username,
-			"avatarUrl": client.avatarURL,
-			"joinedAt":  client.joinedAt,
+	// 每个用户只出现一次，多个标签页合并为一条
+	participants := participantsSnapshot(sessionID)
+
+	// 举手队列随参与者列表一起广播
+	queue, speaker := handQueueSnapshot(sessionID)
+	for _, participant := range participants {
+		participant["handRaised"] = false
+		for position, hand := range queue {
+			if hand.UserID == participant["id"] {
+				participant["handRaised"] = true
+				participant["queuePosition"] = position + 1
+			}
 		}
 	}
 
-	// 将 map 转换为 slice
-	participants := make([]map[string]interface{}, 0, len(uniqueParticipants))
-	for _, participant := range uniqueParticipants {
-		participants = append(participants, participant)
-	}
-
-	// 按加入时间排序
-	sort.Slice(participants, func(i, j int) bool {
-		iTime := participants[i]["joinedAt"].(time.Time)
-		jTime := participants[j]["joinedAt"].(time.Time)
-		return iTime.Before(jTime)
-	})
-
 	message := map[string]interface{}{
 		"type":         "participantsList",
 		"participants": participants,
+		"handQueue":    queue,
+		"speaker":      speaker,
 	}
 
 	Broadcast(sessionID, message)
@@ -2,11 +2,12 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 	"your-project/handlers"
 
 	"github.com/gorilla/mux"
@@ -18,22 +19,30 @@ import (
 
 func main() {
 	// 尝试加载 .env 文件，仅在本地环境中使用
-	if err := godotenv.Load(); err != nil {
-		log.Println("没有找到 .env 文件，继续使用系统环境变量")
+	envErr := godotenv.Load()
+
+	// 日志级别来自环境变量，所以在加载 .env 之后初始化
+	handlers.InitLogging()
+	if envErr != nil {
+		slog.Info("No .env file found, using the environment")
 	}
 
 	// 连接到 MongoDB
 	clientOptions := options.Client().ApplyURI(os.Getenv("DATABASE_URI"))
+	// 记录每个 MongoDB 命令的耗时
+	clientOptions.SetMonitor(handlers.MongoMonitor())
 	client, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Failed to connect to MongoDB", "error", err)
+		os.Exit(1)
 	}
 
 	err = client.Ping(context.TODO(), nil)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Failed to reach MongoDB", "error", err)
+		os.Exit(1)
 	}
-	log.Println("Connected to MongoDB!")
+	slog.Info("Connected to MongoDB")
 
 	// 将 MongoDB 客户端传递给处理器
 	handlers.SetClient(client)
@@ -55,10 +64,11 @@ func main() {
 	os.Setenv("OAUTH_REDIRECT_URL", redirectURL)
 
 	// 输出重定向 URL 以便调试
-	log.Printf("OAuth Redirect URL: %s", redirectURL)
+	slog.Info("OAuth redirect URL", "url", redirectURL)
 
 	// 初始化 session store
 	handlers.InitStore()
+	handlers.InitTemplates()
 
 	// 初始化 OAuth 配置
 	handlers.InitOAuth()
@@ -66,21 +76,122 @@ func main() {
 	handlers.InitSessionCollection(client)
 	// Initialize minutes collection
 	handlers.InitMinutesCollection(client)
+	// Initialize action item collection
+	handlers.InitActionItemCollection(client)
+	// Initialize decision collection
+	handlers.InitDecisionCollection(client)
+	// Initialize e-mail notifications
+	handlers.InitNotifications(client)
+	// Initialize reaction collection
+	handlers.InitReactionCollection(client)
+	// Initialize webhooks
+	handlers.InitWebhookCollections(client)
+	// Initialize the room event log
+	handlers.InitEventLogCollection(client)
+	// Initialize chat collection
+	handlers.InitChatCollection(client)
+	// Initialize session template collection
+	handlers.InitTemplateCollection(client)
+	// Encrypt GitHub tokens stored before encryption
+	handlers.InitGitHub()
+
+	// 命令行子命令：go run . import [-dry-run] [-facilitator user] file...
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImportCommand(os.Args[2:]))
+	}
+
+	// 定期生成重复会议的后续场次
+	handlers.StartRecurrenceScheduler(time.Hour)
+	// 发送提醒邮件并投递发件箱
+	handlers.StartNotificationWorkers(time.Minute)
+	// 投递 webhook 事件
+	handlers.StartWebhookWorker(30 * time.Second)
+	// 同步已关联 GitHub issue 的状态
+	handlers.StartIssueSync(15 * time.Minute)
+	// 清理回收站中过期的会话
+	handlers.StartTrashPurge(time.Hour)
+	handlers.StartBreakoutTimers(30 * time.Second)
 
 	// 设置路由
 	r := mux.NewRouter()
+	r.Use(handlers.RequestIDMiddleware, handlers.MetricsMiddleware)
+	r.HandleFunc("/metrics", handlers.MetricsHandler).Methods("GET")
 	r.HandleFunc("/ws/sessions/{sessionId}", handlers.WebSocketHandler)
+	r.HandleFunc("/ws/sessions/{sessionId}/breakouts/{breakoutId}", handlers.WebSocketHandler)
+	r.HandleFunc("/api/sessions/{sessionId}/events", handlers.SessionEventsHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/actions", handlers.SessionActionHandler).Methods("POST")
 	r.HandleFunc("/api/user", handlers.UserHandler).Methods("GET")
+	r.HandleFunc("/api/user/notifications", handlers.GetNotificationPreferencesHandler).Methods("GET")
+	r.HandleFunc("/api/user/notifications", handlers.UpdateNotificationPreferencesHandler).Methods("PUT")
+	r.HandleFunc("/api/import", handlers.ImportHandler).Methods("POST")
 	r.HandleFunc("/api/sessions", handlers.CreateSessionHandler).Methods("POST")
 	r.HandleFunc("/api/sessions", handlers.GetSessionsHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/export", handlers.ExportSessionsHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/trash", handlers.GetTrashHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/export", handlers.ExportSessionHandler).Methods("GET")
 	r.HandleFunc("/api/sessions/{sessionId}/start", handlers.StartMeetingHandler).Methods("POST")
 	r.HandleFunc("/api/sessions/{sessionId}/comments", handlers.PostCommentHandler).Methods("POST")
 	r.HandleFunc("/api/sessions/{sessionId}/comments", handlers.GetCommentsHandler).Methods("GET")
 	r.HandleFunc("/api/sessions/{sessionId}", handlers.DeleteSessionHandler).Methods("DELETE")
+	r.HandleFunc("/api/sessions/{sessionId}/archive", handlers.ArchiveSessionHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/unarchive", handlers.UnarchiveSessionHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/restore", handlers.RestoreSessionHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/purge", handlers.PurgeSessionHandler).Methods("DELETE")
 	// Add new routes for meeting minutes
 	r.HandleFunc("/api/sessions/{sessionId}/minutes", handlers.GetMinutesHandler).Methods("GET")
 	r.HandleFunc("/api/sessions/{sessionId}/minutes", handlers.UpdateMinutesHandler).Methods("POST", "PUT")
+	r.HandleFunc("/api/sessions/{sessionId}/minutes/publish", handlers.PublishMinutesHandler).Methods("POST")
 	// r.HandleFunc("/", handlers.HomeHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/action-items", handlers.GetActionItemsHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/action-items", handlers.CreateActionItemHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/action-items/{itemId}", handlers.UpdateActionItemHandler).Methods("PUT")
+	r.HandleFunc("/api/sessions/{sessionId}/action-items/{itemId}", handlers.DeleteActionItemHandler).Methods("DELETE")
+	r.HandleFunc("/api/action-items/mine", handlers.MyActionItemsHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/decisions", handlers.GetSessionDecisionsHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/decisions", handlers.CreateDecisionHandler).Methods("POST")
+	r.HandleFunc("/api/decisions", handlers.SearchDecisionsHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/agenda", handlers.AddAgendaItemHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/agenda/order", handlers.ReorderAgendaHandler).Methods("PUT")
+	r.HandleFunc("/api/sessions/{sessionId}/agenda/advance", handlers.AdvanceAgendaHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/agenda/{itemId}", handlers.UpdateAgendaItemHandler).Methods("PUT")
+	r.HandleFunc("/api/sessions/{sessionId}/agenda/{itemId}/complete", handlers.CompleteAgendaItemHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/schedule", handlers.UpdateScheduleHandler).Methods("PUT")
+	r.HandleFunc("/api/sessions/{sessionId}/invite.ics", handlers.SessionInvitationHandler).Methods("GET")
+	r.HandleFunc("/api/user/calendar-token", handlers.CreateCalendarTokenHandler).Methods("POST")
+	r.HandleFunc("/api/calendar/workspace.ics", handlers.WorkspaceCalendarHandler).Methods("GET")
+	r.HandleFunc("/api/calendar/users/{username}.ics", handlers.UserCalendarHandler).Methods("GET")
+	r.HandleFunc("/api/templates", handlers.GetTemplatesHandler).Methods("GET")
+	r.HandleFunc("/api/templates", handlers.CreateTemplateHandler).Methods("POST")
+	r.HandleFunc("/api/templates/{templateId}", handlers.GetTemplateHandler).Methods("GET")
+	r.HandleFunc("/api/templates/{templateId}", handlers.UpdateTemplateHandler).Methods("PUT")
+	r.HandleFunc("/api/templates/{templateId}", handlers.DeleteTemplateHandler).Methods("DELETE")
+	r.HandleFunc("/api/webhooks", handlers.GetWebhooksHandler).Methods("GET")
+	r.HandleFunc("/api/webhooks", handlers.CreateWebhookHandler).Methods("POST")
+	r.HandleFunc("/api/webhooks/{webhookId}", handlers.UpdateWebhookHandler).Methods("PUT")
+	r.HandleFunc("/api/webhooks/{webhookId}", handlers.DeleteWebhookHandler).Methods("DELETE")
+	r.HandleFunc("/api/webhooks/{webhookId}/deliveries", handlers.GetWebhookDeliveriesHandler).Methods("GET")
+	r.HandleFunc("/api/webhooks/{webhookId}/deliveries/{deliveryId}/redeliver", handlers.RedeliverWebhookHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/settings", handlers.UpdateSessionSettingsHandler).Methods("PUT")
+	r.HandleFunc("/api/sessions/{sessionId}/reactions", handlers.ToggleReactionHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/floor", handlers.GetFloorHistoryHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/polls", handlers.GetPollsHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/retro", handlers.GetRetroHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/retro/action-items", handlers.ConvertRetroCardsHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/poker", handlers.GetPokerHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/breakouts", handlers.GetBreakoutsHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/breakouts", handlers.CreateBreakoutsHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/breakouts/broadcast", handlers.BroadcastToBreakoutsHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/breakouts/close", handlers.CloseBreakoutsHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/estimates.csv", handlers.EstimatesCSVHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/chat", handlers.GetChatHandler).Methods("GET")
+	r.HandleFunc("/api/sessions/{sessionId}/repository", handlers.SetSessionRepositoryHandler).Methods("PUT")
+	r.HandleFunc("/api/sessions/{sessionId}/action-items/{itemId}/issue", handlers.CreateActionItemIssueHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/comments/{commentId}/issue", handlers.CreateCommentIssueHandler).Methods("POST")
+	r.HandleFunc("/api/sessions/{sessionId}/issues/sync", handlers.SyncSessionIssuesHandler).Methods("POST")
+	r.HandleFunc("/api/integrations/github/authorize", handlers.GitHubAuthorizeRepoHandler).Methods("GET")
+	r.HandleFunc("/api/integrations/slack/commands", handlers.SlackCommandHandler).Methods("POST")
+	r.HandleFunc("/api/integrations/slack/interactivity", handlers.SlackInteractivityHandler).Methods("POST")
+	r.HandleFunc("/api/integrations/slack/link", handlers.SlackLinkHandler).Methods("GET")
 	r.HandleFunc("/api/login", handlers.LoginHandler).Methods("GET")
 	r.HandleFunc("/auth/github/callback", handlers.GitHubCallbackHandler).Methods("GET")
 	r.HandleFunc("/logout", handlers.LogoutHandler).Methods("GET")
@@ -108,14 +219,17 @@ func main() {
 		AllowCredentials: true,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
+		// 前端可以读取请求 ID，报告问题时附上
+		ExposedHeaders: []string{"X-Request-ID"},
 	})
 
 	// 使用 CORS 中间件包装你的路由器
 	handler := c.Handler(r)
 
 	// 使用���的 handler 启动服务器
-	log.Printf("Server is running on port %s", port)
+	slog.Info("Server is running", "port", port)
 	if err := http.ListenAndServe(":"+port, handler); err != nil {
-		log.Fatal(err)
+		slog.Error("Server stopped", "error", err)
+		os.Exit(1)
 	}
 }
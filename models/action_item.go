@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ActionItemOpen = "open"
+	ActionItemDone = "done"
+)
+
+// ActionItem is a follow-up task that came out of a session
+type ActionItem struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	SessionID        primitive.ObjectID `bson:"session_id" json:"session_id"`
+	Title            string             `bson:"title" json:"title"`
+	AssigneeID       primitive.ObjectID `bson:"assignee_id,omitempty" json:"assignee_id,omitempty"`
+	AssigneeUsername string             `bson:"assignee_username,omitempty" json:"assignee_username,omitempty"`
+	DueDate          *time.Time         `bson:"due_date,omitempty" json:"due_date,omitempty"`
+	Status           string             `bson:"status" json:"status"`
+	Source           string             `bson:"source" json:"source"` // manual or minutes
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time          `bson:"updated_at" json:"updated_at"`
+	CompletedAt      *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	Issue            *IssueLink         `bson:"issue,omitempty" json:"issue,omitempty"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AgendaItem is one entry of a session's ordered agenda
+type AgendaItem struct {
+	ID              primitive.ObjectID `bson:"_id" json:"_id"`
+	Title           string             `bson:"title" json:"title"`
+	Owner           string             `bson:"owner,omitempty" json:"owner,omitempty"` // participant username
+	AllottedMinutes int                `bson:"allotted_minutes" json:"allotted_minutes"`
+	Notes           string             `bson:"notes" json:"notes"`
+	Completed       bool               `bson:"completed" json:"completed"`
+	CompletedAt     *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
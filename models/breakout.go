@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	BreakoutOpen   = "open"
+	BreakoutClosed = "closed"
+)
+
+// Breakout is a small group split off from a session. Its notes are added
+// to the session's minutes when it closes.
+type Breakout struct {
+	ID             primitive.ObjectID `bson:"_id" json:"_id"`
+	Name           string             `bson:"name" json:"name"`
+	Participants   []string           `bson:"participants" json:"participants"` // usernames
+	Notes          string             `bson:"notes" json:"notes"`
+	NotesUpdatedBy string             `bson:"notes_updated_by,omitempty" json:"notes_updated_by,omitempty"`
+	Status         string             `bson:"status" json:"status"`
+	EndsAt         *time.Time         `bson:"ends_at,omitempty" json:"ends_at,omitempty"` // nil when the facilitator ends it
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	ClosedAt       *time.Time         `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+}
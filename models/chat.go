@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChatMessage is a message in the chat of a session. Deleted messages keep
+// their place in the history without their content.
+type ChatMessage struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	SessionID primitive.ObjectID `bson:"session_id" json:"session_id"`
+	UserID    int                `bson:"user_id" json:"user_id"`
+	Username  string             `bson:"username" json:"username"`
+	AvatarURL string             `bson:"avatar_url" json:"avatar_url"`
+	Content   string             `bson:"content" json:"content"`
+	Mentions  []string           `bson:"mentions,omitempty" json:"mentions,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	EditedAt  *time.Time         `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+	DeletedAt *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+}
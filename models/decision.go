@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Decision is an explicit outcome recorded during a session
+type Decision struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"_id"`
+	SessionID    primitive.ObjectID  `bson:"session_id" json:"session_id"`
+	Statement    string              `bson:"statement" json:"statement"`
+	Rationale    string              `bson:"rationale" json:"rationale"`
+	Tags         []string            `bson:"tags" json:"tags"`
+	AgreedBy     []string            `bson:"agreed_by" json:"agreed_by"`       // participant usernames
+	DissentedBy  []string            `bson:"dissented_by" json:"dissented_by"` // participant usernames
+	Supersedes   *primitive.ObjectID `bson:"supersedes,omitempty" json:"supersedes,omitempty"`
+	SupersededBy *primitive.ObjectID `bson:"superseded_by,omitempty" json:"superseded_by,omitempty"`
+	CreatedBy    string              `bson:"created_by" json:"created_by"`
+	CreatedAt    time.Time           `bson:"created_at" json:"created_at"`
+}
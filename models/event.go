@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RoomEvent is a broadcast to a meeting room kept for clients that resume
+// after a disconnect. Data is the JSON message as sent, including its seq.
+type RoomEvent struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	SessionID string             `bson:"session_id" json:"session_id"`
+	Epoch     string             `bson:"epoch" json:"epoch"`
+	Seq       int64              `bson:"seq" json:"seq"`
+	Data      string             `bson:"data" json:"data"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// SessionExportVersion is bumped whenever the JSON export layout changes in a
+// way that importers need to know about.
+const SessionExportVersion = 1
+
+// SessionExport is the JSON representation of a complete meeting record.
+type SessionExport struct {
+	Version    int           `json:"version"`
+	ExportedAt time.Time     `json:"exported_at"`
+	Session    Session       `json:"session"`
+	Minutes    *Minutes      `json:"minutes,omitempty"`
+	Decisions  []Decision    `json:"decisions,omitempty"`
+	Chat       []ChatMessage `json:"chat,omitempty"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FloorTurn records a participant being called on from the hand-raise queue
+type FloorTurn struct {
+	ID       primitive.ObjectID `bson:"_id" json:"_id"`
+	Username string             `bson:"username" json:"username"`
+	RaisedAt time.Time          `bson:"raised_at" json:"raised_at"`
+	CalledAt time.Time          `bson:"called_at" json:"called_at"`
+	CalledBy string             `bson:"called_by" json:"called_by"`
+	EndedAt  *time.Time         `bson:"ended_at,omitempty" json:"ended_at,omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// IssueLink points to the GitHub issue created from an action item or comment
+type IssueLink struct {
+	Repository string    `bson:"repository" json:"repository"` // owner/name
+	Number     int       `bson:"number" json:"number"`
+	URL        string    `bson:"url" json:"url"`
+	State      string    `bson:"state" json:"state"` // open or closed
+	CreatedBy  string    `bson:"created_by" json:"created_by"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	SyncedAt   time.Time `bson:"synced_at" json:"synced_at"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// NotificationPreferences controls which e-mails a user receives
+type NotificationPreferences struct {
+	Invitations      bool `bson:"invitations" json:"invitations"`
+	Reminders        bool `bson:"reminders" json:"reminders"`
+	MinutesPublished bool `bson:"minutes_published" json:"minutes_published"`
+	ActionItems      bool `bson:"action_items" json:"action_items"`
+	ReminderMinutes  int  `bson:"reminder_minutes" json:"reminder_minutes"` // how long before a session the reminder is sent
+	// stored as an opt-out so preferences saved before chat existed keep mention e-mails on
+	MuteMentions bool `bson:"mute_mentions" json:"mute_mentions"`
+}
+
+// DefaultNotificationPreferences are used until a user saves their own
+func DefaultNotificationPreferences() NotificationPreferences {
+	return NotificationPreferences{
+		Invitations:      true,
+		Reminders:        true,
+		MinutesPublished: true,
+		ActionItems:      true,
+		ReminderMinutes:  15,
+	}
+}
+
+type EmailAttachment struct {
+	Filename    string `bson:"filename" json:"filename"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	Data        []byte `bson:"data" json:"-"`
+}
+
+// OutboxMessage is an e-mail waiting to be delivered by the outbox worker
+type OutboxMessage struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	Kind          string             `bson:"kind" json:"kind"`
+	DedupeKey     string             `bson:"dedupe_key,omitempty" json:"dedupe_key,omitempty"`
+	SessionID     primitive.ObjectID `bson:"session_id,omitempty" json:"session_id,omitempty"`
+	To            string             `bson:"to" json:"to"`
+	Subject       string             `bson:"subject" json:"subject"`
+	TextBody      string             `bson:"text_body" json:"text_body"`
+	HTMLBody      string             `bson:"html_body" json:"html_body"`
+	Attachments   []EmailAttachment  `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	Status        string             `bson:"status" json:"status"`
+	Attempts      int                `bson:"attempts" json:"attempts"`
+	LastError     string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at" json:"next_attempt_at"`
+	LockedAt      *time.Time         `bson:"locked_at,omitempty" json:"locked_at,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	SentAt        *time.Time         `bson:"sent_at,omitempty" json:"sent_at,omitempty"`
+}
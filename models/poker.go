@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	SessionModePoker = "poker"
+
+	PokerDeckFibonacci = "fibonacci"
+	PokerDeckTShirt    = "tshirt"
+	PokerDeckCustom    = "custom"
+
+	PokerVoting    = "voting"    // estimates are hidden
+	PokerRevealed  = "revealed"  // estimates and statistics are shown
+	PokerEstimated = "estimated" // the final estimate is set
+)
+
+// PokerBoard is the board of a session in planning poker mode
+type PokerBoard struct {
+	Deck        string             `bson:"deck" json:"deck"`
+	Cards       []string           `bson:"cards" json:"cards"` // in increasing order
+	Items       []PokerItem        `bson:"items" json:"items"`
+	CurrentItem primitive.ObjectID `bson:"current_item,omitempty" json:"current_item,omitempty"`
+}
+
+// PokerItem is a story being estimated. Votes holds the current round;
+// earlier rounds are kept in Rounds once they are revealed.
+type PokerItem struct {
+	ID          primitive.ObjectID `bson:"_id" json:"_id"`
+	Title       string             `bson:"title" json:"title"`
+	Notes       string             `bson:"notes,omitempty" json:"notes,omitempty"`
+	Status      string             `bson:"status" json:"status"`
+	Round       int                `bson:"round" json:"round"`
+	Votes       []PokerVote        `bson:"votes" json:"votes"`
+	Rounds      []PokerRound       `bson:"rounds" json:"rounds"`
+	Estimate    string             `bson:"estimate,omitempty" json:"estimate,omitempty"`
+	EstimatedAt *time.Time         `bson:"estimated_at,omitempty" json:"estimated_at,omitempty"`
+	CreatedBy   string             `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type PokerVote struct {
+	Voter  string    `bson:"voter" json:"voter"`
+	Card   string    `bson:"card" json:"card"`
+	CastAt time.Time `bson:"cast_at" json:"cast_at"`
+}
+
+// PokerRound is a revealed round of votes on an item
+type PokerRound struct {
+	Round      int         `bson:"round" json:"round"`
+	Votes      []PokerVote `bson:"votes" json:"votes"`
+	RevealedAt time.Time   `bson:"revealed_at" json:"revealed_at"`
+}
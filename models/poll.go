@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PollSingle   = "single"
+	PollMultiple = "multiple"
+	PollRanked   = "ranked"
+
+	PollOpen   = "open"
+	PollClosed = "closed"
+)
+
+// Poll is a quick vote held during a meeting
+type Poll struct {
+	ID        primitive.ObjectID `bson:"_id" json:"_id"`
+	Question  string             `bson:"question" json:"question"`
+	Kind      string             `bson:"kind" json:"kind"` // single, multiple or ranked
+	Options   []string           `bson:"options" json:"options"`
+	Anonymous bool               `bson:"anonymous" json:"anonymous"`
+	Status    string             `bson:"status" json:"status"`
+	CreatedBy string             `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ClosedAt  *time.Time         `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+	Votes     []PollVote         `bson:"votes" json:"votes,omitempty"`
+	Results   []PollTally        `bson:"results,omitempty" json:"results,omitempty"` // frozen when the poll is closed
+}
+
+// PollVote is one participant's ballot. Choices are option indexes, in
+// order of preference for ranked polls. Voter is the username, or an opaque
+// key for anonymous polls.
+type PollVote struct {
+	Voter   string    `bson:"voter" json:"voter,omitempty"`
+	Choices []int     `bson:"choices" json:"choices"`
+	CastAt  time.Time `bson:"cast_at" json:"cast_at"`
+}
+
+// PollTally is the result for one option. Points is the Borda count for
+// ranked polls.
+type PollTally struct {
+	Option string `bson:"option" json:"option"`
+	Votes  int    `bson:"votes" json:"votes"`
+	Points int    `bson:"points,omitempty" json:"points,omitempty"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ReactionOnSummary = "summary"
+	ReactionOnComment = "comment"
+)
+
+// Reaction is one user's emoji on a summary or comment
+type Reaction struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	SessionID  primitive.ObjectID `bson:"session_id" json:"session_id"`
+	TargetType string             `bson:"target_type" json:"target_type"` // summary or comment
+	TargetID   primitive.ObjectID `bson:"target_id" json:"target_id"`
+	Emoji      string             `bson:"emoji" json:"emoji"`
+	Username   string             `bson:"username" json:"username"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// ReactionCount aggregates the reactions with one emoji on a target
+type ReactionCount struct {
+	Emoji     string   `bson:"emoji" json:"emoji"`
+	Count     int      `bson:"count" json:"count"`
+	Usernames []string `bson:"usernames" json:"usernames"`
+}
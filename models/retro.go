@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	SessionModeRetro = "retro"
+
+	RetroPhaseCollect = "collect" // cards are posted face down
+	RetroPhaseReveal  = "reveal"  // the facilitator flips and groups cards
+	RetroPhaseVote    = "vote"    // participants spend their dots
+	RetroPhaseDiscuss = "discuss" // the tally is shown and cards become action items
+)
+
+// RetroBoard is the board of a session in retro mode
+type RetroBoard struct {
+	Columns    []RetroColumn `bson:"columns" json:"columns"`
+	Phase      string        `bson:"phase" json:"phase"`
+	VoteBudget int           `bson:"vote_budget" json:"vote_budget"` // dots per participant
+	Cards      []RetroCard   `bson:"cards" json:"cards"`
+	Votes      []RetroVote   `bson:"votes" json:"votes"`
+}
+
+type RetroColumn struct {
+	ID    primitive.ObjectID `bson:"_id" json:"_id"`
+	Title string             `bson:"title" json:"title"`
+}
+
+// RetroCard is a card on the board. Grouped cards point to the card that
+// heads their group, which collects the votes.
+type RetroCard struct {
+	ID           primitive.ObjectID `bson:"_id" json:"_id"`
+	ColumnID     primitive.ObjectID `bson:"column_id" json:"column_id"`
+	Content      string             `bson:"content" json:"content"`
+	Author       string             `bson:"author" json:"author"`
+	GroupID      primitive.ObjectID `bson:"group_id,omitempty" json:"group_id,omitempty"`
+	Revealed     bool               `bson:"revealed" json:"revealed"`
+	ActionItemID primitive.ObjectID `bson:"action_item_id,omitempty" json:"action_item_id,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// RetroVote is one dot a participant put on a card
+type RetroVote struct {
+	ID     primitive.ObjectID `bson:"_id" json:"_id"`
+	CardID primitive.ObjectID `bson:"card_id" json:"card_id"`
+	Voter  string             `bson:"voter" json:"voter,omitempty"`
+	CastAt time.Time          `bson:"cast_at" json:"cast_at"`
+}
@@ -12,12 +12,46 @@ type User struct {
     AvatarURL string            `json:"avatar_url" bson:"avatar_url"`
 }
 
+// Session lifecycle
+const (
+    SessionDraft     = "draft"
+    SessionScheduled = "scheduled"
+    SessionLive      = "live"
+    SessionEnded     = "ended"
+    SessionArchived  = "archived"
+)
+
 type Session struct {
-    ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id"`  // 改为 _id 而不是 id
-    Name        string             `json:"name"`
-    CreatedAt   time.Time          `json:"created_at"`
-    Participants []Participant     `json:"participants"`
-    Summaries   []Summary          `json:"summaries"`
+    ID                primitive.ObjectID `bson:"_id,omitempty" json:"_id"`  // 改为 _id 而不是 id
+    Name              string             `json:"name"`
+    CreatedAt         time.Time          `json:"created_at"`
+    Participants      []Participant      `json:"participants"`
+    Summaries         []Summary          `json:"summaries"`
+    FacilitatorID     int                `bson:"facilitator_id,omitempty" json:"facilitator_id,omitempty"` // GitHub ID of the creator
+    Agenda            []AgendaItem       `bson:"agenda" json:"agenda"`
+    CurrentAgendaItem primitive.ObjectID `bson:"current_agenda_item,omitempty" json:"current_agenda_item,omitempty"`
+    ScheduledStart    *time.Time         `bson:"scheduled_start,omitempty" json:"scheduled_start,omitempty"`
+    ScheduledEnd      *time.Time         `bson:"scheduled_end,omitempty" json:"scheduled_end,omitempty"`
+    TimeZone          string             `bson:"time_zone,omitempty" json:"time_zone,omitempty"`   // IANA name, e.g. Asia/Shanghai
+    Recurrence        string             `bson:"recurrence,omitempty" json:"recurrence,omitempty"` // RFC 5545 RRULE
+    SeriesID          primitive.ObjectID `bson:"series_id,omitempty" json:"series_id,omitempty"`   // recurring session this occurrence belongs to
+    StartedAt         *time.Time         `bson:"started_at,omitempty" json:"started_at,omitempty"`
+    EndedAt           *time.Time         `bson:"ended_at,omitempty" json:"ended_at,omitempty"`
+    Repository        string             `bson:"repository,omitempty" json:"repository,omitempty"` // GitHub owner/name for issues
+    Polls             []Poll             `bson:"polls,omitempty" json:"polls,omitempty"`
+    FloorHistory      []FloorTurn        `bson:"floor_history,omitempty" json:"floor_history,omitempty"`
+    AnonymousFeedback bool               `bson:"anonymous_feedback,omitempty" json:"anonymous_feedback"` // hide comment authors from non-admins
+    SpeakerTimebox    int                `bson:"speaker_timebox,omitempty" json:"speaker_timebox,omitempty"` // seconds per speaker, 0 for no limit
+    MaxStars          int                `bson:"max_stars,omitempty" json:"max_stars,omitempty"`             // top of the star scale, 10 when unset
+    TemplateID        primitive.ObjectID `bson:"template_id,omitempty" json:"template_id,omitempty"`
+    Mode              string             `bson:"mode,omitempty" json:"mode,omitempty"` // empty for summaries, retro or poker
+    Retro             *RetroBoard        `bson:"retro,omitempty" json:"retro,omitempty"`
+    Poker             *PokerBoard        `bson:"poker,omitempty" json:"poker,omitempty"`
+    Breakouts         []Breakout         `bson:"breakouts,omitempty" json:"breakouts,omitempty"`
+    Status            string             `bson:"status,omitempty" json:"status"`
+    ArchivedAt        *time.Time         `bson:"archived_at,omitempty" json:"archived_at,omitempty"`
+    DeletedAt         *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"` // in the trash until purged
+    DeletedBy         string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
 }
 
 type Participant struct {
@@ -28,10 +62,12 @@ type Participant struct {
 }
 
 type Summary struct {
+    ID             primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
     ParticipantID primitive.ObjectID `json:"participant_id"`
     Content        string             `json:"content"`
     Comments       []Comment          `json:"comments"`
     CreatedAt      time.Time          `json:"created_at"`
+    Reactions      []ReactionCount    `bson:"-" json:"reactions,omitempty"`
 }
 
 type Comment struct {
@@ -42,4 +78,7 @@ type Comment struct {
     Content   string             `json:"content" bson:"content"`
     Stars     int                `json:"stars" bson:"stars"`
     CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+    Issue     *IssueLink         `json:"issue,omitempty" bson:"issue,omitempty"`
+    Anonymous bool               `json:"anonymous,omitempty" bson:"anonymous,omitempty"` // author is kept but only shown to admins
+    Reactions []ReactionCount    `json:"reactions,omitempty" bson:"-"`
 }
\ No newline at end of file
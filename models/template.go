@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SessionTemplate is a reusable meeting format such as a standup or a retro.
+// Sessions created from it start with its agenda, settings, minutes and
+// participants.
+type SessionTemplate struct {
+	ID                primitive.ObjectID   `bson:"_id,omitempty" json:"_id"`
+	Name              string               `bson:"name" json:"name"`
+	Description       string               `bson:"description,omitempty" json:"description,omitempty"`
+	Agenda            []TemplateAgendaItem `bson:"agenda" json:"agenda"`
+	SpeakerTimebox    int                  `bson:"speaker_timebox,omitempty" json:"speaker_timebox,omitempty"` // seconds per speaker
+	AnonymousFeedback bool                 `bson:"anonymous_feedback" json:"anonymous_feedback"`
+	MaxStars          int                  `bson:"max_stars,omitempty" json:"max_stars,omitempty"`
+	MinutesSkeleton   string               `bson:"minutes_skeleton,omitempty" json:"minutes_skeleton,omitempty"`
+	Participants      []string             `bson:"participants" json:"participants"` // usernames
+	CreatedBy         string               `bson:"created_by" json:"created_by"`
+	CreatedAt         time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time            `bson:"updated_at" json:"updated_at"`
+}
+
+// TemplateAgendaItem is an agenda item copied into every session created
+// from a template
+type TemplateAgendaItem struct {
+	Title           string `bson:"title" json:"title"`
+	Owner           string `bson:"owner,omitempty" json:"owner,omitempty"`
+	AllottedMinutes int    `bson:"allotted_minutes" json:"allotted_minutes"`
+	Notes           string `bson:"notes" json:"notes"`
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Webhook events
+const (
+	EventSessionCreated   = "session.created"
+	EventMeetingStarted   = "meeting.started"
+	EventMeetingEnded     = "meeting.ended"
+	EventSummarySubmitted = "summary.submitted"
+	EventCommentPosted    = "comment.posted"
+	EventMinutesUpdated   = "minutes.updated"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to
+var WebhookEvents = []string{
+	EventSessionCreated,
+	EventMeetingStarted,
+	EventMeetingEnded,
+	EventSummarySubmitted,
+	EventCommentPosted,
+	EventMinutesUpdated,
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySending   = "sending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint registered by a workspace admin
+type Webhook struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	URL       string             `bson:"url" json:"url"`
+	Secret    string             `bson:"secret" json:"-"`
+	Events    []string           `bson:"events" json:"events"`
+	Active    bool               `bson:"active" json:"active"`
+	CreatedBy string             `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// DeliveryAttempt records a single HTTP request to a webhook endpoint
+type DeliveryAttempt struct {
+	At           time.Time `bson:"at" json:"at"`
+	ResponseCode int       `bson:"response_code,omitempty" json:"response_code,omitempty"`
+	ResponseBody string    `bson:"response_body,omitempty" json:"response_body,omitempty"`
+	Error        string    `bson:"error,omitempty" json:"error,omitempty"`
+	DurationMS   int64     `bson:"duration_ms" json:"duration_ms"`
+}
+
+// WebhookDelivery is one event queued for, or sent to, a webhook
+type WebhookDelivery struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"_id"`
+	WebhookID     primitive.ObjectID  `bson:"webhook_id" json:"webhook_id"`
+	SessionID     primitive.ObjectID  `bson:"session_id,omitempty" json:"session_id,omitempty"`
+	Event         string              `bson:"event" json:"event"`
+	Payload       string              `bson:"payload" json:"payload"`
+	Status        string              `bson:"status" json:"status"`
+	Attempts      []DeliveryAttempt   `bson:"attempts" json:"attempts"`
+	ResponseCode  int                 `bson:"response_code,omitempty" json:"response_code,omitempty"` // of the last attempt
+	NextAttemptAt time.Time           `bson:"next_attempt_at" json:"next_attempt_at"`
+	LockedAt      *time.Time          `bson:"locked_at,omitempty" json:"-"`
+	RedeliveryOf  *primitive.ObjectID `bson:"redelivery_of,omitempty" json:"redelivery_of,omitempty"`
+	CreatedAt     time.Time           `bson:"created_at" json:"created_at"`
+	DeliveredAt   *time.Time          `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+}